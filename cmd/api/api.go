@@ -3,64 +3,157 @@ package api
 import (
 	"log"
 	"net/http"
+	"os"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/razdacoder/mcwale-api/mailer"
+	"github.com/razdacoder/mcwale-api/services/apikeys"
 	"github.com/razdacoder/mcwale-api/services/appointments"
+	"github.com/razdacoder/mcwale-api/services/audit"
+	"github.com/razdacoder/mcwale-api/services/auth"
+	"github.com/razdacoder/mcwale-api/services/customorders"
+	"github.com/razdacoder/mcwale-api/services/jobs"
+	"github.com/razdacoder/mcwale-api/services/measurements"
+	"github.com/razdacoder/mcwale-api/services/oidc"
 	"github.com/razdacoder/mcwale-api/services/orders"
 	"github.com/razdacoder/mcwale-api/services/products"
+	"github.com/razdacoder/mcwale-api/services/reminders"
+	"github.com/razdacoder/mcwale-api/services/roles"
+	"github.com/razdacoder/mcwale-api/services/staff"
+	"github.com/razdacoder/mcwale-api/services/trash"
 	"github.com/razdacoder/mcwale-api/services/users"
 	"github.com/razdacoder/mcwale-api/utils"
 	"gorm.io/gorm"
 )
 
+// corsOptions lets any origin call the API without credentials, unless
+// CORS_ALLOWED_ORIGINS lists the storefronts. Those origins may also send
+// cookies, which the OpenID Connect callback needs to see its state cookie.
+func corsOptions() cors.Options {
+	options := cors.Options{
+		AllowedOrigins:   []string{"https://*", "http://*"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-API-Key"},
+		ExposedHeaders:   []string{"Link"},
+		AllowCredentials: false,
+		MaxAge:           300, // Maximum value not ignored by any of major browsers
+	}
+
+	var origins []string
+	for _, origin := range strings.Split(os.Getenv("CORS_ALLOWED_ORIGINS"), ",") {
+		if origin = strings.TrimSuffix(strings.TrimSpace(origin), "/"); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	if len(origins) > 0 {
+		options.AllowedOrigins = origins
+		options.AllowCredentials = true
+	}
+	return options
+}
+
 type APIServer struct {
-	addr string
-	db   *gorm.DB
+	addr   string
+	db     *gorm.DB
+	mailer mailer.Mailer
 }
 
 func NewAPISever(addr string, db *gorm.DB) *APIServer {
 	return &APIServer{
-		addr: addr,
-		db:   db,
+		addr:   addr,
+		db:     db,
+		mailer: mailer.New(),
 	}
 }
 
 func (server *APIServer) Run() error {
 	router := chi.NewRouter()
+	router.Use(middleware.RequestID)
 	router.Use(middleware.Logger)
 
-	router.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"https://*", "http://*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
-		AllowCredentials: false,
-		MaxAge:           300, // Maximum value not ignored by any of major browsers
-	}))
+	router.Use(cors.Handler(corsOptions()))
+	router.Get("/.well-known/jwks.json", auth.HandleJWKS)
+
 	v1Router := chi.NewRouter()
 	v1Router.Get("/status", handleHealth)
+	// Audit Log Handlers
+	auditStore := audit.NewStore(server.db)
+	auditLogger := audit.NewLogger(auditStore)
+	auditHandler := audit.NewHandler(auditStore)
+	auditHandler.RegisterRoutes(v1Router)
+
+	// Role Handlers
+	roleStore := roles.NewStore(server.db)
+	auth.UsePermissionStore(roleStore)
+	roleHandler := roles.NewHandler(roleStore, auditLogger)
+	roleHandler.RegisterRoutes(v1Router)
+
+	// API Key Handlers
+	apiKeyStore := apikeys.NewStore(server.db)
+	auth.UseAPIKeyStore(apiKeyStore)
+	apiKeyHandler := apikeys.NewHandler(apiKeyStore, auditLogger)
+	apiKeyHandler.RegisterRoutes(v1Router)
+
 	// Users Handlers
 	userStore := users.NewStore(server.db)
-	userHandler := users.NewHandler(userStore)
+	userHandler := users.NewHandler(userStore, server.mailer, auditLogger)
 	userHandler.RegisterRoutes(v1Router)
 
+	// OpenID Connect Handlers
+	providers, err := oidc.LoadProviders()
+	if err != nil {
+		return err
+	}
+	oidcStore := oidc.NewStore(server.db)
+	oidcHandler := oidc.NewHandler(oidcStore, providers)
+	oidcHandler.RegisterRoutes(v1Router)
+
 	// Product Handlers
 	productStore := products.NewStore(server.db)
-	productHandler := products.NewHandler(productStore)
+	productHandler := products.NewHandler(productStore, auditLogger)
 	productHandler.RegisterRoutes(v1Router)
 
 	// Orders Handlers
 	orderStore := orders.NewStore(server.db)
-	orderHandler := orders.NewHandler(orderStore)
+	orderHandler := orders.NewHandler(orderStore, auditLogger)
 	orderHandler.RegisterRoutes(v1Router)
 
+	// Trash Handlers
+	trashStore := trash.NewStore(server.db)
+	trashHandler := trash.NewHandler(trashStore, auditLogger)
+	trashHandler.RegisterRoutes(v1Router)
+
+	// Staff Handlers
+	staffStore := staff.NewStore(server.db)
+	staffHandler := staff.NewHandler(staffStore, auditLogger)
+	staffHandler.RegisterRoutes(v1Router)
+
+	// Measurement Handlers
+	measurementHandler := measurements.NewHandler(measurements.NewStore(server.db), auditLogger)
+	measurementHandler.RegisterRoutes(v1Router)
+
 	//Appointment Handlers
+	schedule, err := appointments.LoadSchedule()
+	if err != nil {
+		return err
+	}
 	appointmentStore := appointments.NewStore(server.db)
-	appointmentHandler := appointments.NewHandler(appointmentStore)
+	appointmentHandler := appointments.NewHandler(appointmentStore, schedule, server.mailer, auditLogger)
 	appointmentHandler.RegisterRoutes(v1Router)
 
+	// Custom Order Handlers
+	customOrderHandler := customorders.NewHandler(customorders.NewStore(server.db), appointmentHandler, server.mailer, auditLogger)
+	customOrderHandler.RegisterRoutes(v1Router)
+
+	// Background Job Status Handlers
+	jobHandler := jobs.NewHandler(jobs.NewStore(server.db))
+	jobHandler.RegisterRoutes(v1Router)
+	reminderHandler := reminders.NewHandler(reminders.NewStore(server.db))
+	reminderHandler.RegisterRoutes(v1Router)
+
 	router.Mount("/api/v1", v1Router)
 	log.Println("Listening on port ", server.addr)
 	return http.ListenAndServe(server.addr, router)
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/razdacoder/mcwale-api/db"
+	"github.com/razdacoder/mcwale-api/services/trash"
+	"github.com/razdacoder/mcwale-api/services/users"
+	"github.com/razdacoder/mcwale-api/utils"
+)
+
+// Runs periodic housekeeping. Schedule it with cron, e.g. once an hour.
+func main() {
+	err := godotenv.Load()
+	if err != nil {
+		log.Fatal("failed to load .env file")
+	}
+	db, err := db.NewPgDataBase(os.Getenv("DSN"))
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	userStore := users.NewStore(db)
+	due, err := userStore.GetUsersDueForErasure(time.Now())
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, user := range due {
+		if err := userStore.EraseUser(user.ID); err != nil {
+			log.Printf("failed to erase user %s: %v", user.ID, err)
+			continue
+		}
+		log.Printf("erased user %s", user.ID)
+	}
+
+	// Purge records that have sat in the trash past the retention period.
+	retentionDays := utils.ParseStringToInt(os.Getenv("TRASH_RETENTION_DAYS"), 30)
+	cutoff := time.Now().AddDate(0, 0, -retentionDays)
+	trashStore := trash.NewStore(db)
+	purged, err := trashStore.Purge(cutoff)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("purged %d records from the trash", purged)
+
+	deletedUsers, err := trashStore.GetPurgeableUserIDs(cutoff)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, id := range deletedUsers {
+		if err := userStore.EraseUser(id); err != nil {
+			log.Printf("failed to purge user %s: %v", id, err)
+		}
+	}
+	log.Println("Maintenance Complete")
+}
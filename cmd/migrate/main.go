@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
 
@@ -19,6 +20,96 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	db.AutoMigrate(&models.User{}, &models.Category{}, &models.Product{}, &models.Order{}, &models.OrderItem{}, &models.Appointment{})
+	// Roles used to be a fixed enum; they are now rows in the roles table.
+	db.Exec(`ALTER TABLE IF EXISTS users ALTER COLUMN user_role DROP DEFAULT, ALTER COLUMN user_role TYPE text USING user_role::text`)
+	// Appointment dates used to be stored as a bare time of day; keep what we
+	// can by pinning legacy rows to the day they were booked.
+	db.Exec(`DO $$
+BEGIN
+	IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'appointments' AND column_name = 'date' AND data_type = 'time without time zone') THEN
+		ALTER TABLE appointments ALTER COLUMN date TYPE timestamptz USING (created_at::date + date);
+		ALTER TABLE appointments ADD COLUMN IF NOT EXISTS ends_at timestamptz;
+		UPDATE appointments SET ends_at = date + interval '1 hour' WHERE ends_at IS NULL;
+	END IF;
+END $$`)
+	// Deposit orders are marked paid by staff, and voided when their booking
+	// is cancelled.
+	for _, status := range []models.OrderStatus{models.Paid, models.Cancelled} {
+		if err := db.Exec(fmt.Sprintf(`ALTER TYPE order_status ADD VALUE IF NOT EXISTS '%s'`, status)).Error; err != nil {
+			log.Fatal(err)
+		}
+	}
+	db.AutoMigrate(&models.User{}, &models.Role{}, &models.APIKey{}, &models.UserIdentity{}, &models.Address{}, &models.Category{}, &models.Product{}, &models.ProductVariant{}, &models.ProductReview{}, &models.SearchTermCount{}, &models.Order{}, &models.OrderItem{}, &models.StaffMember{}, &models.StaffWorkingHours{}, &models.StaffTimeOff{}, &models.AppointmentType{}, &models.Appointment{}, &models.AppointmentClosure{}, &models.AppointmentReminder{}, &models.WaitlistEntry{}, &models.MeasurementSet{}, &models.MeasurementRevision{}, &models.CustomOrder{}, &models.CustomOrderStage{}, &models.JobRun{}, &models.AuditLog{})
+	for _, role := range models.DefaultRoles {
+		if err := db.Where(models.Role{Name: role.Name}).Attrs(role).FirstOrCreate(&models.Role{}).Error; err != nil {
+			log.Fatal(err)
+		}
+	}
+	// Overlapping bookings with the same staff member are refused by the
+	// database itself. Unassigned bookings all share the nil UUID, and a
+	// cancelled booking gives its slot back.
+	if err := db.Exec(`CREATE EXTENSION IF NOT EXISTS btree_gist`).Error; err != nil {
+		log.Fatal(err)
+	}
+	db.Exec(`ALTER TABLE appointments DROP CONSTRAINT IF EXISTS appointments_no_overlap`)
+	if err := db.Exec(`ALTER TABLE appointments ADD CONSTRAINT appointments_no_overlap EXCLUDE USING gist ((coalesce(staff_id, '00000000-0000-0000-0000-000000000000'::uuid)) WITH =, tstzrange(date, ends_at) WITH &&) WHERE (status <> 'cancelled')`).Error; err != nil {
+		log.Fatal(err)
+	}
+	// Likewise a slot can only be held for one waitlisted customer at a time.
+	db.Exec(`ALTER TABLE waitlist_entries DROP CONSTRAINT IF EXISTS waitlist_holds_no_overlap`)
+	if err := db.Exec(`ALTER TABLE waitlist_entries ADD CONSTRAINT waitlist_holds_no_overlap EXCLUDE USING gist ((coalesce(offered_staff_id, '00000000-0000-0000-0000-000000000000'::uuid)) WITH =, tstzrange(offered_starts_at, offered_ends_at) WITH &&) WHERE (status = 'offered')`).Error; err != nil {
+		log.Fatal(err)
+	}
+	// The audit log is append-only; reject any attempt to rewrite history.
+	for _, statement := range []string{
+		`CREATE OR REPLACE FUNCTION audit_logs_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_logs is append-only';
+END;
+$$ LANGUAGE plpgsql`,
+		`DROP TRIGGER IF EXISTS audit_logs_no_update ON audit_logs`,
+		`CREATE TRIGGER audit_logs_no_update BEFORE UPDATE OR DELETE ON audit_logs FOR EACH ROW EXECUTE FUNCTION audit_logs_append_only()`,
+		`DROP TRIGGER IF EXISTS audit_logs_no_truncate ON audit_logs`,
+		`CREATE TRIGGER audit_logs_no_truncate BEFORE TRUNCATE ON audit_logs FOR EACH STATEMENT EXECUTE FUNCTION audit_logs_append_only()`,
+	} {
+		if err := db.Exec(statement).Error; err != nil {
+			log.Fatal(err)
+		}
+	}
+	// Product search runs on a weighted tsvector kept up to date by
+	// triggers, including when a product's category is renamed, with
+	// trigram indexes to catch misspellings.
+	for _, statement := range []string{
+		`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+		`ALTER TABLE products ADD COLUMN IF NOT EXISTS search_vector tsvector`,
+		`CREATE OR REPLACE FUNCTION products_search_vector() RETURNS trigger AS $$
+BEGIN
+	NEW.search_vector :=
+		setweight(to_tsvector('english', coalesce(NEW.title, '')), 'A') ||
+		setweight(to_tsvector('english', coalesce((SELECT title FROM categories WHERE id = NEW.category_id), '')), 'B') ||
+		setweight(to_tsvector('english', coalesce(NEW.style, '')), 'B') ||
+		setweight(to_tsvector('english', coalesce(NEW.description, '')), 'C');
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql`,
+		`DROP TRIGGER IF EXISTS products_search_vector ON products`,
+		`CREATE TRIGGER products_search_vector BEFORE INSERT OR UPDATE OF title, style, description, category_id ON products FOR EACH ROW EXECUTE FUNCTION products_search_vector()`,
+		`CREATE OR REPLACE FUNCTION categories_search_vector() RETURNS trigger AS $$
+BEGIN
+	UPDATE products SET title = title WHERE category_id = NEW.id;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql`,
+		`DROP TRIGGER IF EXISTS categories_search_vector ON categories`,
+		`CREATE TRIGGER categories_search_vector AFTER UPDATE OF title ON categories FOR EACH ROW WHEN (OLD.title IS DISTINCT FROM NEW.title) EXECUTE FUNCTION categories_search_vector()`,
+		`UPDATE products SET title = title WHERE search_vector IS NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_products_search_vector ON products USING gin (search_vector)`,
+		`CREATE INDEX IF NOT EXISTS idx_products_title_trgm ON products USING gin (title gin_trgm_ops)`,
+		`CREATE INDEX IF NOT EXISTS idx_products_style_trgm ON products USING gin (style gin_trgm_ops)`,
+	} {
+		if err := db.Exec(statement).Error; err != nil {
+			log.Fatal(err)
+		}
+	}
 	log.Println("Migration Complete")
 }
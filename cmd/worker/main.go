@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/joho/godotenv"
+	"github.com/razdacoder/mcwale-api/db"
+	"github.com/razdacoder/mcwale-api/mailer"
+	"github.com/razdacoder/mcwale-api/services/appointments"
+	"github.com/razdacoder/mcwale-api/services/audit"
+	"github.com/razdacoder/mcwale-api/services/jobs"
+	"github.com/razdacoder/mcwale-api/services/reminders"
+)
+
+// Runs the background jobs until interrupted. Any number of workers can
+// run side by side; jobs coordinate through the database.
+func main() {
+	err := godotenv.Load()
+	if err != nil {
+		log.Fatal("failed to load .env file")
+	}
+	db, err := db.NewPgDataBase(os.Getenv("DSN"))
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	schedule, err := appointments.LoadSchedule()
+	if err != nil {
+		log.Fatal(err)
+	}
+	appointmentReminders, err := reminders.New(reminders.NewStore(db), mailer.New(), schedule)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	appointmentHandler := appointments.NewHandler(appointments.NewStore(db), schedule, mailer.New(), audit.NewLogger(audit.NewStore(db)))
+	waitlist, err := appointments.NewWaitlist(appointmentHandler)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	runner := jobs.NewRunner(jobs.NewStore(db))
+	runner.Add(appointmentReminders.Job())
+	runner.Add(waitlist.Job())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	log.Println("Worker started")
+	runner.Start(ctx)
+	log.Println("Worker stopped")
+}
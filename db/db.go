@@ -14,3 +14,9 @@ func NewPgDataBase(dsn string) (*gorm.DB, error) {
 	}
 	return db, nil
 }
+
+// Unscoped is a preload condition that includes soft-deleted rows, for
+// records that must keep showing what they referred to after it is deleted.
+func Unscoped(db *gorm.DB) *gorm.DB {
+	return db.Unscoped()
+}
@@ -0,0 +1,220 @@
+// Package ical writes iCalendar (RFC 5545) files.
+package ical
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	StatusTentative = "TENTATIVE"
+	StatusConfirmed = "CONFIRMED"
+	StatusCancelled = "CANCELLED"
+
+	// MethodPublish shares events; MethodCancel tells a calendar that
+	// already holds them, matched by UID, to remove them.
+	MethodPublish = "PUBLISH"
+	MethodCancel  = "CANCEL"
+
+	localFormat = "20060102T150405"
+	utcFormat   = "20060102T150405Z"
+)
+
+type Event struct {
+	UID          string
+	Sequence     int
+	Start        time.Time
+	End          time.Time
+	Summary      string
+	Description  string
+	Location     string
+	Status       string
+	URL          string
+	Organizer    string // email address
+	Created      time.Time
+	LastModified time.Time
+}
+
+// Calendar is a set of events whose times are written in Location, with a
+// matching VTIMEZONE so clients show them in the studio's local time.
+// Method defaults to MethodPublish.
+type Calendar struct {
+	Name     string
+	Method   string
+	Location *time.Location
+	Events   []Event
+}
+
+func (calendar *Calendar) Bytes() []byte {
+	location := calendar.Location
+	if location == nil {
+		location = time.UTC
+	}
+
+	var buffer bytes.Buffer
+	write := func(name, value string) {
+		writeLine(&buffer, name+":"+value)
+	}
+
+	write("BEGIN", "VCALENDAR")
+	write("VERSION", "2.0")
+	write("PRODID", "-//McWale//Appointments//EN")
+	write("CALSCALE", "GREGORIAN")
+	method := calendar.Method
+	if method == "" {
+		method = MethodPublish
+	}
+	write("METHOD", method)
+	if calendar.Name != "" {
+		write("X-WR-CALNAME", escape(calendar.Name))
+	}
+	write("X-WR-TIMEZONE", location.String())
+	first, last := calendar.years()
+	writeTimezone(&buffer, location, first, last)
+
+	stamp := time.Now().UTC().Format(utcFormat)
+	for _, event := range calendar.Events {
+		write("BEGIN", "VEVENT")
+		write("UID", escape(event.UID))
+		write("DTSTAMP", stamp)
+		write("SEQUENCE", fmt.Sprint(event.Sequence))
+		writeLine(&buffer, fmt.Sprintf("DTSTART;TZID=%s:%s", location.String(), event.Start.In(location).Format(localFormat)))
+		writeLine(&buffer, fmt.Sprintf("DTEND;TZID=%s:%s", location.String(), event.End.In(location).Format(localFormat)))
+		write("SUMMARY", escape(event.Summary))
+		if event.Description != "" {
+			write("DESCRIPTION", escape(event.Description))
+		}
+		if event.Location != "" {
+			write("LOCATION", escape(event.Location))
+		}
+		if event.Status != "" {
+			write("STATUS", event.Status)
+		}
+		if event.URL != "" {
+			write("URL", event.URL)
+		}
+		if event.Organizer != "" {
+			write("ORGANIZER", "mailto:"+event.Organizer)
+		}
+		if !event.Created.IsZero() {
+			write("CREATED", event.Created.UTC().Format(utcFormat))
+		}
+		if !event.LastModified.IsZero() {
+			write("LAST-MODIFIED", event.LastModified.UTC().Format(utcFormat))
+		}
+		write("END", "VEVENT")
+	}
+	write("END", "VCALENDAR")
+
+	return buffer.Bytes()
+}
+
+// years returns the first and last calendar years the events fall in, so
+// the timezone only needs to describe those.
+func (calendar *Calendar) years() (int, int) {
+	if len(calendar.Events) == 0 {
+		return time.Now().Year(), time.Now().Year()
+	}
+	first, last := calendar.Events[0].Start.Year(), calendar.Events[0].End.Year()
+	for _, event := range calendar.Events {
+		first = min(first, event.Start.Year())
+		last = max(last, event.End.Year())
+	}
+	return first, last
+}
+
+// writeTimezone describes location's UTC offsets from the first to the last
+// year given: the offset in force at the start, then each change found as
+// its own observance, which avoids expressing the zone's rules as
+// recurrences.
+func writeTimezone(buffer *bytes.Buffer, location *time.Location, first, last int) {
+	writeLine(buffer, "BEGIN:VTIMEZONE")
+	writeLine(buffer, "TZID:"+location.String())
+
+	start := time.Date(first, time.January, 1, 0, 0, 0, 0, location)
+	writeObservance(buffer, start, offset(start), offset(start))
+
+	end := time.Date(last+1, time.January, 1, 0, 0, 0, 0, location)
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		next := day.AddDate(0, 0, 1)
+		if offset(day) == offset(next) {
+			continue
+		}
+		at := findTransition(day, next)
+		writeObservance(buffer, at, offset(at.Add(-time.Second)), offset(at))
+	}
+	writeLine(buffer, "END:VTIMEZONE")
+}
+
+// findTransition narrows the change of offset between from and to down to
+// the second.
+func findTransition(from, to time.Time) time.Time {
+	before := offset(from)
+	for to.Sub(from) > time.Second {
+		middle := from.Add(to.Sub(from) / 2)
+		if offset(middle) == before {
+			from = middle
+		} else {
+			to = middle
+		}
+	}
+	return to
+}
+
+func writeObservance(buffer *bytes.Buffer, at time.Time, from, to int) {
+	kind := "STANDARD"
+	if at.IsDST() {
+		kind = "DAYLIGHT"
+	}
+	name, _ := at.Zone()
+
+	writeLine(buffer, "BEGIN:"+kind)
+	// DTSTART is the local time the observance begins in, per the old offset.
+	writeLine(buffer, "DTSTART:"+at.UTC().Add(time.Duration(from)*time.Second).Format(localFormat))
+	writeLine(buffer, "TZOFFSETFROM:"+formatOffset(from))
+	writeLine(buffer, "TZOFFSETTO:"+formatOffset(to))
+	writeLine(buffer, "TZNAME:"+escape(name))
+	writeLine(buffer, "END:"+kind)
+}
+
+func offset(t time.Time) int {
+	_, seconds := t.Zone()
+	return seconds
+}
+
+func formatOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds%3600/60)
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+func escape(value string) string {
+	return escaper.Replace(value)
+}
+
+// writeLine ends a content line with CRLF, folding it so that no line is
+// longer than 75 octets without splitting a UTF-8 character.
+func writeLine(buffer *bytes.Buffer, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		buffer.WriteString(line[:cut])
+		buffer.WriteString("\r\n ")
+		line = line[cut:]
+		// Continuation lines start with a space, which counts to the limit.
+		limit = 74
+	}
+	buffer.WriteString(line)
+	buffer.WriteString("\r\n")
+}
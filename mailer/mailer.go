@@ -0,0 +1,143 @@
+package mailer
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+type Message struct {
+	To          string
+	Subject     string
+	Body        string
+	Attachments []Attachment
+}
+
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+type Mailer interface {
+	Send(message Message) error
+}
+
+// New returns an SMTP mailer configured from SMTP_HOST, SMTP_PORT,
+// SMTP_USERNAME, SMTP_PASSWORD and MAIL_FROM. Without SMTP_HOST messages are
+// only logged, which is what local development wants.
+func New() Mailer {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return &logMailer{}
+	}
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	return &smtpMailer{
+		addr:     host + ":" + port,
+		host:     host,
+		username: os.Getenv("SMTP_USERNAME"),
+		password: os.Getenv("SMTP_PASSWORD"),
+		from:     os.Getenv("MAIL_FROM"),
+	}
+}
+
+type smtpMailer struct {
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+}
+
+func (mailer *smtpMailer) Send(message Message) error {
+	var auth smtp.Auth
+	if mailer.username != "" {
+		auth = smtp.PlainAuth("", mailer.username, mailer.password, mailer.host)
+	}
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "From: %s\r\n", mailer.from)
+	fmt.Fprintf(&builder, "To: %s\r\n", message.To)
+	fmt.Fprintf(&builder, "Subject: %s\r\n", message.Subject)
+	builder.WriteString("MIME-Version: 1.0\r\n")
+	if len(message.Attachments) == 0 {
+		builder.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n\r\n")
+		builder.WriteString(message.Body)
+	} else if err := writeMultipart(&builder, message); err != nil {
+		return err
+	}
+
+	return smtp.SendMail(mailer.addr, auth, mailer.from, []string{message.To}, []byte(builder.String()))
+}
+
+// writeMultipart writes the body followed by the attachments as a
+// multipart/mixed message.
+func writeMultipart(builder *strings.Builder, message Message) error {
+	writer := multipart.NewWriter(builder)
+	fmt.Fprintf(builder, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", writer.Boundary())
+
+	part, err := writer.CreatePart(map[string][]string{
+		"Content-Type": {"text/plain; charset=\"utf-8\""},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write([]byte(message.Body)); err != nil {
+		return err
+	}
+
+	for _, attachment := range message.Attachments {
+		part, err := writer.CreatePart(map[string][]string{
+			"Content-Type":              {attachment.ContentType},
+			"Content-Disposition":       {fmt.Sprintf("attachment; filename=%q", attachment.Filename)},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return err
+		}
+		// Base64 lines must stay under the 76 character limit for mail.
+		encoded := base64.StdEncoding.EncodeToString(attachment.Data)
+		for len(encoded) > 76 {
+			if _, err := fmt.Fprintf(part, "%s\r\n", encoded[:76]); err != nil {
+				return err
+			}
+			encoded = encoded[76:]
+		}
+		if _, err := fmt.Fprintf(part, "%s\r\n", encoded); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+type logMailer struct{}
+
+func (mailer *logMailer) Send(message Message) error {
+	log.Printf("mail to %s: %s\n%s", message.To, message.Subject, message.Body)
+	for _, attachment := range message.Attachments {
+		log.Printf("  attached %s (%s, %d bytes)", attachment.Filename, attachment.ContentType, len(attachment.Data))
+	}
+	return nil
+}
+
+// Link builds a link into the storefront from FRONTEND_URL.
+func Link(path string) string {
+	return strings.TrimSuffix(os.Getenv("FRONTEND_URL"), "/") + path
+}
+
+// APILink builds a link straight to this API from API_URL, for things
+// fetched by other software rather than opened in the storefront.
+func APILink(path string) string {
+	base := os.Getenv("API_URL")
+	if base == "" {
+		base = "http://localhost:8000"
+	}
+	return strings.TrimSuffix(base, "/") + path
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Address struct {
+	ID                uuid.UUID `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
+	UserID            uuid.UUID `gorm:"type:uuid;index;not null" json:"-"`
+	User              User      `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	Label             string    `gorm:"type:text" json:"label"`
+	FirstName         string    `gorm:"type:text;not null" json:"first_name"`
+	LastName          string    `gorm:"type:text;not null" json:"last_name"`
+	PhoneNumber       string    `gorm:"type:text;not null" json:"phone_number"`
+	Address1          string    `gorm:"type:text;not null" json:"address_line_1"`
+	Address2          string    `gorm:"type:text" json:"address_line_2"`
+	Town              string    `gorm:"type:text;not null" json:"town"`
+	State             string    `gorm:"type:text;not null" json:"state"`
+	Country           string    `gorm:"type:text;not null" json:"country"`
+	PostalCode        string    `gorm:"type:text;not null" json:"postal_code"`
+	IsDefaultShipping bool      `gorm:"default:false" json:"is_default_shipping"`
+	IsDefaultBilling  bool      `gorm:"default:false" json:"is_default_billing"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// Snapshot copies the address as it is now, so later edits to the address
+// book do not rewrite past orders.
+func (address *Address) Snapshot() AddressSnapshot {
+	return AddressSnapshot{
+		FirstName:   address.FirstName,
+		LastName:    address.LastName,
+		PhoneNumber: address.PhoneNumber,
+		Address1:    address.Address1,
+		Address2:    address.Address2,
+		Town:        address.Town,
+		State:       address.State,
+		Country:     address.Country,
+		PostalCode:  address.PostalCode,
+	}
+}
+
+type AddressSnapshot struct {
+	FirstName   string `gorm:"type:text" json:"first_name"`
+	LastName    string `gorm:"type:text" json:"last_name"`
+	PhoneNumber string `gorm:"type:text" json:"phone_number"`
+	Address1    string `gorm:"type:text" json:"address_line_1"`
+	Address2    string `gorm:"type:text" json:"address_line_2"`
+	Town        string `gorm:"type:text" json:"town"`
+	State       string `gorm:"type:text" json:"state"`
+	Country     string `gorm:"type:text" json:"country"`
+	PostalCode  string `gorm:"type:text" json:"postal_code"`
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type APIKey struct {
+	ID          uuid.UUID      `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
+	Name        string         `gorm:"type:text;not null" json:"name"`
+	Prefix      string         `gorm:"type:text;unique;not null" json:"prefix"`
+	KeyHash     string         `gorm:"type:text;unique;not null" json:"-"`
+	Permissions pq.StringArray `gorm:"type:text[];not null" json:"permissions"`
+	CreatedByID uuid.UUID      `gorm:"type:uuid;index;not null" json:"created_by_id"`
+	CreatedBy   User           `json:"-"`
+	ExpiresAt   *time.Time     `json:"expires_at"`
+	LastUsedAt  *time.Time     `json:"last_used_at"`
+	RevokedAt   *time.Time     `json:"revoked_at"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"-"`
+}
+
+func (key *APIKey) IsActive(now time.Time) bool {
+	if key.RevokedAt != nil {
+		return false
+	}
+	return key.ExpiresAt == nil || key.ExpiresAt.After(now)
+}
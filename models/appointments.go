@@ -6,14 +6,131 @@ import (
 	"github.com/google/uuid"
 )
 
+type AppointmentStatus string
+
+const (
+	AppointmentRequested AppointmentStatus = "requested"
+	AppointmentConfirmed AppointmentStatus = "confirmed"
+	AppointmentCompleted AppointmentStatus = "completed"
+	AppointmentCancelled AppointmentStatus = "cancelled"
+	AppointmentNoShow    AppointmentStatus = "no_show"
+)
+
+// appointmentTransitions lists the statuses an appointment may move to from
+// each status. Completed, cancelled and no-show appointments are final.
+var appointmentTransitions = map[AppointmentStatus][]AppointmentStatus{
+	AppointmentRequested: {AppointmentConfirmed, AppointmentCancelled},
+	AppointmentConfirmed: {AppointmentCompleted, AppointmentCancelled, AppointmentNoShow},
+}
+
+func (status AppointmentStatus) CanTransitionTo(next AppointmentStatus) bool {
+	for _, allowed := range appointmentTransitions[status] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// Appointment is a booked fitting. Sequence goes up each time the booking
+// changes so calendars replace the copy they already hold.
 type Appointment struct {
-	ID          uuid.UUID `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
-	FirstName   string    `gorm:"type:text;not null" json:"first_name"`
-	LastName    string    `gorm:"type:text;not null" json:"last_name"`
-	Email       string    `gorm:"type:text;not null" json:"email"`
-	PhoneNumber string    `gorm:"type:text;not null" json:"phone_number"`
-	Address     string    `gorm:"type:text;not null" json:"address"`
-	Date        time.Time `gorm:"type:time;not null" json:"date"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"-"`
+	ID                uuid.UUID         `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
+	FirstName         string            `gorm:"type:text;not null" json:"first_name"`
+	LastName          string            `gorm:"type:text;not null" json:"last_name"`
+	Email             string            `gorm:"type:text;not null" json:"email"`
+	PhoneNumber       string            `gorm:"type:text;not null" json:"phone_number"`
+	Address           string            `gorm:"type:text;not null" json:"address"`
+	Date              time.Time         `gorm:"type:timestamptz;not null;index" json:"date"`
+	EndsAt            time.Time         `gorm:"type:timestamptz;not null" json:"ends_at"`
+	StaffID           *uuid.UUID        `gorm:"type:uuid;index" json:"staff_id"`
+	Staff             *StaffMember      `gorm:"foreignKey:StaffID" json:"staff,omitempty"`
+	AppointmentTypeID *uuid.UUID        `gorm:"type:uuid;index" json:"appointment_type_id"`
+	AppointmentType   *AppointmentType  `json:"appointment_type,omitempty"`
+	DepositOrderID    *uuid.UUID        `gorm:"type:uuid" json:"deposit_order_id"`
+	DepositOrder      *Order            `json:"deposit_order,omitempty"`
+	Status            AppointmentStatus `gorm:"type:text;not null;default:'requested';index" json:"status"`
+	ConfirmedAt       *time.Time        `json:"confirmed_at"`
+	CancelledAt       *time.Time        `json:"cancelled_at"`
+	Sequence          int               `gorm:"not null;default:0" json:"sequence"`
+	CreatedAt         time.Time         `json:"created_at"`
+	UpdatedAt         time.Time         `json:"-"`
+}
+
+type ClosureKind string
+
+const (
+	Holiday  ClosureKind = "holiday"
+	Blackout ClosureKind = "blackout"
+)
+
+// AppointmentClosure blocks out a period in which no fittings can be booked.
+type AppointmentClosure struct {
+	ID        uuid.UUID   `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
+	Kind      ClosureKind `gorm:"type:text;not null" json:"kind"`
+	StartsAt  time.Time   `gorm:"type:timestamptz;not null;index" json:"starts_at"`
+	EndsAt    time.Time   `gorm:"type:timestamptz;not null" json:"ends_at"`
+	Reason    string      `gorm:"type:text" json:"reason"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"-"`
+}
+
+// AppointmentType is a kind of fitting customers can book, such as a
+// bridal consultation or a pickup fitting. Restricting it to some staff
+// members limits who it can be booked with; with none, anyone can take it.
+type AppointmentType struct {
+	ID              uuid.UUID     `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
+	Name            string        `gorm:"type:text;not null" json:"name"`
+	Description     string        `gorm:"type:text" json:"description"`
+	DurationMinutes int           `gorm:"not null" json:"duration_minutes"`
+	Price           float64       `gorm:"type:decimal(10, 2);not null;default:0" json:"price"`
+	DepositAmount   float64       `gorm:"type:decimal(10, 2);not null;default:0" json:"deposit_amount"`
+	IsActive        bool          `gorm:"not null;default:true" json:"is_active"`
+	Staff           []StaffMember `gorm:"many2many:appointment_type_staff" json:"staff,omitempty"`
+	CreatedAt       time.Time     `json:"created_at"`
+	UpdatedAt       time.Time     `json:"-"`
+}
+
+func (appointmentType *AppointmentType) Duration() time.Duration {
+	return time.Duration(appointmentType.DurationMinutes) * time.Minute
+}
+
+// OfferedBy reports whether the given staff member can take this type.
+func (appointmentType *AppointmentType) OfferedBy(staffID uuid.UUID) bool {
+	if len(appointmentType.Staff) == 0 {
+		return true
+	}
+	for _, member := range appointmentType.Staff {
+		if member.ID == staffID {
+			return true
+		}
+	}
+	return false
+}
+
+type ReminderStatus string
+
+const (
+	ReminderPending ReminderStatus = "pending"
+	ReminderSent    ReminderStatus = "sent"
+	ReminderSkipped ReminderStatus = "skipped"
+	ReminderFailed  ReminderStatus = "failed"
+)
+
+// AppointmentReminder is one reminder email due before an appointment. It
+// is tied to the start time it was scheduled for, so rescheduling leaves
+// the old reminders to be skipped and queues fresh ones.
+type AppointmentReminder struct {
+	ID            uuid.UUID      `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
+	AppointmentID uuid.UUID      `gorm:"type:uuid;not null;uniqueIndex:idx_appointment_reminder" json:"appointment_id"`
+	Appointment   *Appointment   `gorm:"constraint:OnDelete:CASCADE" json:"appointment,omitempty"`
+	OffsetMinutes int            `gorm:"not null;uniqueIndex:idx_appointment_reminder" json:"offset_minutes"`
+	StartsAt      time.Time      `gorm:"type:timestamptz;not null;uniqueIndex:idx_appointment_reminder" json:"starts_at"`
+	DueAt         time.Time      `gorm:"type:timestamptz;not null;index" json:"due_at"`
+	Status        ReminderStatus `gorm:"type:text;not null;default:'pending';index" json:"status"`
+	Attempts      int            `gorm:"not null;default:0" json:"attempts"`
+	LastError     string         `gorm:"type:text" json:"last_error"`
+	SentAt        *time.Time     `json:"sent_at"`
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
 }
@@ -0,0 +1,32 @@
+package models
+
+import "testing"
+
+func TestAppointmentStatusCanTransitionTo(t *testing.T) {
+	tests := []struct {
+		from AppointmentStatus
+		to   AppointmentStatus
+		want bool
+	}{
+		{AppointmentRequested, AppointmentConfirmed, true},
+		{AppointmentRequested, AppointmentCancelled, true},
+		{AppointmentRequested, AppointmentCompleted, false},
+		{AppointmentRequested, AppointmentNoShow, false},
+		{AppointmentConfirmed, AppointmentCompleted, true},
+		{AppointmentConfirmed, AppointmentCancelled, true},
+		{AppointmentConfirmed, AppointmentNoShow, true},
+		{AppointmentConfirmed, AppointmentRequested, false},
+		{AppointmentConfirmed, AppointmentConfirmed, false},
+		{AppointmentCompleted, AppointmentCancelled, false},
+		{AppointmentCancelled, AppointmentConfirmed, false},
+		{AppointmentNoShow, AppointmentCompleted, false},
+		{AppointmentStatus("unknown"), AppointmentConfirmed, false},
+	}
+	for _, test := range tests {
+		t.Run(string(test.from)+" to "+string(test.to), func(t *testing.T) {
+			if got := test.from.CanTransitionTo(test.to); got != test.want {
+				t.Errorf("%s.CanTransitionTo(%s) = %v, want %v", test.from, test.to, got, test.want)
+			}
+		})
+	}
+}
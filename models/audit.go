@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuditLog records who changed what. Rows are append-only; the migration
+// installs a trigger that rejects updates and deletes.
+type AuditLog struct {
+	ID            uuid.UUID  `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
+	ActorUserID   *uuid.UUID `gorm:"type:uuid;index" json:"actor_user_id"`
+	ActorAPIKeyID *uuid.UUID `gorm:"type:uuid" json:"actor_api_key_id"`
+	ActorRole     string     `gorm:"type:text" json:"actor_role"`
+	Action        string     `gorm:"type:text;index;not null" json:"action"`
+	EntityType    string     `gorm:"type:text;index:idx_audit_entity;not null" json:"entity_type"`
+	EntityID      string     `gorm:"type:text;index:idx_audit_entity" json:"entity_id"`
+	Changes       JSONMap    `gorm:"type:jsonb" json:"changes"`
+	Method        string     `gorm:"type:text" json:"method"`
+	Path          string     `gorm:"type:text" json:"path"`
+	IPAddress     string     `gorm:"type:text" json:"ip_address"`
+	UserAgent     string     `gorm:"type:text" json:"user_agent"`
+	RequestID     string     `gorm:"type:text" json:"request_id"`
+	CreatedAt     time.Time  `gorm:"index" json:"created_at"`
+}
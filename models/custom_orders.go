@@ -0,0 +1,116 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type CustomOrderStatus string
+
+const (
+	CustomOrderRequested CustomOrderStatus = "requested"
+	CustomOrderQuoted    CustomOrderStatus = "quoted"
+	CustomOrderAccepted  CustomOrderStatus = "accepted"
+	CustomOrderDeclined  CustomOrderStatus = "declined"
+	CustomOrderCancelled CustomOrderStatus = "cancelled"
+)
+
+// customOrderTransitions lists the statuses a custom order may move to from
+// each status. A quoted order can be quoted again; once accepted it is
+// tracked through its production stages instead.
+var customOrderTransitions = map[CustomOrderStatus][]CustomOrderStatus{
+	CustomOrderRequested: {CustomOrderQuoted, CustomOrderCancelled},
+	CustomOrderQuoted:    {CustomOrderQuoted, CustomOrderAccepted, CustomOrderDeclined, CustomOrderCancelled},
+}
+
+func (status CustomOrderStatus) CanTransitionTo(next CustomOrderStatus) bool {
+	for _, allowed := range customOrderTransitions[status] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+type ProductionStage string
+
+const (
+	StageCutting ProductionStage = "cutting"
+	StageSewing  ProductionStage = "sewing"
+	StageFitting ProductionStage = "fitting"
+	StageReady   ProductionStage = "ready"
+)
+
+var productionStages = []ProductionStage{StageCutting, StageSewing, StageFitting, StageReady}
+
+func (stage ProductionStage) position() int {
+	for i, known := range productionStages {
+		if known == stage {
+			return i
+		}
+	}
+	return -1
+}
+
+// CanMoveTo reports whether a piece at this stage may move to next. Work
+// moves forward, except that a fitting can send it back to sewing for
+// alterations. An empty stage means production has not started.
+func (stage ProductionStage) CanMoveTo(next ProductionStage) bool {
+	if next.position() < 0 {
+		return false
+	}
+	if stage == StageFitting && next == StageSewing {
+		return true
+	}
+	return next.position() > stage.position()
+}
+
+// CustomOrder is a customer's request for a made-to-measure piece in one of
+// a category's styles. Staff quote a price and timeline; accepting the
+// quote raises an order for the balance and a deposit order, after which
+// the piece moves through its production stages.
+type CustomOrder struct {
+	ID              uuid.UUID          `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
+	Reference       string             `gorm:"type:text;unique;not null" json:"reference"`
+	UserID          *uuid.UUID         `gorm:"type:uuid;index" json:"user_id"`
+	FirstName       string             `gorm:"type:text;not null" json:"first_name"`
+	LastName        string             `gorm:"type:text;not null" json:"last_name"`
+	Email           string             `gorm:"type:text;not null;index" json:"email"`
+	PhoneNumber     string             `gorm:"type:text;not null" json:"phone_number"`
+	Address         string             `gorm:"type:text;not null" json:"address"`
+	CategoryID      uuid.UUID          `gorm:"type:uuid;not null;index" json:"category_id"`
+	Category        *Category          `json:"category,omitempty"`
+	Style           string             `gorm:"type:text;not null" json:"style"`
+	ReferenceImages pq.StringArray     `gorm:"type:text[];not null;default:'{}'" json:"reference_images"`
+	Notes           string             `gorm:"type:text" json:"notes"`
+	PreferredDates  pq.StringArray     `gorm:"type:text[];not null;default:'{}'" json:"preferred_dates"`
+	NeededBy        *time.Time         `gorm:"type:date" json:"needed_by"`
+	AppointmentID   *uuid.UUID         `gorm:"type:uuid" json:"appointment_id"`
+	Appointment     *Appointment       `json:"appointment,omitempty"`
+	Status          CustomOrderStatus  `gorm:"type:text;not null;default:'requested';index" json:"status"`
+	QuotedPrice     *float64           `gorm:"type:decimal(10, 2)" json:"quoted_price"`
+	DepositAmount   *float64           `gorm:"type:decimal(10, 2)" json:"deposit_amount"`
+	ReadyBy         *time.Time         `gorm:"type:date" json:"ready_by"`
+	QuoteNotes      string             `gorm:"type:text" json:"quote_notes"`
+	QuotedAt        *time.Time         `json:"quoted_at"`
+	OrderID         *uuid.UUID         `gorm:"type:uuid" json:"order_id"`
+	Order           *Order             `json:"order,omitempty"`
+	DepositOrderID  *uuid.UUID         `gorm:"type:uuid" json:"deposit_order_id"`
+	DepositOrder    *Order             `json:"deposit_order,omitempty"`
+	AcceptedAt      *time.Time         `json:"accepted_at"`
+	Stage           ProductionStage    `gorm:"type:text" json:"stage"`
+	Stages          []CustomOrderStage `gorm:"constraint:OnDelete:CASCADE" json:"stages,omitempty"`
+	CreatedAt       time.Time          `json:"created_at"`
+	UpdatedAt       time.Time          `json:"updated_at"`
+}
+
+// CustomOrderStage records a piece reaching a production stage.
+type CustomOrderStage struct {
+	ID            uuid.UUID       `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
+	CustomOrderID uuid.UUID       `gorm:"type:uuid;not null;index" json:"-"`
+	Stage         ProductionStage `gorm:"type:text;not null" json:"stage"`
+	Note          string          `gorm:"type:text" json:"note"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
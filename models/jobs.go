@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// JobRun records one pass of a background job, for admins to check that
+// the worker is alive and healthy.
+type JobRun struct {
+	ID         uuid.UUID  `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
+	Job        string     `gorm:"type:text;not null;index:idx_job_runs_job_started" json:"job"`
+	Host       string     `gorm:"type:text" json:"host"`
+	StartedAt  time.Time  `gorm:"type:timestamptz;not null;index:idx_job_runs_job_started" json:"started_at"`
+	FinishedAt *time.Time `gorm:"type:timestamptz" json:"finished_at"`
+	Processed  int        `gorm:"not null;default:0" json:"processed"`
+	Failed     int        `gorm:"not null;default:0" json:"failed"`
+	Error      string     `gorm:"type:text" json:"error"`
+}
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type MeasurementUnit string
+
+const (
+	Centimetres MeasurementUnit = "cm"
+	Inches      MeasurementUnit = "in"
+)
+
+// Measurements maps what was measured, such as "bust" or "inseam", to its
+// value. It is stored in a jsonb column.
+type Measurements map[string]float64
+
+func (m *Measurements) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	case nil:
+		*m = nil
+		return nil
+	default:
+		return fmt.Errorf("invalid json")
+	}
+	return json.Unmarshal(data, m)
+}
+
+func (m Measurements) Value() (interface{}, error) {
+	if m == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Convert returns the measurements in another unit.
+func (m Measurements) Convert(from, to MeasurementUnit) Measurements {
+	factor := 1.0
+	switch {
+	case from == Inches && to == Centimetres:
+		factor = 2.54
+	case from == Centimetres && to == Inches:
+		factor = 1 / 2.54
+	}
+	converted := make(Measurements, len(m))
+	for name, value := range m {
+		converted[name] = value * factor
+	}
+	return converted
+}
+
+// MeasurementSet is a named set of a customer's measurements, such as
+// "Everyday" or "Wedding gown". It belongs to a user account when there is
+// one, and otherwise to the email the customer books fittings with.
+// Revisions are never changed: measuring again adds a new one, and the
+// latest revision is the customer's current measurements.
+type MeasurementSet struct {
+	ID        uuid.UUID             `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
+	UserID    *uuid.UUID            `gorm:"type:uuid;index" json:"user_id"`
+	Email     string                `gorm:"type:text;index" json:"email"`
+	Name      string                `gorm:"type:text;not null" json:"name"`
+	Revisions []MeasurementRevision `gorm:"constraint:OnDelete:CASCADE" json:"revisions,omitempty"`
+	Latest    *MeasurementRevision  `gorm:"-" json:"latest,omitempty"`
+	CreatedAt time.Time             `json:"created_at"`
+	UpdatedAt time.Time             `json:"updated_at"`
+}
+
+// MeasurementRevision is one sitting's measurements, recording which staff
+// member took them and at which appointment.
+type MeasurementRevision struct {
+	ID               uuid.UUID       `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
+	MeasurementSetID uuid.UUID       `gorm:"type:uuid;not null;index" json:"measurement_set_id"`
+	Unit             MeasurementUnit `gorm:"type:text;not null" json:"unit"`
+	Values           Measurements    `gorm:"type:jsonb;not null" json:"values"`
+	Notes            string          `gorm:"type:text" json:"notes"`
+	TakenByID        *uuid.UUID      `gorm:"type:uuid" json:"taken_by_id"`
+	TakenBy          *StaffMember    `gorm:"foreignKey:TakenByID" json:"taken_by,omitempty"`
+	AppointmentID    *uuid.UUID      `gorm:"type:uuid;index" json:"appointment_id"`
+	TakenAt          time.Time       `gorm:"type:timestamptz;not null" json:"taken_at"`
+	CreatedAt        time.Time       `json:"created_at"`
+}
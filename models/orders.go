@@ -5,14 +5,17 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 type OrderStatus string
 
 const (
 	Pending   OrderStatus = "pending"
+	Paid      OrderStatus = "paid"
 	Shipped   OrderStatus = "shipped"
 	Delivered OrderStatus = "delivered"
+	Cancelled OrderStatus = "cancelled"
 )
 
 func (e *OrderStatus) Scan(value interface{}) error {
@@ -30,24 +33,28 @@ func (e OrderStatus) Value() (interface{}, error) {
 }
 
 type Order struct {
-	ID          uuid.UUID    `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
-	OrderNumber string       `gorm:"type:text;unique;not null" json:"order_number"`
-	FirstName   string       `gorm:"type:text;not null" json:"first_name"`
-	LastName    string       `gorm:"type:text;not null" json:"last_name"`
-	Email       string       `gorm:"type:text;not null" json:"email"`
-	PhoneNumber string       `gorm:"type:text;not null" json:"phone_number"`
-	Address1    string       `gorm:"type:text;not null" json:"address_line_1"`
-	Address2    string       `gorm:"type:text" json:"address_line_2"`
-	Town        string       `gorm:"type:text;not null" json:"town"`
-	State       string       `gorm:"type:text;not null" json:"state"`
-	Country     string       `gorm:"type:text;not null" json:"country"`
-	PostalCode  string       `gorm:"type:text;not null" json:"postal_code"`
-	OrderNote   string       `gorm:"type:text;not null" json:"order_note"`
-	Status      *OrderStatus `gorm:"type:order_status;default:'pending';not null" json:"status"`
-	Total       float64      `gorm:"type:decimal(10, 2);not null" json:"total"`
-	Items       []OrderItem  `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"items,omitempty"`
-	CreatedAt   time.Time    `json:"created_at"`
-	UpdatedAt   time.Time    `json:"-"`
+	ID                uuid.UUID       `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
+	OrderNumber       string          `gorm:"type:text;unique;not null" json:"order_number"`
+	UserID            *uuid.UUID      `gorm:"type:uuid;index" json:"user_id"`
+	FirstName         string          `gorm:"type:text;not null" json:"first_name"`
+	LastName          string          `gorm:"type:text;not null" json:"last_name"`
+	Email             string          `gorm:"type:text;not null" json:"email"`
+	PhoneNumber       string          `gorm:"type:text;not null" json:"phone_number"`
+	Address1          string          `gorm:"type:text;not null" json:"address_line_1"`
+	Address2          string          `gorm:"type:text" json:"address_line_2"`
+	Town              string          `gorm:"type:text;not null" json:"town"`
+	State             string          `gorm:"type:text;not null" json:"state"`
+	Country           string          `gorm:"type:text;not null" json:"country"`
+	PostalCode        string          `gorm:"type:text;not null" json:"postal_code"`
+	OrderNote         string          `gorm:"type:text;not null" json:"order_note"`
+	ShippingAddressID *uuid.UUID      `gorm:"type:uuid" json:"shipping_address_id"`
+	Billing           AddressSnapshot `gorm:"embedded;embeddedPrefix:billing_" json:"billing_address"`
+	Status            *OrderStatus    `gorm:"type:order_status;default:'pending';not null" json:"status"`
+	Total             float64         `gorm:"type:decimal(10, 2);not null" json:"total"`
+	Items             []OrderItem     `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"items,omitempty"`
+	CreatedAt         time.Time       `json:"created_at"`
+	UpdatedAt         time.Time       `json:"-"`
+	DeletedAt         gorm.DeletedAt  `gorm:"index" json:"-"`
 }
 
 type OrderItem struct {
@@ -5,6 +5,7 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/lib/pq"
+	"gorm.io/gorm"
 )
 
 type Category struct {
@@ -16,20 +17,38 @@ type Category struct {
 	Products  []Product      `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"products,omitempty"`
 	CreatedAt time.Time      `gorm:"auto_now_add" json:"-"`
 	UpdatedAt time.Time      `gorm:"auto_now" json:"-"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
 type Product struct {
-	ID                 uuid.UUID      `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
-	Title              string         `gorm:"type:text;not null" json:"title"`
-	Slug               string         `gorm:"type:text;unique;not null" json:"slug"`
-	Images             pq.StringArray `gorm:"type:text[];not null" json:"images"`
-	Style              string         `gorm:"type:string;not null" json:"style"`
-	IsFeatured         bool           `gorm:"default:false" json:"is_featured"`
-	Price              float64        `gorm:"type:decimal(10, 2)" json:"price"`
-	Description        string         `gorm:"type:text;not null" json:"description"`
-	DiscountPercentage float64        `gorm:"type:decimal(5, 2)" json:"discount_percentage"`
-	CategoryID         uuid.UUID      `gorm:"index" json:"-"`
-	Category           Category       `json:"category,omitempty"`
-	CreatedAt          time.Time      `gorm:"auto_now_add" json:"-"`
-	UpdatedAt          time.Time      `gorm:"auto_now" json:"-"`
+	ID                 uuid.UUID        `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
+	Title              string           `gorm:"type:text;not null" json:"title"`
+	Slug               string           `gorm:"type:text;unique;not null" json:"slug"`
+	Images             pq.StringArray   `gorm:"type:text[];not null" json:"images"`
+	Style              string           `gorm:"type:string;not null" json:"style"`
+	IsFeatured         bool             `gorm:"default:false" json:"is_featured"`
+	Price              float64          `gorm:"type:decimal(10, 2)" json:"price"`
+	Description        string           `gorm:"type:text;not null" json:"description"`
+	DiscountPercentage float64          `gorm:"type:decimal(5, 2)" json:"discount_percentage"`
+	CategoryID         uuid.UUID        `gorm:"index" json:"-"`
+	Category           Category         `json:"category,omitempty"`
+	RatingAverage      float64          `gorm:"type:decimal(3, 2);not null;default:0" json:"rating_average"`
+	RatingCount        int              `gorm:"not null;default:0" json:"rating_count"`
+	Variants           []ProductVariant `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"variants,omitempty"`
+	CreatedAt          time.Time        `gorm:"auto_now_add" json:"-"`
+	UpdatedAt          time.Time        `gorm:"auto_now" json:"-"`
+	DeletedAt          gorm.DeletedAt   `gorm:"index" json:"-"`
+}
+
+// ProductVariant is a size and color a product comes in, with its stock.
+// Products without variants do not track stock and are always available.
+type ProductVariant struct {
+	ID        uuid.UUID `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
+	ProductID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_product_variants_option" json:"-"`
+	Size      string    `gorm:"type:text;not null;uniqueIndex:idx_product_variants_option;index" json:"size"`
+	Color     string    `gorm:"type:text;not null;uniqueIndex:idx_product_variants_option;index" json:"color"`
+	SKU       string    `gorm:"type:text" json:"sku"`
+	Stock     int       `gorm:"not null;default:0;check:stock >= 0" json:"stock"`
+	CreatedAt time.Time `json:"-"`
+	UpdatedAt time.Time `json:"-"`
 }
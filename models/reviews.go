@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type ReviewStatus string
+
+const (
+	ReviewPending  ReviewStatus = "pending"
+	ReviewApproved ReviewStatus = "approved"
+	ReviewRejected ReviewStatus = "rejected"
+)
+
+// ProductReview is a customer's rating of a product. Reviews are only shown,
+// and only count towards the product's rating, once approved. AuthorName is
+// captured when the review is written so the reviewer's account details are
+// never exposed.
+type ProductReview struct {
+	ID               uuid.UUID      `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
+	ProductID        uuid.UUID      `gorm:"type:uuid;not null;uniqueIndex:idx_product_reviews_product_user" json:"product_id"`
+	Product          *Product       `json:"product,omitempty"`
+	UserID           uuid.UUID      `gorm:"type:uuid;not null;uniqueIndex:idx_product_reviews_product_user;index" json:"-"`
+	User             *User          `json:"-"`
+	AuthorName       string         `gorm:"type:text;not null" json:"author_name"`
+	Rating           int            `gorm:"not null;check:rating BETWEEN 1 AND 5" json:"rating"`
+	Title            string         `gorm:"type:text;not null" json:"title"`
+	Body             string         `gorm:"type:text;not null" json:"body"`
+	Photos           pq.StringArray `gorm:"type:text[];not null;default:'{}'" json:"photos"`
+	VerifiedPurchase bool           `gorm:"not null;default:false" json:"verified_purchase"`
+	Status           ReviewStatus   `gorm:"type:text;not null;default:'pending';index" json:"status"`
+	RejectionReason  string         `gorm:"type:text" json:"rejection_reason,omitempty"`
+	ModeratedAt      *time.Time     `json:"moderated_at,omitempty"`
+	Reply            string         `gorm:"type:text" json:"reply,omitempty"`
+	RepliedAt        *time.Time     `json:"replied_at,omitempty"`
+	CreatedAt        time.Time      `gorm:"index" json:"created_at"`
+	UpdatedAt        time.Time      `json:"-"`
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Permissions are expressed as "resource:action" and granted to roles.
+const (
+	PermUsersRead              = "users:read"
+	PermUsersWrite             = "users:write"
+	PermUsersDelete            = "users:delete"
+	PermRolesManage            = "roles:manage"
+	PermAPIKeysManage          = "api_keys:manage"
+	PermAuditRead              = "audit:read"
+	PermJobsRead               = "jobs:read"
+	PermCategoriesWrite        = "categories:write"
+	PermCategoriesDelete       = "categories:delete"
+	PermProductsWrite          = "products:write"
+	PermProductsDelete         = "products:delete"
+	PermOrdersRead             = "orders:read"
+	PermOrdersWrite            = "orders:write"
+	PermOrdersDelete           = "orders:delete"
+	PermAppointmentsRead       = "appointments:read"
+	PermAppointmentsWrite      = "appointments:write"
+	PermAppointmentsDelete     = "appointments:delete"
+	PermAppointmentTypesManage = "appointment_types:manage"
+	PermStaffManage            = "staff:manage"
+	PermMeasurementsRead       = "measurements:read"
+	PermMeasurementsWrite      = "measurements:write"
+	PermCustomOrdersRead       = "custom_orders:read"
+	PermCustomOrdersWrite      = "custom_orders:write"
+	PermReviewsModerate        = "reviews:moderate"
+)
+
+var Permissions = []string{
+	PermUsersRead,
+	PermUsersWrite,
+	PermUsersDelete,
+	PermRolesManage,
+	PermAPIKeysManage,
+	PermAuditRead,
+	PermJobsRead,
+	PermCategoriesWrite,
+	PermCategoriesDelete,
+	PermProductsWrite,
+	PermProductsDelete,
+	PermOrdersRead,
+	PermOrdersWrite,
+	PermOrdersDelete,
+	PermAppointmentsRead,
+	PermAppointmentsWrite,
+	PermAppointmentsDelete,
+	PermAppointmentTypesManage,
+	PermStaffManage,
+	PermMeasurementsRead,
+	PermMeasurementsWrite,
+	PermCustomOrdersRead,
+	PermCustomOrdersWrite,
+	PermReviewsModerate,
+}
+
+func IsValidPermission(permission string) bool {
+	for _, p := range Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultRoles are seeded by the migration. Admin is implicitly granted
+// every permission and does not need an explicit list.
+var DefaultRoles = []Role{
+	{Name: Admin, Description: "Full access to the back office", Permissions: pq.StringArray{}},
+	{Name: Customer, Description: "Storefront customer", Permissions: pq.StringArray{}},
+	{Name: Staff, Description: "Shop assistant", Permissions: pq.StringArray{
+		PermOrdersRead, PermAppointmentsRead, PermAppointmentsWrite, PermMeasurementsRead,
+		PermCustomOrdersRead,
+	}},
+	{Name: Tailor, Description: "Tailor handling fittings", Permissions: pq.StringArray{
+		PermAppointmentsRead, PermAppointmentsWrite, PermMeasurementsRead, PermMeasurementsWrite,
+		PermCustomOrdersRead, PermCustomOrdersWrite,
+	}},
+	{Name: Fulfilment, Description: "Packs and ships orders", Permissions: pq.StringArray{
+		PermOrdersRead, PermOrdersWrite,
+	}},
+	{Name: ContentEditor, Description: "Manages the product catalogue", Permissions: pq.StringArray{
+		PermCategoriesWrite, PermProductsWrite, PermReviewsModerate,
+	}},
+}
+
+type Role struct {
+	ID          uuid.UUID      `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
+	Name        UserRole       `gorm:"type:text;unique;not null" json:"name"`
+	Description string         `gorm:"type:text" json:"description"`
+	Permissions pq.StringArray `gorm:"type:text[];not null;default:'{}'" json:"permissions"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"-"`
+}
+
+func (role *Role) HasPermission(permission string) bool {
+	if role.Name == Admin {
+		return true
+	}
+	for _, p := range role.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
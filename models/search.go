@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// SearchTermCount is how many times a search term was used on a day, kept
+// to surface popular and trending searches.
+type SearchTermCount struct {
+	Term  string    `gorm:"type:text;primaryKey" json:"term"`
+	Day   time.Time `gorm:"type:date;primaryKey;index" json:"day"`
+	Count int64     `gorm:"not null;default:0" json:"count"`
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// StaffMember is someone customers can book a fitting with. It is usually
+// linked to the user account they sign in with. CalendarTokenHash is the hash
+// of the secret in their calendar subscription URL.
+type StaffMember struct {
+	ID                uuid.UUID           `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
+	UserID            *uuid.UUID          `gorm:"type:uuid;uniqueIndex" json:"user_id,omitempty"`
+	DisplayName       string              `gorm:"type:text;not null" json:"display_name"`
+	Bio               string              `gorm:"type:text" json:"bio"`
+	IsActive          bool                `gorm:"not null;default:true" json:"is_active"`
+	WorkingHours      []StaffWorkingHours `gorm:"constraint:OnDelete:CASCADE" json:"working_hours,omitempty"`
+	TimeOff           []StaffTimeOff      `gorm:"constraint:OnDelete:CASCADE" json:"time_off,omitempty"`
+	CalendarTokenHash *string             `gorm:"type:text;uniqueIndex" json:"-"`
+	CreatedAt         time.Time           `json:"created_at"`
+	UpdatedAt         time.Time           `json:"-"`
+}
+
+// StaffWorkingHours is one stretch of a staff member's weekly hours, given as
+// "15:04" clock times in the shop's timezone. A staff member without any
+// working hours follows the shop's business hours.
+type StaffWorkingHours struct {
+	ID            uuid.UUID    `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
+	StaffMemberID uuid.UUID    `gorm:"type:uuid;not null;index" json:"-"`
+	Weekday       time.Weekday `gorm:"not null" json:"weekday"`
+	Opens         string       `gorm:"type:text;not null" json:"opens"`
+	Closes        string       `gorm:"type:text;not null" json:"closes"`
+}
+
+type StaffTimeOff struct {
+	ID            uuid.UUID `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
+	StaffMemberID uuid.UUID `gorm:"type:uuid;not null;index" json:"-"`
+	StartsAt      time.Time `gorm:"type:timestamptz;not null" json:"starts_at"`
+	EndsAt        time.Time `gorm:"type:timestamptz;not null" json:"ends_at"`
+	Reason        string    `gorm:"type:text" json:"reason"`
+	CreatedAt     time.Time `json:"created_at"`
+}
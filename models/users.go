@@ -5,13 +5,18 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 type UserRole string
 
 const (
-	Admin    UserRole = "admin"
-	Customer UserRole = "customer"
+	Admin         UserRole = "admin"
+	Customer      UserRole = "customer"
+	Staff         UserRole = "staff"
+	Tailor        UserRole = "tailor"
+	Fulfilment    UserRole = "fulfilment"
+	ContentEditor UserRole = "content_editor"
 )
 
 func (e *UserRole) Scan(value interface{}) error {
@@ -29,13 +34,27 @@ func (e UserRole) Value() (interface{}, error) {
 }
 
 type User struct {
-	ID        uuid.UUID  `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
-	Firstname string     `gorm:"type:text;not null" json:"first_name"`
-	Lastname  string     `gorm:"type:text;not null" json:"last_name"`
-	Email     string     `gorm:"type:text;unique;not null" json:"email"`
-	UserRole  *UserRole  `gorm:"type:user_role;default:'customer';not null" json:"role"`
-	Password  string     `gorm:"type:text;not null" json:"-"`
-	CreatedAt time.Time  `gorm:"auto_now_add" json:"created_at"`
-	UpdatedAt time.Time  `gorm:"auto_now" json:"updated_at"`
-	DeletedAt *time.Time `gorm:"auto_now_add;default:null" json:"-"`
+	ID                  uuid.UUID      `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
+	Firstname           string         `gorm:"type:text;not null" json:"first_name"`
+	Lastname            string         `gorm:"type:text;not null" json:"last_name"`
+	Email               string         `gorm:"type:text;unique;not null" json:"email"`
+	EmailVerifiedAt     *time.Time     `json:"email_verified_at"`
+	UserRole            *UserRole      `gorm:"type:text;default:'customer';not null" json:"role"`
+	Password            string         `gorm:"type:text;not null" json:"-"`
+	CreatedAt           time.Time      `gorm:"auto_now_add" json:"created_at"`
+	UpdatedAt           time.Time      `gorm:"auto_now" json:"updated_at"`
+	LastLoginAt         *time.Time     `json:"last_login_at"`
+	DeletionScheduledAt *time.Time     `json:"deletion_scheduled_at"`
+	DeletedAt           gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// VerifiedEmail is the user's email once they have proved they own it, or
+// empty. Bookings and orders made without an account are only matched to a
+// user through it, so nobody can claim them by registering with someone
+// else's address.
+func (user *User) VerifiedEmail() string {
+	if user.EmailVerifiedAt == nil {
+		return ""
+	}
+	return user.Email
 }
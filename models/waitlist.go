@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type WaitlistStatus string
+
+const (
+	WaitlistWaiting   WaitlistStatus = "waiting"
+	WaitlistOffered   WaitlistStatus = "offered"
+	WaitlistBooked    WaitlistStatus = "booked"
+	WaitlistLapsed    WaitlistStatus = "lapsed"
+	WaitlistExpired   WaitlistStatus = "expired"
+	WaitlistCancelled WaitlistStatus = "cancelled"
+)
+
+// WaitlistEntry is a customer waiting for a slot between WindowStartsAt and
+// WindowEndsAt. When one opens up it is held for them until OfferExpiresAt;
+// an offer that is not claimed in time lapses and the slot passes to the
+// next customer in line.
+type WaitlistEntry struct {
+	ID                uuid.UUID        `gorm:"primary_key;type:uuid;default:uuid_generate_v4()" json:"id"`
+	FirstName         string           `gorm:"type:text;not null" json:"first_name"`
+	LastName          string           `gorm:"type:text;not null" json:"last_name"`
+	Email             string           `gorm:"type:text;not null;index" json:"email"`
+	PhoneNumber       string           `gorm:"type:text;not null" json:"phone_number"`
+	Address           string           `gorm:"type:text;not null" json:"address"`
+	AppointmentTypeID *uuid.UUID       `gorm:"type:uuid" json:"appointment_type_id"`
+	AppointmentType   *AppointmentType `json:"appointment_type,omitempty"`
+	StaffID           *uuid.UUID       `gorm:"type:uuid" json:"staff_id"`
+	WindowStartsAt    time.Time        `gorm:"type:timestamptz;not null" json:"window_starts_at"`
+	WindowEndsAt      time.Time        `gorm:"type:timestamptz;not null" json:"window_ends_at"`
+	Status            WaitlistStatus   `gorm:"type:text;not null;default:'waiting';index" json:"status"`
+	OfferedStartsAt   *time.Time       `gorm:"type:timestamptz" json:"offered_starts_at"`
+	OfferedEndsAt     *time.Time       `gorm:"type:timestamptz" json:"offered_ends_at"`
+	OfferedStaffID    *uuid.UUID       `gorm:"type:uuid" json:"offered_staff_id"`
+	OfferExpiresAt    *time.Time       `gorm:"type:timestamptz" json:"offer_expires_at"`
+	AppointmentID     *uuid.UUID       `gorm:"type:uuid" json:"appointment_id"`
+	CreatedAt         time.Time        `gorm:"index" json:"created_at"`
+	UpdatedAt         time.Time        `json:"-"`
+}
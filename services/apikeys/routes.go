@@ -0,0 +1,127 @@
+package apikeys
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/razdacoder/mcwale-api/models"
+	"github.com/razdacoder/mcwale-api/services/audit"
+	"github.com/razdacoder/mcwale-api/services/auth"
+	"github.com/razdacoder/mcwale-api/utils"
+)
+
+type Handler struct {
+	store APIKeyStore
+	audit audit.Recorder
+}
+
+func NewHandler(store APIKeyStore, recorder audit.Recorder) *Handler {
+	return &Handler{
+		store: store,
+		audit: recorder,
+	}
+}
+
+func apiKeysRouter(handler *Handler) chi.Router {
+	router := chi.NewRouter()
+	router.Use(auth.IsLoggedIn, auth.RequirePermission(models.PermAPIKeysManage))
+
+	router.Get("/", handler.handleGetAPIKeys)
+	router.Post("/", handler.handleCreateAPIKey)
+	router.Delete("/{id}", handler.handleRevokeAPIKey)
+
+	return router
+}
+
+func (handler *Handler) RegisterRoutes(router chi.Router) {
+	router.Mount("/api-keys", apiKeysRouter(handler))
+}
+
+func (handler *Handler) handleGetAPIKeys(writer http.ResponseWriter, request *http.Request) {
+	keys, err := handler.store.GetAPIKeys()
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, keys)
+}
+
+func (handler *Handler) handleCreateAPIKey(writer http.ResponseWriter, request *http.Request) {
+	principal, ok := auth.PrincipalFromContext(request.Context())
+	if !ok || principal.IsAPIKey() {
+		utils.WriteError(writer, http.StatusForbidden, fmt.Errorf("api keys can only be created by a signed in user"))
+		return
+	}
+
+	var payload CreateAPIKeyPayload
+	if err := utils.ParseJSON(request, &payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	for _, permission := range payload.Permissions {
+		if !models.IsValidPermission(permission) {
+			utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("unknown permission %s", permission))
+			return
+		}
+		// A key can never do more than the user who created it.
+		allowed, err := principal.Can(permission)
+		if err != nil {
+			utils.WriteError(writer, http.StatusInternalServerError, err)
+			return
+		}
+		if !allowed {
+			utils.WriteError(writer, http.StatusForbidden, fmt.Errorf("you cannot grant %s", permission))
+			return
+		}
+	}
+
+	if payload.ExpiresAt != nil && payload.ExpiresAt.Before(time.Now()) {
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("expires_at must be in the future"))
+		return
+	}
+
+	key, prefix, err := auth.GenerateAPIKey()
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	apiKey := models.APIKey{
+		Name:        payload.Name,
+		Prefix:      prefix,
+		KeyHash:     auth.HashAPIKey(key),
+		Permissions: payload.Permissions,
+		CreatedByID: principal.UserID,
+		ExpiresAt:   payload.ExpiresAt,
+	}
+	if err := handler.store.CreateAPIKey(&apiKey); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	handler.audit.Record(request, "api_key.create", "api_key", apiKey.ID.String(), nil, apiKey)
+
+	// The plain key is only ever returned here.
+	utils.WriteJSON(writer, http.StatusCreated, CreatedAPIKey{APIKey: apiKey, Key: key})
+}
+
+func (handler *Handler) handleRevokeAPIKey(writer http.ResponseWriter, request *http.Request) {
+	id := chi.URLParam(request, "id")
+	if err := handler.store.RevokeAPIKey(id); err != nil {
+		utils.WriteError(writer, http.StatusNotFound, err)
+		return
+	}
+	handler.audit.Record(request, "api_key.revoke", "api_key", id, nil, nil)
+
+	utils.WriteJSON(writer, http.StatusOK, map[string]string{"message": "API Key Revoked"})
+}
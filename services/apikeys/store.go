@@ -0,0 +1,53 @@
+package apikeys
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/razdacoder/mcwale-api/models"
+	"gorm.io/gorm"
+)
+
+type Store struct {
+	db *gorm.DB
+}
+
+func NewStore(db *gorm.DB) *Store {
+	return &Store{
+		db: db,
+	}
+}
+
+func (store *Store) GetAPIKeys() ([]models.APIKey, error) {
+	var keys []models.APIKey
+	result := store.db.Order("created_at DESC").Find(&keys)
+	return keys, result.Error
+}
+
+func (store *Store) CreateAPIKey(key *models.APIKey) error {
+	result := store.db.Create(key)
+	return result.Error
+}
+
+func (store *Store) RevokeAPIKey(id string) error {
+	results := store.db.Model(&models.APIKey{}).Where("id = ? AND revoked_at IS NULL", id).Update("revoked_at", time.Now())
+	if results.Error != nil {
+		return results.Error
+	}
+	if results.RowsAffected == 0 {
+		return fmt.Errorf("api key not found")
+	}
+	return nil
+}
+
+func (store *Store) GetAPIKeyByHash(hash string) (*models.APIKey, error) {
+	var key models.APIKey
+	result := store.db.Model(&models.APIKey{}).Preload("CreatedBy").Where("key_hash = ?", hash).First(&key)
+	return &key, result.Error
+}
+
+func (store *Store) TouchAPIKey(id uuid.UUID, usedAt time.Time) error {
+	results := store.db.Model(&models.APIKey{}).Where("id = ?", id).UpdateColumn("last_used_at", usedAt)
+	return results.Error
+}
@@ -0,0 +1,27 @@
+package apikeys
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/razdacoder/mcwale-api/models"
+)
+
+type APIKeyStore interface {
+	GetAPIKeys() ([]models.APIKey, error)
+	CreateAPIKey(key *models.APIKey) error
+	RevokeAPIKey(id string) error
+	GetAPIKeyByHash(hash string) (*models.APIKey, error)
+	TouchAPIKey(id uuid.UUID, usedAt time.Time) error
+}
+
+type CreateAPIKeyPayload struct {
+	Name        string     `json:"name" validate:"required"`
+	Permissions []string   `json:"permissions" validate:"required,min=1"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
+
+type CreatedAPIKey struct {
+	models.APIKey
+	Key string `json:"key"`
+}
@@ -0,0 +1,184 @@
+package appointments
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/razdacoder/mcwale-api/models"
+)
+
+var (
+	ErrStaffNotFound     = errors.New("staff member not found")
+	ErrStaffNotQualified = errors.New("staff member does not offer this appointment type")
+)
+
+// calendar is something that can be booked: a staff member or, while no
+// staff have been set up, the shop as a whole.
+type calendar struct {
+	staffID *uuid.UUID
+	hours   map[time.Weekday][]Window
+	closed  []Period
+	booked  []models.Appointment
+}
+
+// calendars loads every bookable calendar for [from, to). When staffID is
+// given only that staff member's calendar is returned, and when kind is
+// given only staff offering that appointment type are included.
+func (handler *Handler) calendars(from, to time.Time, staffID *uuid.UUID, kind *models.AppointmentType) ([]calendar, error) {
+	closures, err := handler.store.GetClosures(from, to)
+	if err != nil {
+		return nil, err
+	}
+	closed := make([]Period, 0, len(closures))
+	for _, closure := range closures {
+		closed = append(closed, Period{StartsAt: closure.StartsAt, EndsAt: closure.EndsAt})
+	}
+
+	booked, err := handler.store.GetAppointmentsBetween(from.Add(-handler.schedule.Buffer), to.Add(handler.schedule.Buffer))
+	if err != nil {
+		return nil, err
+	}
+	// A slot held for someone on the waitlist is as good as booked. Holds
+	// keep their entry's id so claiming one can drop it again.
+	holds, err := handler.store.GetActiveHolds(from.Add(-handler.schedule.Buffer), to.Add(handler.schedule.Buffer), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	for _, hold := range holds {
+		booked = append(booked, models.Appointment{
+			ID:      hold.ID,
+			Date:    *hold.OfferedStartsAt,
+			EndsAt:  *hold.OfferedEndsAt,
+			StaffID: hold.OfferedStaffID,
+		})
+	}
+
+	staff, err := handler.store.GetActiveStaff(from, to)
+	if err != nil {
+		return nil, err
+	}
+	if staffID == nil && len(staff) == 0 {
+		return []calendar{{hours: handler.schedule.Hours, closed: closed, booked: booked}}, nil
+	}
+
+	calendars := []calendar{}
+	for _, member := range staff {
+		if staffID != nil && member.ID != *staffID {
+			continue
+		}
+		if kind != nil && !kind.OfferedBy(member.ID) {
+			if staffID != nil {
+				return nil, ErrStaffNotQualified
+			}
+			continue
+		}
+		calendars = append(calendars, handler.staffCalendar(member, closed, booked))
+	}
+	if staffID != nil && len(calendars) == 0 {
+		return nil, ErrStaffNotFound
+	}
+	return calendars, nil
+}
+
+func (handler *Handler) staffCalendar(member models.StaffMember, closed []Period, booked []models.Appointment) calendar {
+	id := member.ID
+	entry := calendar{staffID: &id, hours: handler.schedule.Hours}
+
+	if len(member.WorkingHours) > 0 {
+		entry.hours = map[time.Weekday][]Window{}
+		for _, hours := range member.WorkingHours {
+			opens, err := parseClock(hours.Opens)
+			if err != nil {
+				continue
+			}
+			closes, err := parseClock(hours.Closes)
+			if err != nil {
+				continue
+			}
+			entry.hours[hours.Weekday] = append(entry.hours[hours.Weekday], Window{Opens: opens, Closes: closes})
+		}
+	}
+
+	entry.closed = append(entry.closed, closed...)
+	for _, timeOff := range member.TimeOff {
+		entry.closed = append(entry.closed, Period{StartsAt: timeOff.StartsAt, EndsAt: timeOff.EndsAt})
+	}
+
+	// Bookings made before any staff were set up belong to nobody in
+	// particular, so they keep every staff member busy.
+	for _, appointment := range booked {
+		if appointment.StaffID == nil || *appointment.StaffID == id {
+			entry.booked = append(entry.booked, appointment)
+		}
+	}
+	return entry
+}
+
+// mergeSlots combines the open slots of each calendar on the given day,
+// noting which staff members are free for each one.
+func (handler *Handler) mergeSlots(day time.Time, length time.Duration, calendars []calendar, now time.Time) []Slot {
+	byStart := map[int64]*Slot{}
+	for _, entry := range calendars {
+		for _, slot := range handler.schedule.Slots(day, length, entry.hours, entry.closed, entry.booked, now) {
+			merged, ok := byStart[slot.StartsAt.Unix()]
+			if !ok {
+				merged = &Slot{StartsAt: slot.StartsAt, EndsAt: slot.EndsAt}
+				byStart[slot.StartsAt.Unix()] = merged
+			}
+			if entry.staffID != nil {
+				merged.StaffIDs = append(merged.StaffIDs, *entry.staffID)
+			}
+		}
+	}
+
+	slots := make([]Slot, 0, len(byStart))
+	for _, slot := range byStart {
+		slots = append(slots, *slot)
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i].StartsAt.Before(slots[j].StartsAt) })
+	return slots
+}
+
+// pickCalendar returns the calendar to book startsAt on, preferring whoever
+// has the fewest bookings that day. It returns false if nobody is free.
+func (handler *Handler) pickCalendar(startsAt time.Time, length time.Duration, calendars []calendar, now time.Time) (calendar, bool) {
+	var chosen calendar
+	found := false
+	for _, entry := range calendars {
+		if !handler.schedule.IsOpen(startsAt, length, entry.hours, entry.closed, entry.booked, now) {
+			continue
+		}
+		if !found || len(entry.booked) < len(chosen.booked) {
+			chosen = entry
+			found = true
+		}
+	}
+	return chosen, found
+}
+
+// withoutAppointment drops a booking from each calendar so it does not
+// block the slot it is being moved to.
+func withoutAppointment(calendars []calendar, id uuid.UUID) []calendar {
+	result := make([]calendar, 0, len(calendars))
+	for _, entry := range calendars {
+		booked := make([]models.Appointment, 0, len(entry.booked))
+		for _, appointment := range entry.booked {
+			if appointment.ID != id {
+				booked = append(booked, appointment)
+			}
+		}
+		entry.booked = booked
+		result = append(result, entry)
+	}
+	return result
+}
+
+// slotLength is how long a booking of the given type takes.
+func (handler *Handler) slotLength(kind *models.AppointmentType) time.Duration {
+	if kind == nil || kind.DurationMinutes <= 0 {
+		return handler.schedule.SlotLength
+	}
+	return kind.Duration()
+}
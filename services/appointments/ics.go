@@ -0,0 +1,107 @@
+package appointments
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+	"os"
+	"strings"
+
+	"github.com/razdacoder/mcwale-api/ical"
+	"github.com/razdacoder/mcwale-api/mailer"
+	"github.com/razdacoder/mcwale-api/models"
+)
+
+const calendarContentType = "text/calendar; charset=utf-8"
+
+// customerEvent describes an appointment for the customer's own calendar,
+// linking back to where they can change it.
+func (handler *Handler) customerEvent(appointment *models.Appointment) ical.Event {
+	event := handler.event(appointment)
+	event.Summary = "McWale fitting"
+	if appointment.AppointmentType != nil {
+		event.Summary = "McWale " + appointment.AppointmentType.Name
+	}
+	// Staff is only loaded with the appointment, so it may be stale after a
+	// change of staff member.
+	if appointment.Staff != nil && appointment.StaffID != nil && appointment.Staff.ID == *appointment.StaffID {
+		event.Description = "With " + appointment.Staff.DisplayName
+	}
+	if link, err := ManageLink(appointment); err == nil {
+		event.URL = link
+	}
+	return event
+}
+
+// staffEvent describes an appointment for the calendar of whoever is taking
+// it, with the customer's contact details.
+func (handler *Handler) staffEvent(appointment *models.Appointment) ical.Event {
+	event := handler.event(appointment)
+	event.Summary = fmt.Sprintf("Fitting with %s %s", appointment.FirstName, appointment.LastName)
+	if appointment.AppointmentType != nil {
+		event.Summary = fmt.Sprintf("%s with %s %s", appointment.AppointmentType.Name, appointment.FirstName, appointment.LastName)
+	}
+	event.Description = strings.Join([]string{
+		"Phone: " + appointment.PhoneNumber,
+		"Email: " + appointment.Email,
+		"Status: " + string(appointment.Status),
+	}, "\n")
+	return event
+}
+
+func (handler *Handler) event(appointment *models.Appointment) ical.Event {
+	status := ical.StatusConfirmed
+	switch appointment.Status {
+	case models.AppointmentRequested:
+		status = ical.StatusTentative
+	case models.AppointmentCancelled:
+		status = ical.StatusCancelled
+	}
+
+	return ical.Event{
+		UID:          appointment.ID.String() + "@mcwale",
+		Sequence:     appointment.Sequence,
+		Start:        appointment.Date,
+		End:          appointment.EndsAt,
+		Location:     handler.schedule.StudioAddress,
+		Status:       status,
+		Created:      appointment.CreatedAt,
+		LastModified: appointment.UpdatedAt,
+	}
+}
+
+func (handler *Handler) calendarFile(name string, events ...ical.Event) []byte {
+	calendar := ical.Calendar{Name: name, Location: handler.schedule.Location, Events: events}
+	return calendar.Bytes()
+}
+
+// calendarAttachment is the appointment as an .ics file for emails, so the
+// customer can add it to, or update it in, their calendar. A cancelled
+// appointment is sent as a cancellation of the same event, with the
+// sequence bumped when it was cancelled, so calendars remove it.
+func (handler *Handler) calendarAttachment(appointment *models.Appointment) []mailer.Attachment {
+	method := ical.MethodPublish
+	if appointment.Status == models.AppointmentCancelled {
+		method = ical.MethodCancel
+	}
+	event := handler.customerEvent(appointment)
+	if from, err := mail.ParseAddress(os.Getenv("MAIL_FROM")); err == nil {
+		event.Organizer = from.Address
+	}
+
+	calendar := ical.Calendar{Name: "McWale", Method: method, Location: handler.schedule.Location, Events: []ical.Event{event}}
+	return []mailer.Attachment{{
+		Filename:    "fitting.ics",
+		ContentType: calendarContentType + "; method=" + method,
+		Data:        calendar.Bytes(),
+	}}
+}
+
+func writeCalendar(writer http.ResponseWriter, filename string, data []byte) {
+	writer.Header().Set("Content-Type", calendarContentType)
+	if filename != "" {
+		writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	}
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(data)
+}
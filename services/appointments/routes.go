@@ -1,21 +1,49 @@
 package appointments
 
 import (
+	"errors"
 	"fmt"
+	"log"
+	"math"
 	"net/http"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/razdacoder/mcwale-api/ical"
+	"github.com/razdacoder/mcwale-api/mailer"
+	"github.com/razdacoder/mcwale-api/models"
+	"github.com/razdacoder/mcwale-api/services/audit"
+	"github.com/razdacoder/mcwale-api/services/auth"
 	"github.com/razdacoder/mcwale-api/utils"
 )
 
+// maxAvailabilityDays caps how far a single availability query can look.
+const maxAvailabilityDays = 31
+
+// feedHistoryDays is how far back staff calendar feeds go.
+const feedHistoryDays = 30
+
+// purposeManageAppointment marks the tokens emailed to customers that let
+// them reschedule or cancel a single appointment without signing in.
+const purposeManageAppointment = "appointment_manage"
+
 type Handler struct {
-	store *Store
+	store    *Store
+	schedule *Schedule
+	mailer   mailer.Mailer
+	audit    audit.Recorder
 }
 
-func NewHandler(store *Store) *Handler {
+func NewHandler(store *Store, schedule *Schedule, mailer mailer.Mailer, recorder audit.Recorder) *Handler {
 	return &Handler{
-		store: store,
+		store:    store,
+		schedule: schedule,
+		mailer:   mailer,
+		audit:    recorder,
 	}
 }
 
@@ -23,12 +51,50 @@ func appointmentsRoues(handler *Handler) chi.Router {
 	router := chi.NewRouter()
 
 	router.Route("/", func(router chi.Router) {
-		router.Get("/", handler.HandleGetAllAppointments)
-		router.Post("/", handler.HandleCrateAppointment)
+		router.With(auth.IsLoggedIn, auth.RequirePermission(models.PermAppointmentsRead)).Get("/", handler.HandleGetAllAppointments)
+		router.With(auth.IsLoggedIn).Get("/mine", handler.HandleGetMyAppointments)
+		router.With(auth.OptionalLogin).Post("/", handler.HandleCrateAppointment)
+		router.Get("/availability", handler.HandleGetAvailability)
+	})
+
+	router.Route("/closures", func(router chi.Router) {
+		router.Use(auth.IsLoggedIn)
+		router.With(auth.RequirePermission(models.PermAppointmentsRead)).Get("/", handler.HandleGetClosures)
+		router.With(auth.RequirePermission(models.PermAppointmentsWrite)).Post("/", handler.HandleCreateClosure)
+		router.With(auth.RequirePermission(models.PermAppointmentsWrite)).Delete("/{closureID}", handler.HandleDeleteClosure)
+	})
+
+	router.Route("/types", func(router chi.Router) {
+		manage := auth.RequirePermission(models.PermAppointmentTypesManage)
+		router.Get("/", handler.HandleGetAppointmentTypes)
+		router.Get("/{typeID}", handler.HandleGetAppointmentType)
+		router.With(auth.IsLoggedIn, manage).Post("/", handler.HandleCreateAppointmentType)
+		router.With(auth.IsLoggedIn, manage).Patch("/{typeID}", handler.HandleUpdateAppointmentType)
+		router.With(auth.IsLoggedIn, manage).Delete("/{typeID}", handler.HandleDeactivateAppointmentType)
+	})
+
+	router.Route("/waitlist", func(router chi.Router) {
+		router.With(auth.OptionalLogin).Post("/", handler.HandleJoinWaitlist)
+		router.With(auth.IsLoggedIn, auth.RequirePermission(models.PermAppointmentsRead)).Get("/", handler.HandleGetWaitlist)
+		router.Get("/hold/{token}", handler.HandleGetHold)
+		router.Post("/hold/{token}/claim", handler.HandleClaimHold)
+		router.Get("/{token}", handler.HandleGetManagedWaitlistEntry)
+		router.Post("/{token}/leave", handler.HandleLeaveWaitlist)
+	})
+
+	router.Get("/feeds/{token}.ics", handler.HandleGetStaffFeed)
+
+	router.Route("/manage/{token}", func(router chi.Router) {
+		router.Get("/", handler.HandleGetManagedAppointment)
+		router.Post("/cancel", handler.HandleCancelManagedAppointment)
+		router.Post("/reschedule", handler.HandleRescheduleManagedAppointment)
 	})
 
 	router.Route("/{id}", func(route chi.Router) {
-		route.Get("/", handler.HandleSingleAppointment)
+		route.With(auth.IsLoggedIn).Get("/", handler.HandleSingleAppointment)
+		route.With(auth.OptionalLogin).Get("/calendar.ics", handler.HandleGetAppointmentCalendar)
+		route.With(auth.IsLoggedIn, auth.RequirePermission(models.PermAppointmentsWrite)).Patch("/status", handler.HandleUpdateAppointmentStatus)
+		route.With(auth.IsLoggedIn, auth.RequirePermission(models.PermAppointmentsWrite)).Put("/staff", handler.HandleReassignAppointment)
 	})
 
 	return router
@@ -38,8 +104,142 @@ func (handler *Handler) RegisterRoutes(router chi.Router) {
 	router.Mount("/appointments", appointmentsRoues(handler))
 }
 
+// HandleGetAllAppointments lists appointments for the back office, a page
+// at a time. It filters by from and to dates (YYYY-MM-DD, inclusive),
+// status, staff_id and q, which searches customers' names, emails and phone
+// numbers. With view=day or view=week it instead returns the day or week
+// (Monday to Sunday) containing ?date, grouped by date.
 func (handler *Handler) HandleGetAllAppointments(writer http.ResponseWriter, request *http.Request) {
-	appointments, err := handler.store.GetAllAppointments()
+	query := request.URL.Query()
+	filter := AppointmentFilter{
+		Status: query.Get("status"),
+		Search: strings.TrimSpace(query.Get("q")),
+	}
+	if value := query.Get("staff_id"); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid staff_id"))
+			return
+		}
+		filter.StaffID = &id
+	}
+
+	switch view := query.Get("view"); view {
+	case "day", "week":
+		handler.writeCalendarView(writer, view, query.Get("date"), filter)
+		return
+	case "", "list":
+	default:
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("view must be list, day or week"))
+		return
+	}
+
+	for param, target := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+		date, err := time.ParseInLocation(time.DateOnly, value, handler.schedule.Location)
+		if err != nil {
+			utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("%s must be a date (YYYY-MM-DD)", param))
+			return
+		}
+		if param == "to" {
+			// The to date is inclusive.
+			date = date.AddDate(0, 0, 1)
+		}
+		*target = &date
+	}
+
+	page := utils.ParseStringToInt(query.Get("page"), 0)
+	perPage := utils.ParseStringToInt(os.Getenv("PER_PAGE"), 10)
+	if page == 0 {
+		page = 1
+	}
+	filter.Offset = (page - 1) * perPage
+	filter.Limit = perPage
+
+	appointments, total, err := handler.store.GetAppointments(filter)
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError,
+			fmt.Errorf("internal server error"))
+		return
+	}
+
+	pages := int(math.Ceil(float64(total) / float64(perPage)))
+	utils.WriteJSON(writer, http.StatusOK, map[string]any{"results": len(appointments), "page": page, "pages": pages, "total": total, "data": appointments})
+}
+
+// writeCalendarView writes every appointment matching filter on the day or
+// week containing date, defaulting to today, with an entry for each day
+// even when it has none.
+func (handler *Handler) writeCalendarView(writer http.ResponseWriter, view string, date string, filter AppointmentFilter) {
+	start := handler.schedule.Day(time.Now())
+	if date != "" {
+		parsed, err := time.ParseInLocation(time.DateOnly, date, handler.schedule.Location)
+		if err != nil {
+			utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("date must be a date (YYYY-MM-DD)"))
+			return
+		}
+		start = parsed
+	}
+	length := 1
+	if view == "week" {
+		start = start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7))
+		length = 7
+	}
+	end := start.AddDate(0, 0, length)
+	filter.From = &start
+	filter.To = &end
+
+	appointments, total, err := handler.store.GetAppointments(filter)
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError,
+			fmt.Errorf("internal server error"))
+		return
+	}
+
+	days := make([]AppointmentDay, length)
+	byDate := map[string]*AppointmentDay{}
+	for i := range days {
+		days[i] = AppointmentDay{Date: start.AddDate(0, 0, i).Format(time.DateOnly), Appointments: []models.Appointment{}}
+		byDate[days[i].Date] = &days[i]
+	}
+	for _, appointment := range appointments {
+		if day, ok := byDate[appointment.Date.In(handler.schedule.Location).Format(time.DateOnly)]; ok {
+			day.Appointments = append(day.Appointments, appointment)
+		}
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, map[string]any{
+		"view":  view,
+		"from":  days[0].Date,
+		"to":    days[length-1].Date,
+		"total": total,
+		"days":  days,
+	})
+}
+
+// HandleGetMyAppointments lists the appointments booked with the signed in
+// customer's email address, once they have verified it.
+func (handler *Handler) HandleGetMyAppointments(writer http.ResponseWriter, request *http.Request) {
+	principal, _ := auth.PrincipalFromContext(request.Context())
+	if principal.IsAPIKey() {
+		utils.WriteError(writer, http.StatusForbidden, fmt.Errorf("forbidden"))
+		return
+	}
+
+	email, err := handler.store.GetVerifiedEmail(principal.UserID)
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("user not found"))
+		return
+	}
+	if email == "" {
+		utils.WriteJSON(writer, http.StatusOK, []models.Appointment{})
+		return
+	}
+
+	appointments, err := handler.store.GetCustomerAppointments(email)
 	if err != nil {
 		utils.WriteError(writer, http.StatusInternalServerError,
 			fmt.Errorf("internal server error"))
@@ -61,16 +261,92 @@ func (handler *Handler) HandleCrateAppointment(writer http.ResponseWriter, reque
 		utils.WriteError(writer, http.StatusUnprocessableEntity, errors)
 		return
 	}
-	err := handler.store.CreateNewAppointments(payload)
+	appointment, deposit, err := handler.Book(request, payload)
 	if err != nil {
-		utils.WriteError(writer, http.StatusInternalServerError,
-			fmt.Errorf("internal server error"))
+		status := BookingErrorStatus(err)
+		if status == http.StatusInternalServerError {
+			err = fmt.Errorf("internal server error")
+		}
+		utils.WriteError(writer, status, err)
 		return
 	}
 
-	utils.WriteJSON(writer, http.StatusOK, map[string]string{"message": "Appointment Booked"})
+	response := map[string]string{"message": "Appointment Booked", "appointment_id": appointment.ID.String()}
+	if deposit != nil {
+		// The deposit is paid like any other order, using its order number.
+		response["deposit_order_number"] = deposit.OrderNumber
+	}
+	utils.WriteJSON(writer, http.StatusOK, response)
+}
+
+// Book books an appointment the way a customer booking online would: with
+// whoever is free at the time, raising a deposit order when the type asks
+// for one, and emailing the customer the details.
+func (handler *Handler) Book(request *http.Request, payload CreateAppointmentPayload) (*models.Appointment, *models.Order, error) {
+	return handler.book(request, payload, uuid.Nil)
+}
+
+// book is Book, ignoring the booking or waitlist hold with the given id
+// when checking the slot is free.
+func (handler *Handler) book(request *http.Request, payload CreateAppointmentPayload, ignore uuid.UUID) (*models.Appointment, *models.Order, error) {
+	var kind *models.AppointmentType
+	if payload.AppointmentTypeID != nil {
+		found, err := handler.store.GetAppointmentType(payload.AppointmentTypeID.String())
+		if err != nil || !found.IsActive {
+			return nil, nil, ErrTypeNotFound
+		}
+		kind = found
+	}
+
+	day := handler.schedule.Day(payload.Date)
+	calendars, err := handler.calendars(day, day.AddDate(0, 0, 1), payload.StaffID, kind)
+	if err != nil {
+		return nil, nil, err
+	}
+	length := handler.slotLength(kind)
+	chosen, ok := handler.pickCalendar(payload.Date, length, withoutAppointment(calendars, ignore), time.Now())
+	if !ok {
+		return nil, nil, ErrSlotUnavailable
+	}
+
+	appointment := &models.Appointment{
+		FirstName:         payload.FirstName,
+		LastName:          payload.LastName,
+		Email:             payload.Email,
+		PhoneNumber:       payload.PhoneNumber,
+		Address:           payload.Address,
+		Date:              payload.Date,
+		EndsAt:            payload.Date.Add(length),
+		StaffID:           chosen.staffID,
+		AppointmentTypeID: payload.AppointmentTypeID,
+	}
+	deposit := handler.depositOrder(request, appointment, kind)
+
+	appointment, err = handler.store.CreateNewAppointments(appointment, deposit)
+	if err != nil {
+		return nil, nil, err
+	}
+	handler.audit.Record(request, "appointment.create", "appointment", appointment.ID.String(), nil, appointment)
+	appointment.AppointmentType = kind
+	handler.sendManageLink(appointment, "Your fitting request has been received.")
+
+	return appointment, deposit, nil
+}
+
+// BookingErrorStatus is the HTTP status for an error returned by Book.
+func BookingErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrTypeNotFound), errors.Is(err, ErrStaffNotFound), errors.Is(err, ErrStaffNotQualified):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrSlotUnavailable), errors.Is(err, ErrSlotTaken):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
 }
 
+// HandleSingleAppointment shows an appointment to staff who can read
+// appointments, or to the customer it was booked for.
 func (handler *Handler) HandleSingleAppointment(writer http.ResponseWriter, request *http.Request) {
 	id := chi.URLParam(request, "id")
 	if id == "" {
@@ -80,10 +356,722 @@ func (handler *Handler) HandleSingleAppointment(writer http.ResponseWriter, requ
 	}
 	app, err := handler.store.GetSingleAppointment(id)
 	if err != nil {
-		utils.WriteError(writer, http.StatusInternalServerError,
-			err)
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("appointment not found"))
 		return
 	}
 
+	principal, _ := auth.PrincipalFromContext(request.Context())
+	if allowed, _ := principal.Can(models.PermAppointmentsRead); !allowed {
+		email := ""
+		if !principal.IsAPIKey() {
+			email, _ = handler.store.GetVerifiedEmail(principal.UserID)
+		}
+		// Other customers' appointments are reported as missing rather than
+		// forbidden so their ids cannot be probed.
+		if email == "" || email != app.Email {
+			utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("appointment not found"))
+			return
+		}
+	}
+
 	utils.WriteJSON(writer, http.StatusOK, app)
 }
+
+// HandleGetAvailability lists the open slots for each day between the from
+// and to dates (YYYY-MM-DD, inclusive), defaulting to the coming week. Each
+// slot names the staff free to take it; staff_id narrows it to one person
+// and type_id sizes the slots for, and limits staff to, that type.
+func (handler *Handler) HandleGetAvailability(writer http.ResponseWriter, request *http.Request) {
+	from, to, err := handler.parseDateRange(request)
+	if err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	var staffID *uuid.UUID
+	if value := request.URL.Query().Get("staff_id"); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid staff_id"))
+			return
+		}
+		staffID = &id
+	}
+
+	var kind *models.AppointmentType
+	if value := request.URL.Query().Get("type_id"); value != "" {
+		found, err := handler.store.GetAppointmentType(value)
+		if err != nil || !found.IsActive {
+			utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("appointment type not found"))
+			return
+		}
+		kind = found
+	}
+
+	end := to.AddDate(0, 0, 1)
+	calendars, err := handler.calendars(from, end, staffID, kind)
+	if errors.Is(err, ErrStaffNotFound) || errors.Is(err, ErrStaffNotQualified) {
+		utils.WriteError(writer, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, fmt.Errorf("internal server error"))
+		return
+	}
+
+	now := time.Now()
+	length := handler.slotLength(kind)
+	days := []Day{}
+	for day := from; day.Before(end); day = day.AddDate(0, 0, 1) {
+		days = append(days, Day{
+			Date:  day.Format(time.DateOnly),
+			Slots: handler.mergeSlots(day, length, calendars, now),
+		})
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, map[string]any{
+		"timezone":     handler.schedule.Location.String(),
+		"slot_minutes": int(length.Minutes()),
+		"days":         days,
+	})
+}
+
+func (handler *Handler) parseDateRange(request *http.Request) (time.Time, time.Time, error) {
+	query := request.URL.Query()
+	from := handler.schedule.Day(time.Now())
+	if value := query.Get("from"); value != "" {
+		date, err := time.ParseInLocation(time.DateOnly, value, handler.schedule.Location)
+		if err != nil {
+			return from, from, fmt.Errorf("from must be a date (YYYY-MM-DD)")
+		}
+		from = date
+	}
+
+	to := from.AddDate(0, 0, 6)
+	if value := query.Get("to"); value != "" {
+		date, err := time.ParseInLocation(time.DateOnly, value, handler.schedule.Location)
+		if err != nil {
+			return from, to, fmt.Errorf("to must be a date (YYYY-MM-DD)")
+		}
+		to = date
+	}
+
+	if to.Before(from) {
+		return from, to, fmt.Errorf("to must not be before from")
+	}
+	if to.After(from.AddDate(0, 0, maxAvailabilityDays-1)) {
+		return from, to, fmt.Errorf("date range cannot exceed %d days", maxAvailabilityDays)
+	}
+	return from, to, nil
+}
+
+func (handler *Handler) HandleGetClosures(writer http.ResponseWriter, request *http.Request) {
+	from, to, err := handler.parseDateRange(request)
+	if err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	closures, err := handler.store.GetClosures(from, to.AddDate(0, 0, 1))
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, closures)
+}
+
+func (handler *Handler) HandleCreateClosure(writer http.ResponseWriter, request *http.Request) {
+	var payload CreateClosurePayload
+	if err := utils.ParseJSON(request, &payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	closure := &models.AppointmentClosure{
+		Kind:     models.ClosureKind(payload.Kind),
+		StartsAt: payload.StartsAt,
+		EndsAt:   payload.EndsAt,
+		Reason:   payload.Reason,
+	}
+	if closure.Kind == models.Holiday {
+		day, err := time.ParseInLocation(time.DateOnly, payload.Date, handler.schedule.Location)
+		if err != nil {
+			utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("date must be a date (YYYY-MM-DD)"))
+			return
+		}
+		closure.StartsAt = day
+		closure.EndsAt = day.AddDate(0, 0, 1)
+	}
+	if !closure.EndsAt.After(closure.StartsAt) {
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("ends_at must be after starts_at"))
+		return
+	}
+
+	if err := handler.store.CreateClosure(closure); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	handler.audit.Record(request, "appointment_closure.create", "appointment_closure", closure.ID.String(), nil, closure)
+
+	utils.WriteJSON(writer, http.StatusCreated, closure)
+}
+
+func (handler *Handler) HandleDeleteClosure(writer http.ResponseWriter, request *http.Request) {
+	id := chi.URLParam(request, "closureID")
+	if err := handler.store.DeleteClosure(id); err != nil {
+		utils.WriteError(writer, http.StatusNotFound, err)
+		return
+	}
+	handler.audit.Record(request, "appointment_closure.delete", "appointment_closure", id, nil, nil)
+
+	utils.WriteJSON(writer, http.StatusNoContent, nil)
+}
+
+// HandleReassignAppointment moves a booking to another staff member, who
+// must be free at the booked time.
+func (handler *Handler) HandleReassignAppointment(writer http.ResponseWriter, request *http.Request) {
+	id := chi.URLParam(request, "id")
+	var payload ReassignAppointmentPayload
+	if err := utils.ParseJSON(request, &payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	appointment, err := handler.store.GetSingleAppointment(id)
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, err)
+		return
+	}
+	if !isOpenStatus(appointment.Status) {
+		utils.WriteError(writer, http.StatusConflict, fmt.Errorf("a %s appointment cannot be reassigned", appointment.Status))
+		return
+	}
+
+	day := handler.schedule.Day(appointment.Date)
+	calendars, err := handler.calendars(day, day.AddDate(0, 0, 1), &payload.StaffID, appointment.AppointmentType)
+	if errors.Is(err, ErrStaffNotFound) || errors.Is(err, ErrStaffNotQualified) {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	// The booking being moved must not count against its new staff member,
+	// and an admin may still rearrange a fitting that has already started.
+	entry := withoutAppointment(calendars, appointment.ID)[0]
+	if !handler.schedule.IsOpen(appointment.Date, appointment.EndsAt.Sub(appointment.Date), entry.hours, entry.closed, entry.booked, time.Time{}) {
+		utils.WriteError(writer, http.StatusConflict, fmt.Errorf("staff member is not available at this time"))
+		return
+	}
+
+	before := *appointment
+	if err := handler.store.UpdateAppointmentStaff(appointment.ID, payload.StaffID); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrSlotTaken) {
+			status = http.StatusConflict
+		}
+		utils.WriteError(writer, status, err)
+		return
+	}
+	appointment.StaffID = &payload.StaffID
+	appointment.Sequence++
+	handler.audit.Record(request, "appointment.reassign", "appointment", id, before, appointment)
+
+	utils.WriteJSON(writer, http.StatusOK, appointment)
+}
+
+// HandleUpdateAppointmentStatus lets staff confirm a request once any
+// deposit is paid, record how a fitting went, or cancel it.
+func (handler *Handler) HandleUpdateAppointmentStatus(writer http.ResponseWriter, request *http.Request) {
+	var payload UpdateStatusPayload
+	if err := utils.ParseJSON(request, &payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	appointment, err := handler.store.GetSingleAppointment(chi.URLParam(request, "id"))
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, err)
+		return
+	}
+
+	next := models.AppointmentStatus(payload.Status)
+	if !appointment.Status.CanTransitionTo(next) {
+		utils.WriteError(writer, http.StatusConflict, fmt.Errorf("cannot change a %s appointment to %s", appointment.Status, next))
+		return
+	}
+	now := time.Now()
+	if (next == models.AppointmentCompleted || next == models.AppointmentNoShow) && now.Before(appointment.Date) {
+		utils.WriteError(writer, http.StatusConflict, fmt.Errorf("appointment has not started yet"))
+		return
+	}
+	if next == models.AppointmentConfirmed && !depositPaid(appointment) {
+		utils.WriteError(writer, http.StatusConflict, fmt.Errorf("the deposit for this appointment has not been paid"))
+		return
+	}
+
+	before := *appointment
+	appointment.Status = next
+	appointment.Sequence++
+	switch next {
+	case models.AppointmentConfirmed:
+		appointment.ConfirmedAt = &now
+	case models.AppointmentCancelled:
+		appointment.CancelledAt = &now
+	}
+	update := handler.store.UpdateAppointmentStatus
+	if next == models.AppointmentCancelled {
+		update = handler.store.CancelAppointment
+	}
+	if err := update(appointment); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	handler.audit.Record(request, "appointment.update_status", "appointment", appointment.ID.String(), before, appointment)
+
+	switch next {
+	case models.AppointmentConfirmed:
+		handler.sendManageLink(appointment, "Your fitting has been confirmed.")
+	case models.AppointmentCancelled:
+		handler.sendCancellation(appointment)
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, appointment)
+}
+
+// HandleGetManagedAppointment shows a customer the appointment behind their
+// manage link and whether they can still change it.
+func (handler *Handler) HandleGetManagedAppointment(writer http.ResponseWriter, request *http.Request) {
+	appointment, err := handler.appointmentFromToken(request)
+	if err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, map[string]any{
+		"appointment":     appointment,
+		"can_change":      handler.checkCustomerChange(appointment, time.Now()) == nil,
+		"change_deadline": appointment.Date.Add(-handler.schedule.ChangeCutoff),
+	})
+}
+
+func (handler *Handler) HandleCancelManagedAppointment(writer http.ResponseWriter, request *http.Request) {
+	appointment, err := handler.appointmentFromToken(request)
+	if err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+	if err := handler.checkCustomerChange(appointment, time.Now()); err != nil {
+		utils.WriteError(writer, http.StatusConflict, err)
+		return
+	}
+
+	if err := handler.Cancel(request, appointment); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, map[string]string{"message": "Appointment Cancelled"})
+}
+
+// Cancel cancels a booking, releasing its slot and voiding an unpaid
+// deposit, and lets the customer know.
+func (handler *Handler) Cancel(request *http.Request, appointment *models.Appointment) error {
+	before := *appointment
+	now := time.Now()
+	appointment.Status = models.AppointmentCancelled
+	appointment.CancelledAt = &now
+	appointment.Sequence++
+	if err := handler.store.CancelAppointment(appointment); err != nil {
+		return err
+	}
+	handler.audit.Record(request, "appointment.cancel", "appointment", appointment.ID.String(), before, appointment)
+	handler.sendCancellation(appointment)
+	return nil
+}
+
+// HandleRescheduleManagedAppointment moves a customer's appointment to
+// another open slot, keeping the same staff member where possible. The old
+// slot is released and the booking goes back to awaiting confirmation.
+func (handler *Handler) HandleRescheduleManagedAppointment(writer http.ResponseWriter, request *http.Request) {
+	appointment, err := handler.appointmentFromToken(request)
+	if err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	var payload ReschedulePayload
+	if err := utils.ParseJSON(request, &payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	now := time.Now()
+	if err := handler.checkCustomerChange(appointment, now); err != nil {
+		utils.WriteError(writer, http.StatusConflict, err)
+		return
+	}
+
+	day := handler.schedule.Day(payload.Date)
+	length := appointment.EndsAt.Sub(appointment.Date)
+	calendars, err := handler.calendars(day, day.AddDate(0, 0, 1), appointment.StaffID, appointment.AppointmentType)
+	if errors.Is(err, ErrStaffNotFound) || errors.Is(err, ErrStaffNotQualified) {
+		// Their stylist has since left; anyone free will do.
+		calendars, err = handler.calendars(day, day.AddDate(0, 0, 1), nil, appointment.AppointmentType)
+	}
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, fmt.Errorf("internal server error"))
+		return
+	}
+	chosen, ok := handler.pickCalendar(payload.Date, length, withoutAppointment(calendars, appointment.ID), now)
+	if !ok {
+		utils.WriteError(writer, http.StatusConflict, fmt.Errorf("this slot is not available"))
+		return
+	}
+
+	before := *appointment
+	appointment.Date = payload.Date
+	appointment.EndsAt = payload.Date.Add(length)
+	appointment.StaffID = chosen.staffID
+	appointment.Status = models.AppointmentRequested
+	appointment.ConfirmedAt = nil
+	appointment.Sequence++
+	if err := handler.store.RescheduleAppointment(appointment); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrSlotTaken) {
+			status = http.StatusConflict
+		}
+		utils.WriteError(writer, status, err)
+		return
+	}
+	handler.audit.Record(request, "appointment.reschedule", "appointment", appointment.ID.String(), before, appointment)
+	handler.sendManageLink(appointment, "Your fitting has been rescheduled.")
+
+	utils.WriteJSON(writer, http.StatusOK, appointment)
+}
+
+// HandleGetAppointmentCalendar serves an appointment as an .ics file. Staff
+// who can read appointments may fetch any of them; customers pass the token
+// from their manage link as ?token=.
+func (handler *Handler) HandleGetAppointmentCalendar(writer http.ResponseWriter, request *http.Request) {
+	id := chi.URLParam(request, "id")
+	allowed := false
+	if principal, ok := auth.PrincipalFromContext(request.Context()); ok {
+		allowed, _ = principal.Can(models.PermAppointmentsRead)
+	}
+	if !allowed {
+		claims, err := auth.ParseToken(request.URL.Query().Get("token"), purposeManageAppointment)
+		allowed = err == nil && claims.Subject == id
+	}
+	if !allowed {
+		utils.WriteError(writer, http.StatusForbidden, fmt.Errorf("forbidden"))
+		return
+	}
+
+	appointment, err := handler.store.GetSingleAppointment(id)
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("appointment not found"))
+		return
+	}
+
+	writeCalendar(writer, "fitting.ics", handler.calendarFile("McWale", handler.customerEvent(appointment)))
+}
+
+// HandleGetStaffFeed serves a staff member's appointments as a calendar
+// they can subscribe to. The token in the URL stands in for signing in, as
+// calendar apps cannot send credentials.
+func (handler *Handler) HandleGetStaffFeed(writer http.ResponseWriter, request *http.Request) {
+	member, err := handler.store.GetStaffByCalendarToken(auth.HashToken(chi.URLParam(request, "token")))
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("calendar not found"))
+		return
+	}
+
+	appointments, err := handler.store.GetStaffAppointmentsSince(member.ID, time.Now().AddDate(0, 0, -feedHistoryDays))
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, fmt.Errorf("internal server error"))
+		return
+	}
+
+	events := make([]ical.Event, 0, len(appointments))
+	for i := range appointments {
+		events = append(events, handler.staffEvent(&appointments[i]))
+	}
+	writeCalendar(writer, "", handler.calendarFile("McWale fittings: "+member.DisplayName, events...))
+}
+
+func (handler *Handler) appointmentFromToken(request *http.Request) (*models.Appointment, error) {
+	claims, err := auth.ParseToken(chi.URLParam(request, "token"), purposeManageAppointment)
+	if err != nil {
+		return nil, fmt.Errorf("invalid or expired link")
+	}
+	appointment, err := handler.store.GetSingleAppointment(claims.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid or expired link")
+	}
+	return appointment, nil
+}
+
+// checkCustomerChange enforces that customers can only change open
+// appointments, and only until the cutoff before the fitting.
+func (handler *Handler) checkCustomerChange(appointment *models.Appointment, now time.Time) error {
+	if !isOpenStatus(appointment.Status) {
+		return fmt.Errorf("a %s appointment can no longer be changed", appointment.Status)
+	}
+	if now.After(appointment.Date.Add(-handler.schedule.ChangeCutoff)) {
+		return fmt.Errorf("appointments can only be changed up to %d hours in advance, please contact us", int(handler.schedule.ChangeCutoff.Hours()))
+	}
+	return nil
+}
+
+func isOpenStatus(status models.AppointmentStatus) bool {
+	return status == models.AppointmentRequested || status == models.AppointmentConfirmed
+}
+
+// sendManageLink emails the customer the appointment details along with a
+// link to reschedule or cancel it. The booking stands even if this fails.
+func (handler *Handler) sendManageLink(appointment *models.Appointment, intro string) {
+	link, err := ManageLink(appointment)
+	if err != nil {
+		log.Printf("failed to create manage link for appointment %s: %v", appointment.ID, err)
+		return
+	}
+
+	err = handler.mailer.Send(mailer.Message{
+		To:      appointment.Email,
+		Subject: "Your fitting appointment",
+		Body: fmt.Sprintf("Hi %s,\n\n%s It is on %s.\n\nYou can reschedule or cancel up to %d hours beforehand using the link below.\n\n%s",
+			appointment.FirstName, intro, handler.formatTime(appointment.Date),
+			int(handler.schedule.ChangeCutoff.Hours()), link),
+		Attachments: handler.calendarAttachment(appointment),
+	})
+	if err != nil {
+		log.Printf("failed to email appointment %s: %v", appointment.ID, err)
+	}
+}
+
+func (handler *Handler) sendCancellation(appointment *models.Appointment) {
+	err := handler.mailer.Send(mailer.Message{
+		To:      appointment.Email,
+		Subject: "Your fitting has been cancelled",
+		Body: fmt.Sprintf("Hi %s,\n\nYour fitting on %s has been cancelled. We hope to see you another time.",
+			appointment.FirstName, handler.formatTime(appointment.Date)),
+		// The cancelled event removes the fitting from the customer's calendar.
+		Attachments: handler.calendarAttachment(appointment),
+	})
+	if err != nil {
+		log.Printf("failed to email appointment %s: %v", appointment.ID, err)
+	}
+}
+
+func (handler *Handler) formatTime(t time.Time) string {
+	return handler.schedule.Format(t)
+}
+
+// ManageLink returns the link a customer uses to reschedule or cancel an
+// appointment without signing in. It stays valid until the fitting ends.
+func ManageLink(appointment *models.Appointment) (string, error) {
+	lifetime := time.Until(appointment.EndsAt)
+	if lifetime < time.Hour {
+		lifetime = time.Hour
+	}
+	token, err := auth.CreatePurposeJWT(appointment.ID.String(), purposeManageAppointment, lifetime, nil)
+	if err != nil {
+		return "", err
+	}
+	return mailer.Link("/appointments/manage/" + token), nil
+}
+
+// depositOrder raises a pending order for the deposit on a booking of the
+// given type, or returns nil when no deposit is due.
+func (handler *Handler) depositOrder(request *http.Request, appointment *models.Appointment, kind *models.AppointmentType) *models.Order {
+	if kind == nil || kind.DepositAmount <= 0 {
+		return nil
+	}
+
+	order := &models.Order{
+		ID:          uuid.New(),
+		OrderNumber: "DEP-" + strings.ToUpper(strings.ReplaceAll(uuid.NewString(), "-", "")[:10]),
+		FirstName:   appointment.FirstName,
+		LastName:    appointment.LastName,
+		Email:       appointment.Email,
+		PhoneNumber: appointment.PhoneNumber,
+		Address1:    appointment.Address,
+		OrderNote:   fmt.Sprintf("Deposit for %s on %s", kind.Name, handler.formatTime(appointment.Date)),
+		Total:       kind.DepositAmount,
+	}
+	if principal, ok := auth.PrincipalFromContext(request.Context()); ok && !principal.IsAPIKey() {
+		order.UserID = &principal.UserID
+	}
+	return order
+}
+
+// depositPaid reports whether a booking's deposit, if it has one, has been
+// paid. GetSingleAppointment preloads the deposit order.
+func depositPaid(appointment *models.Appointment) bool {
+	if appointment.DepositOrderID == nil {
+		return true
+	}
+	order := appointment.DepositOrder
+	return order != nil && order.Status != nil && *order.Status == models.Paid
+}
+
+func (handler *Handler) HandleGetAppointmentTypes(writer http.ResponseWriter, request *http.Request) {
+	types, err := handler.store.GetAppointmentTypes()
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, types)
+}
+
+func (handler *Handler) HandleGetAppointmentType(writer http.ResponseWriter, request *http.Request) {
+	kind, err := handler.store.GetAppointmentType(chi.URLParam(request, "typeID"))
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("appointment type not found"))
+		return
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, kind)
+}
+
+func (handler *Handler) HandleCreateAppointmentType(writer http.ResponseWriter, request *http.Request) {
+	var payload CreateAppointmentTypePayload
+	if err := utils.ParseJSON(request, &payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	kind := &models.AppointmentType{
+		Name:            payload.Name,
+		Description:     payload.Description,
+		DurationMinutes: payload.DurationMinutes,
+		Price:           payload.Price,
+		DepositAmount:   payload.DepositAmount,
+		IsActive:        true,
+	}
+	if err := checkDeposit(kind); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := handler.store.CreateAppointmentType(kind, payload.StaffIDs); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+	handler.audit.Record(request, "appointment_type.create", "appointment_type", kind.ID.String(), nil, kind)
+
+	utils.WriteJSON(writer, http.StatusCreated, kind)
+}
+
+func (handler *Handler) HandleUpdateAppointmentType(writer http.ResponseWriter, request *http.Request) {
+	var payload UpdateAppointmentTypePayload
+	if err := utils.ParseJSON(request, &payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	kind, err := handler.store.GetAppointmentType(chi.URLParam(request, "typeID"))
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("appointment type not found"))
+		return
+	}
+	before := *kind
+
+	if payload.Name != nil {
+		kind.Name = *payload.Name
+	}
+	if payload.Description != nil {
+		kind.Description = *payload.Description
+	}
+	if payload.DurationMinutes != nil {
+		kind.DurationMinutes = *payload.DurationMinutes
+	}
+	if payload.Price != nil {
+		kind.Price = *payload.Price
+	}
+	if payload.DepositAmount != nil {
+		kind.DepositAmount = *payload.DepositAmount
+	}
+	if payload.IsActive != nil {
+		kind.IsActive = *payload.IsActive
+	}
+	if err := checkDeposit(kind); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := handler.store.UpdateAppointmentType(kind, payload.StaffIDs); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+	handler.audit.Record(request, "appointment_type.update", "appointment_type", kind.ID.String(), before, kind)
+
+	utils.WriteJSON(writer, http.StatusOK, kind)
+}
+
+// HandleDeactivateAppointmentType stops a type being booked. Existing
+// bookings keep pointing at it.
+func (handler *Handler) HandleDeactivateAppointmentType(writer http.ResponseWriter, request *http.Request) {
+	kind, err := handler.store.GetAppointmentType(chi.URLParam(request, "typeID"))
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("appointment type not found"))
+		return
+	}
+
+	kind.IsActive = false
+	if err := handler.store.UpdateAppointmentType(kind, nil); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	handler.audit.Record(request, "appointment_type.deactivate", "appointment_type", kind.ID.String(), nil, nil)
+
+	utils.WriteJSON(writer, http.StatusNoContent, nil)
+}
+
+func checkDeposit(kind *models.AppointmentType) error {
+	if kind.Price > 0 && kind.DepositAmount > kind.Price {
+		return fmt.Errorf("deposit cannot be more than the price")
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package appointments
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/razdacoder/mcwale-api/models"
+)
+
+func TestDepositOrder(t *testing.T) {
+	handler := &Handler{schedule: testSchedule(t)}
+	appointment := &models.Appointment{
+		FirstName: "Ada",
+		LastName:  "Lovelace",
+		Email:     "ada@example.com",
+		Date:      at(handler.schedule, 2026, 6, 1, 9, 0),
+	}
+
+	tests := []struct {
+		name string
+		kind *models.AppointmentType
+		want float64
+	}{
+		{"untyped booking", nil, 0},
+		{"no deposit", &models.AppointmentType{Name: "Fitting"}, 0},
+		{"deposit due", &models.AppointmentType{Name: "Bridal fitting", Price: 200, DepositAmount: 50}, 50},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/appointments", nil)
+			order := handler.depositOrder(request, appointment, test.kind)
+			if test.want == 0 {
+				if order != nil {
+					t.Fatalf("expected no deposit order, got %+v", order)
+				}
+				return
+			}
+			if order == nil {
+				t.Fatal("expected a deposit order")
+			}
+			if order.Total != test.want || order.Email != appointment.Email || !strings.HasPrefix(order.OrderNumber, "DEP-") {
+				t.Errorf("unexpected deposit order %+v", order)
+			}
+			if order.UserID != nil {
+				t.Errorf("a booking made without signing in set user %s", order.UserID)
+			}
+		})
+	}
+}
+
+func TestDepositPaid(t *testing.T) {
+	orderID := uuid.New()
+	status := func(status models.OrderStatus) *models.OrderStatus { return &status }
+
+	tests := []struct {
+		name        string
+		appointment models.Appointment
+		want        bool
+	}{
+		{"no deposit", models.Appointment{}, true},
+		{"deposit not loaded", models.Appointment{DepositOrderID: &orderID}, false},
+		{"deposit pending", models.Appointment{DepositOrderID: &orderID, DepositOrder: &models.Order{Status: status(models.Pending)}}, false},
+		{"deposit cancelled", models.Appointment{DepositOrderID: &orderID, DepositOrder: &models.Order{Status: status(models.Cancelled)}}, false},
+		{"deposit paid", models.Appointment{DepositOrderID: &orderID, DepositOrder: &models.Order{Status: status(models.Paid)}}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := depositPaid(&test.appointment); got != test.want {
+				t.Errorf("depositPaid = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCheckDeposit(t *testing.T) {
+	tests := []struct {
+		name    string
+		kind    models.AppointmentType
+		wantErr bool
+	}{
+		{"no deposit", models.AppointmentType{Price: 100}, false},
+		{"part of the price", models.AppointmentType{Price: 100, DepositAmount: 25}, false},
+		{"the whole price", models.AppointmentType{Price: 100, DepositAmount: 100}, false},
+		{"more than the price", models.AppointmentType{Price: 100, DepositAmount: 150}, true},
+		{"free fitting with a deposit", models.AppointmentType{DepositAmount: 20}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := checkDeposit(&test.kind); (err != nil) != test.wantErr {
+				t.Errorf("checkDeposit error = %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
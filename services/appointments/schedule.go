@@ -0,0 +1,229 @@
+package appointments
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	_ "time/tzdata" // the shop's timezone must resolve even on hosts without zoneinfo
+
+	"github.com/google/uuid"
+	"github.com/razdacoder/mcwale-api/models"
+	"github.com/razdacoder/mcwale-api/utils"
+)
+
+const defaultBusinessHours = "mon-fri=09:00-17:00;sat=10:00-15:00"
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Window is a stretch of opening hours, in minutes from midnight.
+type Window struct {
+	Opens  int
+	Closes int
+}
+
+// Schedule describes when fittings can be booked.
+type Schedule struct {
+	Location   *time.Location
+	SlotLength time.Duration
+	Buffer     time.Duration
+	Hours      map[time.Weekday][]Window
+	// ChangeCutoff is how long before a fitting customers stop being able
+	// to cancel or reschedule it themselves.
+	ChangeCutoff time.Duration
+	// StudioAddress is where fittings take place, given as the location of
+	// calendar events.
+	StudioAddress string
+}
+
+// LoadSchedule reads the booking configuration from the environment:
+//
+//	APPOINTMENT_TIMEZONE        shop timezone, defaults to Africa/Lagos
+//	APPOINTMENT_SLOT_MINUTES    length of an untyped fitting, defaults to 60
+//	APPOINTMENT_BUFFER_MINUTES  gap kept free around each fitting, defaults to 15
+//	APPOINTMENT_HOURS           e.g. "mon-fri=09:00-13:00,14:00-17:00;sat=10:00-15:00"
+//	APPOINTMENT_CUTOFF_HOURS    customer changes close this long before, defaults to 24
+//	STUDIO_ADDRESS              where fittings take place, shown in calendar invites
+func LoadSchedule() (*Schedule, error) {
+	timezone := os.Getenv("APPOINTMENT_TIMEZONE")
+	if timezone == "" {
+		timezone = "Africa/Lagos"
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid APPOINTMENT_TIMEZONE: %v", err)
+	}
+
+	slotMinutes := utils.ParseStringToInt(os.Getenv("APPOINTMENT_SLOT_MINUTES"), 60)
+	if slotMinutes <= 0 {
+		return nil, fmt.Errorf("APPOINTMENT_SLOT_MINUTES must be positive")
+	}
+	bufferMinutes := utils.ParseStringToInt(os.Getenv("APPOINTMENT_BUFFER_MINUTES"), 15)
+	if bufferMinutes < 0 {
+		return nil, fmt.Errorf("APPOINTMENT_BUFFER_MINUTES must not be negative")
+	}
+
+	spec := os.Getenv("APPOINTMENT_HOURS")
+	if spec == "" {
+		spec = defaultBusinessHours
+	}
+	hours, err := parseHours(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid APPOINTMENT_HOURS: %v", err)
+	}
+
+	cutoffHours := utils.ParseStringToInt(os.Getenv("APPOINTMENT_CUTOFF_HOURS"), 24)
+	if cutoffHours < 0 {
+		return nil, fmt.Errorf("APPOINTMENT_CUTOFF_HOURS must not be negative")
+	}
+
+	return &Schedule{
+		Location:      location,
+		SlotLength:    time.Duration(slotMinutes) * time.Minute,
+		Buffer:        time.Duration(bufferMinutes) * time.Minute,
+		Hours:         hours,
+		ChangeCutoff:  time.Duration(cutoffHours) * time.Hour,
+		StudioAddress: os.Getenv("STUDIO_ADDRESS"),
+	}, nil
+}
+
+func parseHours(spec string) (map[time.Weekday][]Window, error) {
+	hours := map[time.Weekday][]Window{}
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		days, ranges, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not of the form days=hh:mm-hh:mm", entry)
+		}
+
+		var windows []Window
+		for _, span := range strings.Split(ranges, ",") {
+			opens, closes, ok := strings.Cut(strings.TrimSpace(span), "-")
+			if !ok {
+				return nil, fmt.Errorf("%q is not a time range", span)
+			}
+			window := Window{}
+			var err error
+			if window.Opens, err = parseClock(opens); err != nil {
+				return nil, err
+			}
+			if window.Closes, err = parseClock(closes); err != nil {
+				return nil, err
+			}
+			if window.Closes <= window.Opens {
+				return nil, fmt.Errorf("%q closes before it opens", span)
+			}
+			windows = append(windows, window)
+		}
+
+		first, last, isRange := strings.Cut(strings.ToLower(strings.TrimSpace(days)), "-")
+		from, ok := weekdays[first]
+		if !ok {
+			return nil, fmt.Errorf("unknown weekday %q", first)
+		}
+		to := from
+		if isRange {
+			if to, ok = weekdays[last]; !ok {
+				return nil, fmt.Errorf("unknown weekday %q", last)
+			}
+		}
+		for day := from; ; day = (day + 1) % 7 {
+			hours[day] = append(hours[day], windows...)
+			if day == to {
+				break
+			}
+		}
+	}
+	return hours, nil
+}
+
+func parseClock(value string) (int, error) {
+	clock, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a time of day", value)
+	}
+	return clock.Hour()*60 + clock.Minute(), nil
+}
+
+type Slot struct {
+	StartsAt time.Time   `json:"starts_at"`
+	EndsAt   time.Time   `json:"ends_at"`
+	StaffIDs []uuid.UUID `json:"staff_ids,omitempty"`
+}
+
+type Day struct {
+	Date  string `json:"date"`
+	Slots []Slot `json:"slots"`
+}
+
+// Period is a span of time during which nothing can be booked.
+type Period struct {
+	StartsAt time.Time
+	EndsAt   time.Time
+}
+
+// Format renders t in the shop's timezone for customer emails.
+func (schedule *Schedule) Format(t time.Time) string {
+	return t.In(schedule.Location).Format("Monday 2 January 2006 at 15:04 MST")
+}
+
+// Day returns midnight at the start of the shop's calendar day containing t.
+func (schedule *Schedule) Day(t time.Time) time.Time {
+	year, month, day := t.In(schedule.Location).Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, schedule.Location)
+}
+
+// Slots lists the open slots of the given length on the given day within
+// the given opening hours. Slots that have already started, fall in a closed
+// period, or come within the buffer of an existing booking are left out.
+func (schedule *Schedule) Slots(day time.Time, length time.Duration, hours map[time.Weekday][]Window, closed []Period, booked []models.Appointment, now time.Time) []Slot {
+	year, month, date := day.In(schedule.Location).Date()
+	slots := []Slot{}
+	for _, window := range hours[day.In(schedule.Location).Weekday()] {
+		closes := time.Date(year, month, date, 0, window.Closes, 0, 0, schedule.Location)
+		start := time.Date(year, month, date, 0, window.Opens, 0, 0, schedule.Location)
+		for end := start.Add(length); !end.After(closes); end = start.Add(length) {
+			if start.After(now) && schedule.isFree(start, end, closed, booked) {
+				slots = append(slots, Slot{StartsAt: start, EndsAt: end})
+			}
+			start = end.Add(schedule.Buffer)
+		}
+	}
+	return slots
+}
+
+func (schedule *Schedule) isFree(start, end time.Time, closed []Period, booked []models.Appointment) bool {
+	for _, period := range closed {
+		if period.StartsAt.Before(end) && start.Before(period.EndsAt) {
+			return false
+		}
+	}
+	for _, appointment := range booked {
+		if appointment.Date.Add(-schedule.Buffer).Before(end) && start.Before(appointment.EndsAt.Add(schedule.Buffer)) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsOpen reports whether a booking of the given length may start at the
+// given time.
+func (schedule *Schedule) IsOpen(startsAt time.Time, length time.Duration, hours map[time.Weekday][]Window, closed []Period, booked []models.Appointment, now time.Time) bool {
+	for _, slot := range schedule.Slots(startsAt, length, hours, closed, booked, now) {
+		if slot.StartsAt.Equal(startsAt) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,206 @@
+package appointments
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/razdacoder/mcwale-api/models"
+)
+
+func testSchedule(t *testing.T) *Schedule {
+	location, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Schedule{
+		Location:   location,
+		SlotLength: time.Hour,
+		Buffer:     15 * time.Minute,
+		Hours: map[time.Weekday][]Window{
+			time.Monday: {{Opens: 9 * 60, Closes: 12 * 60}},
+			time.Sunday: {{Opens: 9 * 60, Closes: 10 * 60}},
+		},
+	}
+}
+
+// at is a time on the shop's clock.
+func at(schedule *Schedule, year int, month time.Month, day, hour, minute int) time.Time {
+	return time.Date(year, month, day, hour, minute, 0, 0, schedule.Location)
+}
+
+func slotStarts(slots []Slot) []string {
+	starts := []string{}
+	for _, slot := range slots {
+		starts = append(starts, slot.StartsAt.Format("15:04"))
+	}
+	return starts
+}
+
+func TestParseHours(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    map[time.Weekday][]Window
+		wantErr bool
+	}{
+		{"single day", "sat=10:00-15:00", map[time.Weekday][]Window{time.Saturday: {{600, 900}}}, false},
+		{"split day", "mon=09:00-13:00,14:00-17:00", map[time.Weekday][]Window{time.Monday: {{540, 780}, {840, 1020}}}, false},
+		{"range wrapping the week", "sat-mon=10:00-11:00", map[time.Weekday][]Window{
+			time.Saturday: {{600, 660}}, time.Sunday: {{600, 660}}, time.Monday: {{600, 660}},
+		}, false},
+		{"blank entries", " ; fri=09:00-10:00 ;", map[time.Weekday][]Window{time.Friday: {{540, 600}}}, false},
+		{"missing days", "09:00-17:00", nil, true},
+		{"unknown weekday", "funday=09:00-17:00", nil, true},
+		{"not a time", "mon=9am-5pm", nil, true},
+		{"closes before it opens", "mon=17:00-09:00", nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseHours(test.spec)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseHours(%q) error = %v, want error %v", test.spec, err, test.wantErr)
+			}
+			if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseHours(%q) = %v, want %v", test.spec, got, test.want)
+			}
+		})
+	}
+}
+
+func TestSlots(t *testing.T) {
+	schedule := testSchedule(t)
+	monday := at(schedule, 2026, time.June, 1, 0, 0)
+	before := at(schedule, 2026, time.May, 31, 12, 0)
+
+	tests := []struct {
+		name   string
+		day    time.Time
+		length time.Duration
+		closed []Period
+		booked []models.Appointment
+		now    time.Time
+		want   []string
+	}{
+		{"open day", monday, time.Hour, nil, nil, before, []string{"09:00", "10:15"}},
+		{"shorter fittings", monday, 30 * time.Minute, nil, nil, before, []string{"09:00", "09:45", "10:30", "11:15"}},
+		{"longer than the opening hours", monday, 4 * time.Hour, nil, nil, before, []string{}},
+		{"closed weekday", monday.AddDate(0, 0, 1), time.Hour, nil, nil, before, []string{}},
+		{"closed all day", monday, time.Hour, []Period{
+			{at(schedule, 2026, time.June, 1, 0, 0), at(schedule, 2026, time.June, 2, 0, 0)},
+		}, nil, before, []string{}},
+		{"closed for the morning", monday, time.Hour, []Period{
+			{at(schedule, 2026, time.June, 1, 8, 0), at(schedule, 2026, time.June, 1, 10, 0)},
+		}, nil, before, []string{"10:15"}},
+		{"closure ending as the slot starts", monday, time.Hour, []Period{
+			{at(schedule, 2026, time.June, 1, 7, 0), at(schedule, 2026, time.June, 1, 9, 0)},
+		}, nil, before, []string{"09:00", "10:15"}},
+		{"booking within the buffer", monday, time.Hour, nil, []models.Appointment{
+			{Date: at(schedule, 2026, time.June, 1, 11, 20), EndsAt: at(schedule, 2026, time.June, 1, 12, 0)},
+		}, before, []string{"09:00"}},
+		{"booking just outside the buffer", monday, time.Hour, nil, []models.Appointment{
+			{Date: at(schedule, 2026, time.June, 1, 11, 30), EndsAt: at(schedule, 2026, time.June, 1, 12, 0)},
+		}, before, []string{"09:00", "10:15"}},
+		{"first slot already started", monday, time.Hour, nil, nil, at(schedule, 2026, time.June, 1, 9, 0), []string{"10:15"}},
+		{"day already over", monday, time.Hour, nil, nil, at(schedule, 2026, time.June, 1, 12, 0), []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := slotStarts(schedule.Slots(test.day, test.length, schedule.Hours, test.closed, test.booked, test.now))
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got slots %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+// TestSlotsAcrossDST checks opening hours follow the shop's clock on the
+// days the clocks change, rather than a fixed UTC offset.
+func TestSlotsAcrossDST(t *testing.T) {
+	schedule := testSchedule(t)
+	now := at(schedule, 2026, time.January, 1, 0, 0)
+
+	tests := []struct {
+		name string
+		day  time.Time
+		want time.Time
+	}{
+		{"before clocks go forward", at(schedule, 2026, time.March, 22, 0, 0), time.Date(2026, time.March, 22, 9, 0, 0, 0, time.UTC)},
+		{"clocks go forward", at(schedule, 2026, time.March, 29, 0, 0), time.Date(2026, time.March, 29, 8, 0, 0, 0, time.UTC)},
+		{"clocks go back", at(schedule, 2026, time.October, 25, 0, 0), time.Date(2026, time.October, 25, 9, 0, 0, 0, time.UTC)},
+		{"given in UTC", time.Date(2026, time.March, 29, 12, 0, 0, 0, time.UTC), time.Date(2026, time.March, 29, 8, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			slots := schedule.Slots(test.day, time.Hour, schedule.Hours, nil, nil, now)
+			if len(slots) != 1 {
+				t.Fatalf("got %d slots, want 1", len(slots))
+			}
+			if !slots[0].StartsAt.Equal(test.want) {
+				t.Errorf("slot starts at %s, want %s", slots[0].StartsAt.UTC(), test.want)
+			}
+			if length := slots[0].EndsAt.Sub(slots[0].StartsAt); length != time.Hour {
+				t.Errorf("slot lasts %s, want 1h", length)
+			}
+		})
+	}
+}
+
+func TestIsFree(t *testing.T) {
+	schedule := testSchedule(t)
+	start := at(schedule, 2026, time.June, 1, 10, 0)
+	end := start.Add(time.Hour)
+
+	tests := []struct {
+		name   string
+		closed []Period
+		booked []models.Appointment
+		want   bool
+	}{
+		{"nothing in the way", nil, nil, true},
+		{"overlapping closure", []Period{{start.Add(30 * time.Minute), end.Add(time.Hour)}}, nil, false},
+		{"closure ending at the start", []Period{{start.Add(-time.Hour), start}}, nil, true},
+		{"closure starting at the end", []Period{{end, end.Add(time.Hour)}}, nil, true},
+		{"overlapping booking", nil, []models.Appointment{{Date: start, EndsAt: end}}, false},
+		{"booking ending inside the buffer", nil, []models.Appointment{{Date: start.Add(-time.Hour), EndsAt: start.Add(-10 * time.Minute)}}, false},
+		{"booking ending at the buffer", nil, []models.Appointment{{Date: start.Add(-time.Hour), EndsAt: start.Add(-15 * time.Minute)}}, true},
+		{"booking starting inside the buffer", nil, []models.Appointment{{Date: end.Add(10 * time.Minute), EndsAt: end.Add(time.Hour)}}, false},
+		{"booking starting after the buffer", nil, []models.Appointment{{Date: end.Add(15 * time.Minute), EndsAt: end.Add(time.Hour)}}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := schedule.isFree(start, end, test.closed, test.booked); got != test.want {
+				t.Errorf("isFree = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestIsOpen(t *testing.T) {
+	schedule := testSchedule(t)
+	now := at(schedule, 2026, time.June, 1, 9, 30)
+	booked := []models.Appointment{
+		{Date: at(schedule, 2026, time.June, 8, 10, 15), EndsAt: at(schedule, 2026, time.June, 8, 11, 15)},
+	}
+
+	tests := []struct {
+		name     string
+		startsAt time.Time
+		want     bool
+	}{
+		{"start of a slot", at(schedule, 2026, time.June, 1, 10, 15), true},
+		{"between slots", at(schedule, 2026, time.June, 1, 10, 0), false},
+		{"already started", at(schedule, 2026, time.June, 1, 9, 0), false},
+		{"closed weekday", at(schedule, 2026, time.June, 2, 9, 0), false},
+		{"booked", at(schedule, 2026, time.June, 8, 10, 15), false},
+		{"free the same day", at(schedule, 2026, time.June, 8, 9, 0), true},
+		{"free another week", at(schedule, 2026, time.June, 15, 10, 15), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := schedule.IsOpen(test.startsAt, time.Hour, schedule.Hours, nil, booked, now); got != test.want {
+				t.Errorf("IsOpen(%s) = %v, want %v", test.startsAt, got, test.want)
+			}
+		})
+	}
+}
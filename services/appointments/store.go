@@ -1,8 +1,12 @@
 package appointments
 
 import (
+	"errors"
 	"fmt"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/razdacoder/mcwale-api/models"
 	"gorm.io/gorm"
 )
@@ -17,42 +21,360 @@ func NewStore(db *gorm.DB) *Store {
 	}
 }
 
-func (store *Store) GetAllAppointments() ([]models.Appointment, error) {
+// GetAppointments returns the appointments matching filter, soonest first,
+// with the total number of matches.
+func (store *Store) GetAppointments(filter AppointmentFilter) ([]models.Appointment, int64, error) {
 	var appointments []models.Appointment
-	result := store.db.Find(&appointments)
-	if result.Error != nil {
-		fmt.Println(result.Error)
-		return nil, result.Error
+	var total int64
+	db := store.db.Model(&models.Appointment{})
+
+	if filter.From != nil {
+		db = db.Where("date >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		db = db.Where("date < ?", *filter.To)
+	}
+	if filter.Status != "" {
+		db = db.Where("status = ?", filter.Status)
+	}
+	if filter.StaffID != nil {
+		db = db.Where("staff_id = ?", *filter.StaffID)
+	}
+	if filter.Search != "" {
+		search := "%" + filter.Search + "%"
+		db = db.Where("(first_name ILIKE ? OR last_name ILIKE ? OR email ILIKE ? OR phone_number ILIKE ? OR CONCAT(first_name, ' ', last_name) ILIKE ?)",
+			search, search, search, search, search)
 	}
 
-	return appointments, nil
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	db = db.Preload("Staff").Preload("AppointmentType").Order("date").Offset(filter.Offset)
+	if filter.Limit > 0 {
+		db = db.Limit(filter.Limit)
+	}
+	result := db.Find(&appointments)
+	return appointments, total, result.Error
+}
+
+// GetCustomerAppointments returns the appointments booked with an email,
+// latest first.
+func (store *Store) GetCustomerAppointments(email string) ([]models.Appointment, error) {
+	var appointments []models.Appointment
+	err := store.db.Preload("Staff").Preload("AppointmentType").
+		Where("email = ?", email).
+		Order("date DESC").
+		Find(&appointments).Error
+	return appointments, err
 }
 
-func (store *Store) CreateNewAppointments(app CreateAppointmentPayload) error {
-	appointment := &models.Appointment{
-		FirstName:   app.FirstName,
-		LastName:    app.LastName,
-		Email:       app.Email,
-		PhoneNumber: app.Email,
-		Address:     app.Address,
-		Date:        app.Date,
+// GetVerifiedEmail returns the user's email, or empty if they have not
+// verified it.
+func (store *Store) GetVerifiedEmail(userID uuid.UUID) (string, error) {
+	var user models.User
+	if err := store.db.Select("email", "email_verified_at").Where("id = ?", userID).First(&user).Error; err != nil {
+		return "", err
 	}
+	return user.VerifiedEmail(), nil
+}
 
-	if err := store.db.Create(&appointment).Error; err != nil {
+// CreateNewAppointments books the appointment, raising its deposit order
+// alongside it when one is due.
+func (store *Store) CreateNewAppointments(appointment *models.Appointment, deposit *models.Order) (*models.Appointment, error) {
+	err := store.db.Transaction(func(tx *gorm.DB) error {
+		if deposit != nil {
+			if err := tx.Create(deposit).Error; err != nil {
+				return err
+			}
+			appointment.DepositOrderID = &deposit.ID
+		}
+		return tx.Create(appointment).Error
+	})
+	if err != nil {
+		if isExclusionViolation(err) {
+			return nil, ErrSlotTaken
+		}
 		fmt.Println(err)
-		return err
+		return nil, err
 	}
 
+	return appointment, nil
+}
+
+// GetAppointmentsBetween returns the bookings overlapping [from, to).
+// Cancelled bookings no longer hold their slot and are left out.
+func (store *Store) GetAppointmentsBetween(from, to time.Time) ([]models.Appointment, error) {
+	var appointments []models.Appointment
+	err := store.db.Where("date < ? AND ends_at > ? AND status <> ?", to, from, models.AppointmentCancelled).Order("date").Find(&appointments).Error
+	return appointments, err
+}
+
+// GetClosures returns the closures overlapping [from, to).
+func (store *Store) GetClosures(from, to time.Time) ([]models.AppointmentClosure, error) {
+	var closures []models.AppointmentClosure
+	err := store.db.Where("starts_at < ? AND ends_at > ?", to, from).Order("starts_at").Find(&closures).Error
+	return closures, err
+}
+
+func (store *Store) UpdateAppointmentStatus(appointment *models.Appointment) error {
+	return store.db.Model(appointment).Select("status", "confirmed_at", "cancelled_at", "sequence").Updates(appointment).Error
+}
+
+// CancelAppointment cancels a booking and voids its deposit order if it has
+// not been paid. A paid deposit is left for staff to refund.
+func (store *Store) CancelAppointment(appointment *models.Appointment) error {
+	return store.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(appointment).Select("status", "confirmed_at", "cancelled_at", "sequence").Updates(appointment).Error; err != nil {
+			return err
+		}
+		if appointment.DepositOrderID == nil {
+			return nil
+		}
+		return tx.Model(&models.Order{}).
+			Where("id = ? AND status = ?", *appointment.DepositOrderID, models.Pending).
+			Update("status", models.Cancelled).Error
+	})
+}
+
+func (store *Store) RescheduleAppointment(appointment *models.Appointment) error {
+	err := store.db.Model(appointment).Select("date", "ends_at", "staff_id", "status", "confirmed_at", "sequence").Updates(appointment).Error
+	if isExclusionViolation(err) {
+		return ErrSlotTaken
+	}
+	return err
+}
+
+func (store *Store) UpdateAppointmentStaff(id uuid.UUID, staffID uuid.UUID) error {
+	err := store.db.Model(&models.Appointment{}).Where("id = ?", id).Updates(map[string]any{
+		"staff_id": staffID,
+		"sequence": gorm.Expr("sequence + 1"),
+	}).Error
+	if isExclusionViolation(err) {
+		return ErrSlotTaken
+	}
+	return err
+}
+
+// GetStaffByCalendarToken finds the staff member a calendar feed belongs to.
+func (store *Store) GetStaffByCalendarToken(hash string) (*models.StaffMember, error) {
+	var member models.StaffMember
+	if err := store.db.Where("calendar_token_hash = ?", hash).First(&member).Error; err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// GetStaffAppointmentsSince returns a staff member's appointments starting
+// from the given time, cancelled ones included so that feeds drop them.
+func (store *Store) GetStaffAppointmentsSince(staffID uuid.UUID, from time.Time) ([]models.Appointment, error) {
+	var appointments []models.Appointment
+	err := store.db.Preload("AppointmentType").
+		Where("staff_id = ? AND date >= ?", staffID, from).
+		Order("date").
+		Find(&appointments).Error
+	return appointments, err
+}
+
+// GetActiveStaff returns the staff taking bookings, with their working hours
+// and any time off overlapping [from, to).
+func (store *Store) GetActiveStaff(from, to time.Time) ([]models.StaffMember, error) {
+	var staff []models.StaffMember
+	err := store.db.Where("is_active = ?", true).
+		Preload("WorkingHours").
+		Preload("TimeOff", "starts_at < ? AND ends_at > ?", to, from).
+		Order("display_name").
+		Find(&staff).Error
+	return staff, err
+}
+
+func (store *Store) CreateClosure(closure *models.AppointmentClosure) error {
+	return store.db.Create(closure).Error
+}
+
+func (store *Store) DeleteClosure(id string) error {
+	result := store.db.Where("id = ?", id).Delete(&models.AppointmentClosure{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("closure not found")
+	}
 	return nil
 }
 
+// isExclusionViolation reports whether err came from an EXCLUDE constraint,
+// which is how the database refuses overlapping bookings.
+func isExclusionViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23P01"
+}
+
 func (store *Store) GetSingleAppointment(id string) (*models.Appointment, error) {
 	var appointment *models.Appointment
 
-	if err := store.db.Where("id = ?", id).First(&appointment).Error; err != nil {
+	if err := store.db.Preload("AppointmentType.Staff").Preload("Staff").Preload("DepositOrder").Where("id = ?", id).First(&appointment).Error; err != nil {
 		fmt.Println(err)
 		return nil, err
 	}
 
 	return appointment, nil
 }
+
+func (store *Store) GetAppointmentTypes() ([]models.AppointmentType, error) {
+	var types []models.AppointmentType
+	err := store.db.Preload("Staff").Where("is_active = ?", true).Order("name").Find(&types).Error
+	return types, err
+}
+
+func (store *Store) GetAppointmentType(id string) (*models.AppointmentType, error) {
+	var kind models.AppointmentType
+	if err := store.db.Preload("Staff").Where("id = ?", id).First(&kind).Error; err != nil {
+		return nil, err
+	}
+	return &kind, nil
+}
+
+func (store *Store) CreateAppointmentType(kind *models.AppointmentType, staffIDs []uuid.UUID) error {
+	staff, err := store.findStaff(staffIDs)
+	if err != nil {
+		return err
+	}
+	kind.Staff = staff
+	return store.db.Create(kind).Error
+}
+
+// UpdateAppointmentType saves the type, replacing who can take it when
+// staffIDs is given.
+func (store *Store) UpdateAppointmentType(kind *models.AppointmentType, staffIDs *[]uuid.UUID) error {
+	return store.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(kind).Select("name", "description", "duration_minutes", "price", "deposit_amount", "is_active").Updates(kind).Error
+		if err != nil || staffIDs == nil {
+			return err
+		}
+
+		staff, err := store.findStaff(*staffIDs)
+		if err != nil {
+			return err
+		}
+		kind.Staff = staff
+		return tx.Model(kind).Association("Staff").Replace(staff)
+	})
+}
+
+func (store *Store) findStaff(ids []uuid.UUID) ([]models.StaffMember, error) {
+	staff := []models.StaffMember{}
+	if len(ids) == 0 {
+		return staff, nil
+	}
+	if err := store.db.Where("id IN ?", ids).Find(&staff).Error; err != nil {
+		return nil, err
+	}
+	if len(staff) != len(ids) {
+		return nil, fmt.Errorf("unknown staff member")
+	}
+	return staff, nil
+}
+
+func (store *Store) CreateWaitlistEntry(entry *models.WaitlistEntry) error {
+	return store.db.Omit("AppointmentType").Create(entry).Error
+}
+
+func (store *Store) GetWaitlistEntry(id string) (*models.WaitlistEntry, error) {
+	var entry models.WaitlistEntry
+	if err := store.db.Preload("AppointmentType").Where("id = ?", id).First(&entry).Error; err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// GetWaitlist returns entries in the order they will be offered slots,
+// optionally only those with the given status.
+func (store *Store) GetWaitlist(status string, offset, limit int) ([]models.WaitlistEntry, int64, error) {
+	var entries []models.WaitlistEntry
+	var total int64
+	db := store.db.Model(&models.WaitlistEntry{})
+	if status != "" {
+		db = db.Where("status = ?", status)
+	}
+
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	result := db.Preload("AppointmentType").Order("created_at").Offset(offset).Limit(limit).Find(&entries)
+	return entries, total, result.Error
+}
+
+// GetWaitingEntries returns the customers still waiting, first come first
+// served.
+func (store *Store) GetWaitingEntries() ([]models.WaitlistEntry, error) {
+	var entries []models.WaitlistEntry
+	err := store.db.Preload("AppointmentType.Staff").
+		Where("status = ?", models.WaitlistWaiting).
+		Order("created_at").
+		Find(&entries).Error
+	return entries, err
+}
+
+// GetActiveHolds returns the slots held for waitlisted customers that
+// overlap [from, to).
+func (store *Store) GetActiveHolds(from, to, now time.Time) ([]models.WaitlistEntry, error) {
+	var entries []models.WaitlistEntry
+	err := store.db.Where("status = ? AND offer_expires_at > ? AND offered_starts_at < ? AND offered_ends_at > ?",
+		models.WaitlistOffered, now, to, from).Find(&entries).Error
+	return entries, err
+}
+
+// ExpireWaitlist lapses offers that were not claimed in time and closes
+// entries whose window has passed, returning how many entries changed.
+func (store *Store) ExpireWaitlist(now time.Time) (int64, error) {
+	lapsed := store.db.Model(&models.WaitlistEntry{}).
+		Where("status = ? AND offer_expires_at <= ?", models.WaitlistOffered, now).
+		Update("status", models.WaitlistLapsed)
+	if lapsed.Error != nil {
+		return 0, lapsed.Error
+	}
+	expired := store.db.Model(&models.WaitlistEntry{}).
+		Where("status = ? AND window_ends_at <= ?", models.WaitlistWaiting, now).
+		Update("status", models.WaitlistExpired)
+	return lapsed.RowsAffected + expired.RowsAffected, expired.Error
+}
+
+// OfferWaitlistEntry holds a slot for a waiting customer. It returns
+// ErrSlotTaken if the slot is already held for someone else, and false if
+// the entry stopped waiting in the meantime.
+func (store *Store) OfferWaitlistEntry(entry *models.WaitlistEntry) (bool, error) {
+	result := store.db.Model(entry).
+		Where("status = ?", models.WaitlistWaiting).
+		Select("status", "offered_starts_at", "offered_ends_at", "offered_staff_id", "offer_expires_at").
+		Updates(entry)
+	if isExclusionViolation(result.Error) {
+		return false, ErrSlotTaken
+	}
+	return result.RowsAffected > 0, result.Error
+}
+
+// ReleaseWaitlistOffer puts an offered entry back to waiting, freeing the
+// slot held for it.
+func (store *Store) ReleaseWaitlistOffer(entry *models.WaitlistEntry) error {
+	entry.Status = models.WaitlistWaiting
+	entry.OfferedStartsAt = nil
+	entry.OfferedEndsAt = nil
+	entry.OfferedStaffID = nil
+	entry.OfferExpiresAt = nil
+	return store.db.Model(entry).
+		Where("status = ?", models.WaitlistOffered).
+		Select("status", "offered_starts_at", "offered_ends_at", "offered_staff_id", "offer_expires_at").
+		Updates(entry).Error
+}
+
+// UpdateWaitlistStatus moves an entry on from the status it was loaded
+// with, returning false if it had already changed.
+func (store *Store) UpdateWaitlistStatus(entry *models.WaitlistEntry, from models.WaitlistStatus) (bool, error) {
+	result := store.db.Model(entry).
+		Where("status = ?", from).
+		Select("status", "appointment_id").
+		Updates(entry)
+	return result.RowsAffected > 0, result.Error
+}
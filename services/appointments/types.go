@@ -1,12 +1,105 @@
 package appointments
 
-import "time"
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/razdacoder/mcwale-api/models"
+)
+
+var (
+	// ErrSlotTaken is returned when a booking overlaps one that already exists.
+	ErrSlotTaken = errors.New("this slot has already been booked")
+	// ErrSlotUnavailable is returned when nobody can be booked at a time.
+	ErrSlotUnavailable = errors.New("this slot is not available")
+	ErrTypeNotFound    = errors.New("appointment type not found")
+)
 
 type CreateAppointmentPayload struct {
-	FirstName   string    `json:"first_name" validate:"required"`
-	LastName    string    `json:"last_name" validate:"required"`
-	Email       string    `json:"email" validate:"required,email"`
-	PhoneNumber string    `json:"phone_number" validate:"required"`
-	Address     string    `json:"address" validate:"required"`
-	Date        time.Time `json:"date" validate:"required"`
+	FirstName   string     `json:"first_name" validate:"required"`
+	LastName    string     `json:"last_name" validate:"required"`
+	Email       string     `json:"email" validate:"required,email"`
+	PhoneNumber string     `json:"phone_number" validate:"required"`
+	Address     string     `json:"address" validate:"required"`
+	Date        time.Time  `json:"date" validate:"required"`
+	StaffID     *uuid.UUID `json:"staff_id"`
+
+	AppointmentTypeID *uuid.UUID `json:"appointment_type_id"`
+}
+
+// AppointmentFilter narrows the back office appointment list. A zero Limit
+// returns every match, which the calendar views rely on.
+type AppointmentFilter struct {
+	From    *time.Time
+	To      *time.Time
+	Status  string
+	StaffID *uuid.UUID
+	Search  string
+	Offset  int
+	Limit   int
+}
+
+// AppointmentDay is one date of the day and week calendar views.
+type AppointmentDay struct {
+	Date         string               `json:"date"`
+	Appointments []models.Appointment `json:"appointments"`
+}
+
+// JoinWaitlistPayload asks for a slot of the given type starting between
+// StartsAt and EndsAt, optionally with one staff member.
+type JoinWaitlistPayload struct {
+	FirstName   string     `json:"first_name" validate:"required"`
+	LastName    string     `json:"last_name" validate:"required"`
+	Email       string     `json:"email" validate:"required,email"`
+	PhoneNumber string     `json:"phone_number" validate:"required"`
+	Address     string     `json:"address" validate:"required"`
+	StartsAt    time.Time  `json:"starts_at" validate:"required"`
+	EndsAt      time.Time  `json:"ends_at" validate:"required,gtfield=StartsAt"`
+	StaffID     *uuid.UUID `json:"staff_id"`
+
+	AppointmentTypeID *uuid.UUID `json:"appointment_type_id"`
+}
+
+type UpdateStatusPayload struct {
+	Status string `json:"status" validate:"required,oneof=confirmed completed cancelled no_show"`
+}
+
+type ReschedulePayload struct {
+	Date time.Time `json:"date" validate:"required"`
+}
+
+type ReassignAppointmentPayload struct {
+	StaffID uuid.UUID `json:"staff_id" validate:"required"`
+}
+
+// CreateClosurePayload blocks out time. Holidays take a whole calendar day
+// via Date; blackouts give an explicit StartsAt and EndsAt.
+type CreateClosurePayload struct {
+	Kind     string    `json:"kind" validate:"required,oneof=holiday blackout"`
+	Date     string    `json:"date" validate:"required_if=Kind holiday"`
+	StartsAt time.Time `json:"starts_at" validate:"required_if=Kind blackout"`
+	EndsAt   time.Time `json:"ends_at" validate:"required_if=Kind blackout"`
+	Reason   string    `json:"reason"`
+}
+
+// CreateAppointmentTypePayload leaves StaffIDs empty for a type any staff
+// member can take.
+type CreateAppointmentTypePayload struct {
+	Name            string      `json:"name" validate:"required"`
+	Description     string      `json:"description"`
+	DurationMinutes int         `json:"duration_minutes" validate:"required,min=5,max=720"`
+	Price           float64     `json:"price" validate:"min=0"`
+	DepositAmount   float64     `json:"deposit_amount" validate:"min=0"`
+	StaffIDs        []uuid.UUID `json:"staff_ids"`
+}
+
+type UpdateAppointmentTypePayload struct {
+	Name            *string      `json:"name" validate:"omitempty,min=1"`
+	Description     *string      `json:"description"`
+	DurationMinutes *int         `json:"duration_minutes" validate:"omitempty,min=5,max=720"`
+	Price           *float64     `json:"price" validate:"omitempty,min=0"`
+	DepositAmount   *float64     `json:"deposit_amount" validate:"omitempty,min=0"`
+	IsActive        *bool        `json:"is_active"`
+	StaffIDs        *[]uuid.UUID `json:"staff_ids"`
 }
@@ -0,0 +1,429 @@
+package appointments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/razdacoder/mcwale-api/mailer"
+	"github.com/razdacoder/mcwale-api/models"
+	"github.com/razdacoder/mcwale-api/services/auth"
+	"github.com/razdacoder/mcwale-api/services/jobs"
+	"github.com/razdacoder/mcwale-api/utils"
+)
+
+const (
+	// purposeWaitlist marks the tokens that let customers check on or leave
+	// the waitlist without signing in.
+	purposeWaitlist = "appointment_waitlist"
+	// purposeWaitlistHold marks the tokens that claim a held slot.
+	purposeWaitlistHold = "appointment_waitlist_hold"
+)
+
+// HandleJoinWaitlist adds a customer to the waitlist for a slot of the
+// given type starting between starts_at and ends_at. Slots are offered to
+// customers in the order they joined.
+func (handler *Handler) HandleJoinWaitlist(writer http.ResponseWriter, request *http.Request) {
+	var payload JoinWaitlistPayload
+	if err := utils.ParseJSON(request, &payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+	if !payload.EndsAt.After(time.Now()) {
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("ends_at must be in the future"))
+		return
+	}
+	if payload.EndsAt.Sub(payload.StartsAt) > maxAvailabilityDays*24*time.Hour {
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("the window can be at most %d days", maxAvailabilityDays))
+		return
+	}
+
+	var kind *models.AppointmentType
+	if payload.AppointmentTypeID != nil {
+		found, err := handler.store.GetAppointmentType(payload.AppointmentTypeID.String())
+		if err != nil || !found.IsActive {
+			utils.WriteError(writer, http.StatusBadRequest, ErrTypeNotFound)
+			return
+		}
+		kind = found
+	}
+	if payload.StaffID != nil {
+		_, err := handler.calendars(payload.StartsAt, payload.EndsAt, payload.StaffID, kind)
+		if errors.Is(err, ErrStaffNotFound) || errors.Is(err, ErrStaffNotQualified) {
+			utils.WriteError(writer, http.StatusBadRequest, err)
+			return
+		}
+		if err != nil {
+			utils.WriteError(writer, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	entry := &models.WaitlistEntry{
+		FirstName:         payload.FirstName,
+		LastName:          payload.LastName,
+		Email:             payload.Email,
+		PhoneNumber:       payload.PhoneNumber,
+		Address:           payload.Address,
+		AppointmentTypeID: payload.AppointmentTypeID,
+		StaffID:           payload.StaffID,
+		WindowStartsAt:    payload.StartsAt,
+		WindowEndsAt:      payload.EndsAt,
+		Status:            models.WaitlistWaiting,
+	}
+	if err := handler.store.CreateWaitlistEntry(entry); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	handler.audit.Record(request, "waitlist.join", "waitlist_entry", entry.ID.String(), nil, entry)
+	entry.AppointmentType = kind
+	handler.sendWaitlistJoined(entry)
+
+	utils.WriteJSON(writer, http.StatusCreated, map[string]string{"message": "Added to waitlist", "waitlist_id": entry.ID.String()})
+}
+
+// HandleGetWaitlist lists waitlist entries in the order they are offered
+// slots, a page at a time, optionally filtered by ?status.
+func (handler *Handler) HandleGetWaitlist(writer http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+	page := utils.ParseStringToInt(query.Get("page"), 0)
+	perPage := utils.ParseStringToInt(os.Getenv("PER_PAGE"), 10)
+	if page == 0 {
+		page = 1
+	}
+
+	entries, total, err := handler.store.GetWaitlist(query.Get("status"), (page-1)*perPage, perPage)
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	pages := int(math.Ceil(float64(total) / float64(perPage)))
+	utils.WriteJSON(writer, http.StatusOK, map[string]any{
+		"results": len(entries),
+		"page":    page,
+		"pages":   pages,
+		"total":   total,
+		"data":    entries,
+	})
+}
+
+func (handler *Handler) HandleGetManagedWaitlistEntry(writer http.ResponseWriter, request *http.Request) {
+	entry, err := handler.waitlistEntryFromToken(request, purposeWaitlist)
+	if err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, entry)
+}
+
+// HandleLeaveWaitlist takes a customer off the waitlist, releasing any slot
+// being held for them.
+func (handler *Handler) HandleLeaveWaitlist(writer http.ResponseWriter, request *http.Request) {
+	entry, err := handler.waitlistEntryFromToken(request, purposeWaitlist)
+	if err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+	if entry.Status != models.WaitlistWaiting && entry.Status != models.WaitlistOffered {
+		utils.WriteError(writer, http.StatusConflict, fmt.Errorf("this waitlist entry is already %s", entry.Status))
+		return
+	}
+
+	before := *entry
+	entry.Status = models.WaitlistCancelled
+	ok, err := handler.store.UpdateWaitlistStatus(entry, before.Status)
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	if !ok {
+		utils.WriteError(writer, http.StatusConflict, fmt.Errorf("this waitlist entry has changed, please try again"))
+		return
+	}
+	handler.audit.Record(request, "waitlist.leave", "waitlist_entry", entry.ID.String(), before, entry)
+
+	utils.WriteJSON(writer, http.StatusOK, map[string]string{"message": "Removed from waitlist"})
+}
+
+// HandleGetHold shows the slot being held for a customer and until when.
+func (handler *Handler) HandleGetHold(writer http.ResponseWriter, request *http.Request) {
+	entry, err := handler.waitlistEntryFromToken(request, purposeWaitlistHold)
+	if err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+	if !isHeld(entry, time.Now()) {
+		utils.WriteError(writer, http.StatusConflict, fmt.Errorf("this offer has expired"))
+		return
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, entry)
+}
+
+// HandleClaimHold books the slot held for a waitlisted customer, provided
+// the hold has not expired.
+func (handler *Handler) HandleClaimHold(writer http.ResponseWriter, request *http.Request) {
+	entry, err := handler.waitlistEntryFromToken(request, purposeWaitlistHold)
+	if err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+	if !isHeld(entry, time.Now()) {
+		utils.WriteError(writer, http.StatusConflict, fmt.Errorf("this offer has expired"))
+		return
+	}
+
+	appointment, deposit, err := handler.book(request, CreateAppointmentPayload{
+		FirstName:         entry.FirstName,
+		LastName:          entry.LastName,
+		Email:             entry.Email,
+		PhoneNumber:       entry.PhoneNumber,
+		Address:           entry.Address,
+		Date:              *entry.OfferedStartsAt,
+		StaffID:           entry.OfferedStaffID,
+		AppointmentTypeID: entry.AppointmentTypeID,
+	}, entry.ID)
+	if err != nil {
+		status := BookingErrorStatus(err)
+		if status == http.StatusInternalServerError {
+			err = fmt.Errorf("internal server error")
+		}
+		utils.WriteError(writer, status, err)
+		return
+	}
+
+	before := *entry
+	entry.Status = models.WaitlistBooked
+	entry.AppointmentID = &appointment.ID
+	if _, err := handler.store.UpdateWaitlistStatus(entry, models.WaitlistOffered); err != nil {
+		log.Printf("failed to mark waitlist entry %s booked: %v", entry.ID, err)
+	}
+	handler.audit.Record(request, "waitlist.claim", "waitlist_entry", entry.ID.String(), before, entry)
+
+	response := map[string]string{"message": "Appointment Booked", "appointment_id": appointment.ID.String()}
+	if deposit != nil {
+		response["deposit_order_number"] = deposit.OrderNumber
+	}
+	utils.WriteJSON(writer, http.StatusOK, response)
+}
+
+func (handler *Handler) waitlistEntryFromToken(request *http.Request, purpose string) (*models.WaitlistEntry, error) {
+	claims, err := auth.ParseToken(chi.URLParam(request, "token"), purpose)
+	if err != nil {
+		return nil, fmt.Errorf("invalid or expired link")
+	}
+	entry, err := handler.store.GetWaitlistEntry(claims.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid or expired link")
+	}
+	return entry, nil
+}
+
+func isHeld(entry *models.WaitlistEntry, now time.Time) bool {
+	return entry.Status == models.WaitlistOffered && entry.OfferExpiresAt != nil && now.Before(*entry.OfferExpiresAt)
+}
+
+func (handler *Handler) sendWaitlistJoined(entry *models.WaitlistEntry) {
+	lifetime := time.Until(entry.WindowEndsAt)
+	if lifetime < time.Hour {
+		lifetime = time.Hour
+	}
+	token, err := auth.CreatePurposeJWT(entry.ID.String(), purposeWaitlist, lifetime, nil)
+	if err != nil {
+		log.Printf("failed to create waitlist link for entry %s: %v", entry.ID, err)
+		return
+	}
+
+	err = handler.mailer.Send(mailer.Message{
+		To:      entry.Email,
+		Subject: "You're on the waitlist",
+		Body: fmt.Sprintf("Hi %s,\n\nYou're on the waitlist for a %s between %s and %s. As soon as a slot opens up we'll email you and hold it for you for a short while.\n\nIf your plans change, you can leave the waitlist using the link below.\n\n%s",
+			entry.FirstName, waitlistWhat(entry), handler.formatTime(entry.WindowStartsAt),
+			handler.formatTime(entry.WindowEndsAt), mailer.Link("/appointments/waitlist/"+token)),
+	})
+	if err != nil {
+		log.Printf("failed to email waitlist entry %s: %v", entry.ID, err)
+	}
+}
+
+func (handler *Handler) sendWaitlistOffer(entry *models.WaitlistEntry) error {
+	token, err := auth.CreatePurposeJWT(entry.ID.String(), purposeWaitlistHold, time.Until(*entry.OfferExpiresAt), nil)
+	if err != nil {
+		return err
+	}
+
+	return handler.mailer.Send(mailer.Message{
+		To:      entry.Email,
+		Subject: "A slot has opened up",
+		Body: fmt.Sprintf("Hi %s,\n\nGood news: a %s has opened up on %s. We're holding it for you until %s.\n\nTo book it, use the link below. If you don't, it will be offered to the next person on the waitlist.\n\n%s",
+			entry.FirstName, waitlistWhat(entry), handler.formatTime(*entry.OfferedStartsAt),
+			handler.formatTime(*entry.OfferExpiresAt), mailer.Link("/appointments/waitlist/hold/"+token)),
+	})
+}
+
+func waitlistWhat(entry *models.WaitlistEntry) string {
+	if entry.AppointmentType != nil {
+		return entry.AppointmentType.Name
+	}
+	return "fitting"
+}
+
+type Waitlist struct {
+	handler  *Handler
+	hold     time.Duration
+	interval time.Duration
+}
+
+// NewWaitlist configures the waitlist job from the environment:
+//
+//	WAITLIST_HOLD_MINUTES  how long an opened slot is held for a customer, defaults to 60
+//	WAITLIST_INTERVAL      how often to look for opened slots, defaults to 1m
+func NewWaitlist(handler *Handler) (*Waitlist, error) {
+	hold := time.Hour
+	if value := os.Getenv("WAITLIST_HOLD_MINUTES"); value != "" {
+		minutes, err := strconv.Atoi(value)
+		if err != nil || minutes <= 0 {
+			return nil, fmt.Errorf("invalid WAITLIST_HOLD_MINUTES %q", value)
+		}
+		hold = time.Duration(minutes) * time.Minute
+	}
+
+	interval := time.Minute
+	if value := os.Getenv("WAITLIST_INTERVAL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid WAITLIST_INTERVAL %q", value)
+		}
+		interval = parsed
+	}
+
+	return &Waitlist{
+		handler:  handler,
+		hold:     hold,
+		interval: interval,
+	}, nil
+}
+
+func (waitlist *Waitlist) Job() jobs.Job {
+	return jobs.Job{
+		Name:     "appointment_waitlist",
+		Interval: waitlist.interval,
+		Run:      waitlist.run,
+	}
+}
+
+// run lapses unclaimed holds, then offers any open slots to waiting
+// customers, first come first served. Each customer is offered one slot at
+// a time; one whose hold lapses drops off the waitlist.
+func (waitlist *Waitlist) run(ctx context.Context) (jobs.Result, error) {
+	result := jobs.Result{}
+	now := time.Now()
+	if _, err := waitlist.handler.store.ExpireWaitlist(now); err != nil {
+		return result, err
+	}
+
+	entries, err := waitlist.handler.store.GetWaitingEntries()
+	if err != nil {
+		return result, err
+	}
+	for i := range entries {
+		if ctx.Err() != nil {
+			break
+		}
+		offered, err := waitlist.offer(&entries[i], now)
+		if err != nil {
+			log.Printf("waitlist entry %s: %v", entries[i].ID, err)
+			result.Failed++
+			continue
+		}
+		if offered {
+			result.Processed++
+		}
+	}
+	return result, nil
+}
+
+// offer holds the earliest open slot in the entry's window for it and
+// emails the customer a link to claim it. It returns false if nothing is
+// free.
+func (waitlist *Waitlist) offer(entry *models.WaitlistEntry, now time.Time) (bool, error) {
+	handler := waitlist.handler
+	from := entry.WindowStartsAt
+	if from.Before(now) {
+		from = now
+	}
+	if from.After(entry.WindowEndsAt) {
+		return false, nil
+	}
+
+	first := handler.schedule.Day(from)
+	last := handler.schedule.Day(entry.WindowEndsAt)
+	calendars, err := handler.calendars(first, last.AddDate(0, 0, 1), entry.StaffID, entry.AppointmentType)
+	if errors.Is(err, ErrStaffNotFound) || errors.Is(err, ErrStaffNotQualified) {
+		// The staff member asked for is no longer available; the entry
+		// waits until its window closes.
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	length := handler.slotLength(entry.AppointmentType)
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		for _, slot := range handler.mergeSlots(day, length, calendars, now) {
+			if slot.StartsAt.Before(from) || slot.StartsAt.After(entry.WindowEndsAt) {
+				continue
+			}
+			chosen, ok := handler.pickCalendar(slot.StartsAt, length, calendars, now)
+			if !ok {
+				continue
+			}
+
+			startsAt, endsAt := slot.StartsAt, slot.StartsAt.Add(length)
+			expiresAt := now.Add(waitlist.hold)
+			if startsAt.Before(expiresAt) {
+				expiresAt = startsAt
+			}
+			entry.Status = models.WaitlistOffered
+			entry.OfferedStartsAt = &startsAt
+			entry.OfferedEndsAt = &endsAt
+			entry.OfferedStaffID = chosen.staffID
+			entry.OfferExpiresAt = &expiresAt
+
+			ok, err := handler.store.OfferWaitlistEntry(entry)
+			if errors.Is(err, ErrSlotTaken) {
+				// Held for someone else since the calendars were loaded.
+				continue
+			}
+			if err != nil || !ok {
+				return false, err
+			}
+			// A customer who never hears of the offer cannot claim it, so
+			// the slot is released and the entry tried again next run.
+			if err := handler.sendWaitlistOffer(entry); err != nil {
+				if releaseErr := handler.store.ReleaseWaitlistOffer(entry); releaseErr != nil {
+					return false, fmt.Errorf("%v; releasing the offer: %v", err, releaseErr)
+				}
+				return false, err
+			}
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,163 @@
+package audit
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"reflect"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/razdacoder/mcwale-api/models"
+	"github.com/razdacoder/mcwale-api/services/auth"
+)
+
+// Recorder is what services use to write audit entries from their handlers.
+type Recorder interface {
+	Record(request *http.Request, action, entityType, entityID string, before, after any)
+}
+
+type Logger struct {
+	store AuditStore
+}
+
+func NewLogger(store AuditStore) *Logger {
+	return &Logger{
+		store: store,
+	}
+}
+
+// Record stores an entry for a change made by the request's principal.
+// before and after are the entity around the change (nil when it was created
+// or deleted) and only the fields that differ are kept. A failure to record
+// is logged rather than failing a change that has already been made.
+func (logger *Logger) Record(request *http.Request, action, entityType, entityID string, before, after any) {
+	entry := &models.AuditLog{
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Changes:    Diff(before, after),
+		Method:     request.Method,
+		Path:       routePattern(request),
+		UserAgent:  request.UserAgent(),
+		RequestID:  middleware.GetReqID(request.Context()),
+	}
+	if host, _, err := net.SplitHostPort(request.RemoteAddr); err == nil {
+		entry.IPAddress = host
+	} else {
+		entry.IPAddress = request.RemoteAddr
+	}
+
+	if principal, ok := auth.PrincipalFromContext(request.Context()); ok {
+		if principal.IsAPIKey() {
+			entry.ActorAPIKeyID = principal.APIKeyID
+		} else {
+			entry.ActorUserID = &principal.UserID
+			entry.ActorRole = string(principal.Role)
+		}
+	}
+
+	if err := logger.store.CreateLog(entry); err != nil {
+		log.Printf("failed to record audit entry %s %s/%s: %v", action, entityType, entityID, err)
+	}
+}
+
+// personalFields are the JSON fields holding a customer's personal details.
+// The audit log is append-only and outlives account erasure, so it records
+// that these changed but never what they held.
+var personalFields = map[string]bool{
+	"first_name":      true,
+	"last_name":       true,
+	"email":           true,
+	"phone_number":    true,
+	"address":         true,
+	"address_line_1":  true,
+	"address_line_2":  true,
+	"town":            true,
+	"state":           true,
+	"postal_code":     true,
+	"billing_address": true,
+	"order_note":      true,
+	"author_name":     true,
+	"values":          true,
+	"notes":           true,
+}
+
+const redacted = "[redacted]"
+
+// routePattern is the route that handled the request, such as
+// /appointments/manage/{token}, rather than its path. Several paths carry
+// secrets like reset or manage tokens, which must never reach an
+// append-only table.
+func routePattern(request *http.Request) string {
+	if routeContext := chi.RouteContext(request.Context()); routeContext != nil {
+		if pattern := routeContext.RoutePattern(); pattern != "" {
+			return pattern
+		}
+	}
+	return ""
+}
+
+// Diff compares the JSON form of before and after and returns the changed
+// fields as {"field": {"before": ..., "after": ...}}, with personal details
+// redacted.
+func Diff(before, after any) models.JSONMap {
+	beforeFields := toFields(before)
+	afterFields := toFields(after)
+
+	changes := models.JSONMap{}
+	for key, value := range afterFields {
+		if previous, ok := beforeFields[key]; !ok || !reflect.DeepEqual(previous, value) {
+			changes[key] = map[string]any{"before": redact(key, beforeFields[key]), "after": redact(key, value)}
+		}
+	}
+	for key, value := range beforeFields {
+		if _, ok := afterFields[key]; !ok {
+			changes[key] = map[string]any{"before": redact(key, value), "after": nil}
+		}
+	}
+	return changes
+}
+
+// redact hides value if key is a personal field, and otherwise hides any
+// personal fields nested inside it, such as an order embedded in an
+// appointment.
+func redact(key string, value any) any {
+	if value == nil {
+		return nil
+	}
+	if personalFields[key] {
+		return redacted
+	}
+	switch value := value.(type) {
+	case map[string]any:
+		fields := make(map[string]any, len(value))
+		for field, nested := range value {
+			fields[field] = redact(field, nested)
+		}
+		return fields
+	case []any:
+		items := make([]any, len(value))
+		for i, item := range value {
+			items[i] = redact("", item)
+		}
+		return items
+	}
+	return value
+}
+
+func toFields(value any) map[string]any {
+	fields := map[string]any{}
+	if value == nil || (reflect.ValueOf(value).Kind() == reflect.Pointer && reflect.ValueOf(value).IsNil()) {
+		return fields
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fields
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return map[string]any{"value": value}
+	}
+	return fields
+}
@@ -0,0 +1,67 @@
+package audit
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/razdacoder/mcwale-api/models"
+	"github.com/razdacoder/mcwale-api/services/auth"
+	"github.com/razdacoder/mcwale-api/utils"
+)
+
+type Handler struct {
+	store AuditStore
+}
+
+func NewHandler(store AuditStore) *Handler {
+	return &Handler{
+		store: store,
+	}
+}
+
+func (handler *Handler) RegisterRoutes(router chi.Router) {
+	router.With(auth.IsLoggedIn, auth.RequirePermission(models.PermAuditRead)).Get("/audit-logs", handler.handleGetLogs)
+}
+
+func (handler *Handler) handleGetLogs(writer http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+	page := utils.ParseStringToInt(query.Get("page"), 0)
+	perPage := utils.ParseStringToInt(os.Getenv("PER_PAGE"), 10)
+	if page == 0 {
+		page = 1
+	}
+
+	filter := LogFilter{
+		ActorUserID: query.Get("actor"),
+		Action:      query.Get("action"),
+		EntityType:  query.Get("entity_type"),
+		EntityID:    query.Get("entity_id"),
+		Offset:      (page - 1) * perPage,
+		Limit:       perPage,
+	}
+	for param, target := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+		date, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("%s must be an RFC 3339 timestamp", param))
+			return
+		}
+		*target = &date
+	}
+
+	logs, total, err := handler.store.GetLogs(filter)
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	pages := int(math.Ceil(float64(total) / float64(perPage)))
+	utils.WriteJSON(writer, http.StatusOK, map[string]any{"results": len(logs), "page": page, "pages": pages, "total": total, "data": logs})
+}
@@ -0,0 +1,53 @@
+package audit
+
+import (
+	"github.com/razdacoder/mcwale-api/models"
+	"gorm.io/gorm"
+)
+
+type Store struct {
+	db *gorm.DB
+}
+
+func NewStore(db *gorm.DB) *Store {
+	return &Store{
+		db: db,
+	}
+}
+
+func (store *Store) CreateLog(entry *models.AuditLog) error {
+	result := store.db.Create(entry)
+	return result.Error
+}
+
+func (store *Store) GetLogs(filter LogFilter) ([]models.AuditLog, int64, error) {
+	var logs []models.AuditLog
+	var total int64
+	db := store.db.Model(&models.AuditLog{})
+
+	if filter.ActorUserID != "" {
+		db = db.Where("actor_user_id = ?", filter.ActorUserID)
+	}
+	if filter.Action != "" {
+		db = db.Where("action = ?", filter.Action)
+	}
+	if filter.EntityType != "" {
+		db = db.Where("entity_type = ?", filter.EntityType)
+	}
+	if filter.EntityID != "" {
+		db = db.Where("entity_id = ?", filter.EntityID)
+	}
+	if filter.From != nil {
+		db = db.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		db = db.Where("created_at < ?", *filter.To)
+	}
+
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	result := db.Order("created_at DESC").Offset(filter.Offset).Limit(filter.Limit).Find(&logs)
+	return logs, total, result.Error
+}
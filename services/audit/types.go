@@ -0,0 +1,23 @@
+package audit
+
+import (
+	"time"
+
+	"github.com/razdacoder/mcwale-api/models"
+)
+
+type AuditStore interface {
+	CreateLog(entry *models.AuditLog) error
+	GetLogs(filter LogFilter) ([]models.AuditLog, int64, error)
+}
+
+type LogFilter struct {
+	ActorUserID string
+	Action      string
+	EntityType  string
+	EntityID    string
+	From        *time.Time
+	To          *time.Time
+	Offset      int
+	Limit       int
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/razdacoder/mcwale-api/utils"
+)
+
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicKeys returns the asymmetric keys other services need to validate
+// tokens, including retired keys whose tokens may still be in circulation.
+func PublicKeys() (JWKSet, error) {
+	set := JWKSet{Keys: []JWK{}}
+	ring, err := getKeyring()
+	if err != nil {
+		return set, err
+	}
+
+	now := time.Now()
+	for _, key := range ring.keys {
+		if !key.public() || key.expired(now) {
+			continue
+		}
+		jwk := JWK{KeyID: key.id, Algorithm: key.method.Alg(), Use: "sig"}
+		switch k := key.verify.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(k.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(k)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].KeyID < set.Keys[j].KeyID })
+
+	return set, nil
+}
+
+func HandleJWKS(writer http.ResponseWriter, request *http.Request) {
+	set, err := PublicKeys()
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	writer.Header().Set("Cache-Control", "public, max-age=300")
+	utils.WriteJSON(writer, http.StatusOK, set)
+}
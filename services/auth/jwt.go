@@ -1,177 +1,187 @@
 package auth
 
 import (
-	"context"
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
-	"github.com/go-chi/chi/v5"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/razdacoder/mcwale-api/models"
 	"github.com/razdacoder/mcwale-api/utils"
 )
 
-func CreateJWT(secret []byte, userID uuid.UUID, role string) (string, error) {
-	expiration := time.Second * time.Duration(utils.ParseStringToInt(os.Getenv("JWT_EXP"), 604800))
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"userID":     userID,
-		"role":       role,
-		"expires_at": time.Now().Add(expiration).Unix(),
-	})
+const (
+	PurposeAccess        = "access"
+	PurposePasswordReset = "password_reset"
+)
 
-	tokenStr, err := token.SignedString(secret)
-	if err != nil {
-		return "", err
+type Claims struct {
+	Role    string            `json:"role,omitempty"`
+	Purpose string            `json:"purpose"`
+	Data    map[string]string `json:"data,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func issuer() string {
+	if iss := os.Getenv("JWT_ISSUER"); iss != "" {
+		return iss
 	}
+	return "mcwale-api"
+}
 
-	return tokenStr, nil
+func audience() string {
+	if aud := os.Getenv("JWT_AUDIENCE"); aud != "" {
+		return aud
+	}
+	return "mcwale"
 }
 
-func CreateResetPasswordJWT(secret []byte, userID uuid.UUID) (string, error) {
-	expiration := time.Duration(10) * time.Minute
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"userID":     userID,
-		"expires_at": time.Now().Add(expiration).Unix(),
-	})
-	tokenStr, err := token.SignedString(secret)
+func newClaims(subject string, purpose string, expiration time.Duration) Claims {
+	now := time.Now()
+	return Claims{
+		Purpose: purpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Subject:   subject,
+			Issuer:    issuer(),
+			Audience:  jwt.ClaimStrings{audience()},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiration)),
+		},
+	}
+}
+
+func signClaims(claims Claims) (string, error) {
+	ring, err := getKeyring()
 	if err != nil {
 		return "", err
 	}
 
-	return tokenStr, nil
+	token := jwt.NewWithClaims(ring.active.method, claims)
+	token.Header["kid"] = ring.active.id
+
+	return token.SignedString(ring.active.sign)
 }
-func verifyToken(tokenString string) (string, string, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(os.Getenv("JWT_SECRET")), nil
-	})
 
-	if err != nil {
-		return "", "", err
-	}
+func CreateJWT(userID uuid.UUID, role string) (string, error) {
+	claims := newClaims(userID.String(), PurposeAccess, accessTokenLifetime())
+	claims.Role = role
+
+	return signClaims(claims)
+}
 
-	if !token.Valid {
-		return "", "", fmt.Errorf("invalid token")
+func CreateResetPasswordJWT(userID uuid.UUID) (string, error) {
+	expiration := time.Duration(10) * time.Minute
+	return signClaims(newClaims(userID.String(), PurposePasswordReset, expiration))
+}
+
+// CreatePurposeJWT signs a short lived token that is only accepted by
+// ParseToken for the same purpose, carrying any extra data the flow needs.
+func CreatePurposeJWT(subject, purpose string, expiration time.Duration, data map[string]string) (string, error) {
+	claims := newClaims(subject, purpose, expiration)
+	claims.Data = data
+	return signClaims(claims)
+}
+
+// ParseToken verifies the signature, algorithm, issuer, audience and
+// validity window of a token and checks it was issued for purpose.
+func ParseToken(tokenString, purpose string) (*Claims, error) {
+	ring, err := getKeyring()
+	if err != nil {
+		return nil, err
 	}
 
-	claims := token.Claims.(jwt.MapClaims)
-	userId, ok := claims["userID"].(string)
-	if !ok {
-		return "", "", fmt.Errorf("user claim not found")
+	var claims Claims
+	_, err = jwt.ParseWithClaims(tokenString, &claims, ring.verificationKey,
+		jwt.WithValidMethods(ring.methods()),
+		jwt.WithIssuer(issuer()),
+		jwt.WithAudience(audience()),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil {
+		return nil, err
 	}
 
-	role, ok := claims["role"].(string)
-	if !ok {
-		return "", "", fmt.Errorf("role claim not found")
+	if claims.Purpose == "" || claims.Purpose != purpose {
+		return nil, fmt.Errorf("invalid token")
 	}
 
-	return userId, role, nil
+	return &claims, nil
 }
 
-func VerifyUserToken(tokenString string) (bool, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(os.Getenv("JWT_SECRET")), nil
-	})
-
+func PrincipalFromToken(tokenString string) (*Principal, error) {
+	claims, err := ParseToken(tokenString, PurposeAccess)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		exp := claims["expires_at"].(float64)
-		if exp < float64(time.Now().Unix()) {
-			return false, fmt.Errorf("token has expired")
-		}
-		return true, nil
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subject claim")
 	}
 
-	return false, fmt.Errorf("invalid token")
+	return &Principal{
+		UserID:  userID,
+		Role:    models.UserRole(claims.Role),
+		TokenID: claims.ID,
+	}, nil
 }
 
 func VerifyPasswordToken(tokenString string) (string, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(os.Getenv("JWT_SECRET")), nil
-	})
-
+	claims, err := ParseToken(tokenString, PurposePasswordReset)
 	if err != nil {
 		return "", err
 	}
 
-	if !token.Valid {
-		return "", fmt.Errorf("invalid token")
-	}
-
-	claims := token.Claims.(jwt.MapClaims)
-	userId, ok := claims["userID"].(string)
-	if !ok {
-		return "", fmt.Errorf("userId claim not found")
-	}
-
-	return userId, nil
-
+	return claims.Subject, nil
 }
 
-type ContextKey string
-
-const UserKey ContextKey = "user"
+func BearerToken(request *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+	return token, ok && token != ""
+}
 
 func IsLoggedIn(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
 		writer.Header().Set("Content-Type", "application/json")
-		tokenString := request.Header.Get("Authorization")
-		if tokenString == "" {
-			utils.WriteError(writer, http.StatusUnauthorized, fmt.Errorf("Unauthorized"))
+		if key := request.Header.Get(APIKeyHeader); key != "" {
+			principal, err := PrincipalFromAPIKey(key)
+			if err != nil {
+				utils.WriteError(writer, http.StatusUnauthorized, fmt.Errorf("Unauthorized"))
+				return
+			}
+			next.ServeHTTP(writer, request.WithContext(WithPrincipal(request.Context(), principal)))
 			return
 		}
-		tokenString = tokenString[len("Bearer "):]
-		userId, role, err := verifyToken(tokenString)
-		if err != nil {
-			utils.WriteError(writer, http.StatusUnauthorized, fmt.Errorf("Unauthorized"))
-			return
-		}
-		ctxVal := map[string]string{"userId": userId, "role": role}
-		ctx := context.WithValue(request.Context(), UserKey, ctxVal)
-		next.ServeHTTP(writer, request.WithContext(ctx))
-	})
-}
 
-func IsAdmin(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
-		writer.Header().Set("Content-Type", "application/json")
-		ctx := request.Context()
-		user, ok := ctx.Value(UserKey).(map[string]string)
+		tokenString, ok := BearerToken(request)
 		if !ok {
-			utils.WriteError(writer, http.StatusUnprocessableEntity, fmt.Errorf("no user found"))
+			utils.WriteError(writer, http.StatusUnauthorized, fmt.Errorf("Unauthorized"))
 			return
 		}
-		fmt.Println(models.UserRole(user["role"]))
-		if models.UserRole(user["role"]) != models.Admin {
-			utils.WriteError(writer, http.StatusUnauthorized, fmt.Errorf("unauthorized"))
+		principal, err := PrincipalFromToken(tokenString)
+		if err != nil {
+			utils.WriteError(writer, http.StatusUnauthorized, fmt.Errorf("Unauthorized"))
 			return
 		}
-
+		ctx := WithPrincipal(request.Context(), principal)
 		next.ServeHTTP(writer, request.WithContext(ctx))
 	})
 }
 
-func IsAdminOrCurrentUser(next http.Handler) http.Handler {
+// OptionalLogin attaches the principal when credentials are sent but lets
+// anonymous requests through. Invalid credentials are still rejected.
+func OptionalLogin(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
-		writer.Header().Set("Content-Type", "application/json")
-		ctx := request.Context()
-		user, ok := ctx.Value(UserKey).(map[string]string)
-		if !ok {
-			utils.WriteError(writer, http.StatusUnprocessableEntity, fmt.Errorf("no user found"))
+		if request.Header.Get(APIKeyHeader) == "" && request.Header.Get("Authorization") == "" {
+			next.ServeHTTP(writer, request)
 			return
 		}
-
-		id := chi.URLParam(request, "id")
-		fmt.Println(user["userId"], id)
-		if models.UserRole(user["role"]) != models.Admin && user["userId"] != id {
-			utils.WriteError(writer, http.StatusUnauthorized, fmt.Errorf("unauthorized this"))
-			return
-		}
-		next.ServeHTTP(writer, request.WithContext(ctx))
+		IsLoggedIn(next).ServeHTTP(writer, request)
 	})
 }
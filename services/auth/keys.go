@@ -0,0 +1,232 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/razdacoder/mcwale-api/utils"
+)
+
+const defaultKeyID = "default"
+
+type signingKey struct {
+	id        string
+	method    jwt.SigningMethod
+	sign      interface{}
+	verify    interface{}
+	retiredAt *time.Time
+}
+
+// public reports whether the key can be shared with other services.
+func (key *signingKey) public() bool {
+	return key.method != jwt.SigningMethodHS256
+}
+
+// expired reports whether every token signed before retirement has expired,
+// after which the key is neither accepted nor published.
+func (key *signingKey) expired(now time.Time) bool {
+	return key.retiredAt != nil && now.After(key.retiredAt.Add(accessTokenLifetime()))
+}
+
+type keyring struct {
+	active *signingKey
+	keys   map[string]*signingKey
+}
+
+var (
+	ringOnce    sync.Once
+	ring        *keyring
+	ringLoadErr error
+)
+
+func accessTokenLifetime() time.Duration {
+	return time.Second * time.Duration(utils.ParseStringToInt(os.Getenv("JWT_EXP"), 604800))
+}
+
+func getKeyring() (*keyring, error) {
+	ringOnce.Do(func() {
+		ring, ringLoadErr = loadKeyring()
+	})
+	return ring, ringLoadErr
+}
+
+// loadKeyring builds the set of keys used to sign and verify tokens.
+//
+// JWT_ALG selects the signing algorithm. For HS256, JWT_SECRETS holds a comma
+// separated list of kid:secret pairs (a lone JWT_SECRET is treated as a single
+// key). For RS256 and EdDSA the private key is read from JWT_PRIVATE_KEY_FILE.
+// JWT_ACTIVE_KID names the signing key.
+//
+// JWT_RETIRED_KEYS lists keys that no longer sign as kid=path@RFC3339, where
+// the timestamp is when the key was retired. They are still accepted and
+// published until tokens signed before that moment have expired.
+func loadKeyring() (*keyring, error) {
+	ring := &keyring{keys: map[string]*signingKey{}}
+
+	activeID := os.Getenv("JWT_ACTIVE_KID")
+	if activeID == "" {
+		activeID = defaultKeyID
+	}
+
+	for _, pair := range strings.Split(os.Getenv("JWT_SECRETS"), ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		id, secret, ok := strings.Cut(pair, ":")
+		if !ok || id == "" || secret == "" {
+			return nil, fmt.Errorf("invalid JWT_SECRETS entry")
+		}
+		ring.add(hmacKey(id, []byte(secret)))
+	}
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		if _, exists := ring.keys[defaultKeyID]; !exists {
+			ring.add(hmacKey(defaultKeyID, []byte(secret)))
+		}
+	}
+
+	switch alg := os.Getenv("JWT_ALG"); alg {
+	case "", jwt.SigningMethodHS256.Alg():
+	case jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg():
+		key, err := loadKeyFile(activeID, os.Getenv("JWT_PRIVATE_KEY_FILE"))
+		if err != nil {
+			return nil, err
+		}
+		if key.method.Alg() != alg {
+			return nil, fmt.Errorf("JWT_PRIVATE_KEY_FILE is not a %s key", alg)
+		}
+		ring.add(key)
+	default:
+		return nil, fmt.Errorf("unsupported JWT_ALG %s", alg)
+	}
+
+	for _, entry := range strings.Split(os.Getenv("JWT_RETIRED_KEYS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, rest, ok := strings.Cut(entry, "=")
+		path, retired, hasDate := strings.Cut(rest, "@")
+		if !ok || !hasDate || id == "" {
+			return nil, fmt.Errorf("invalid JWT_RETIRED_KEYS entry %s", entry)
+		}
+		retiredAt, err := time.Parse(time.RFC3339, retired)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retirement date for key %s: %w", id, err)
+		}
+		key, err := loadKeyFile(id, path)
+		if err != nil {
+			return nil, err
+		}
+		key.sign = nil
+		key.retiredAt = &retiredAt
+		ring.add(key)
+	}
+
+	active, ok := ring.keys[activeID]
+	if !ok || active.sign == nil {
+		return nil, fmt.Errorf("no JWT signing key configured for key %s", activeID)
+	}
+	ring.active = active
+
+	return ring, nil
+}
+
+func (ring *keyring) add(key *signingKey) {
+	ring.keys[key.id] = key
+}
+
+func (ring *keyring) methods() []string {
+	seen := map[string]bool{}
+	var methods []string
+	for _, key := range ring.keys {
+		if alg := key.method.Alg(); !seen[alg] {
+			seen[alg] = true
+			methods = append(methods, alg)
+		}
+	}
+	return methods
+}
+
+// verificationKey returns the key for kid, refusing tokens whose header
+// algorithm differs from the one the key was configured with.
+func (ring *keyring) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing key id")
+	}
+	key, ok := ring.keys[kid]
+	if !ok || key.expired(time.Now()) {
+		return nil, fmt.Errorf("unknown signing key %s", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	return key.verify, nil
+}
+
+func hmacKey(id string, secret []byte) *signingKey {
+	return &signingKey{
+		id:     id,
+		method: jwt.SigningMethodHS256,
+		sign:   secret,
+		verify: secret,
+	}
+}
+
+// loadKeyFile reads a PEM encoded RSA or Ed25519 key. Private keys can sign
+// and verify, public keys only verify.
+func loadKeyFile(id, path string) (*signingKey, error) {
+	if path == "" {
+		return nil, fmt.Errorf("no key file configured for key %s", id)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", path)
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %s in %s", block.Type, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &signingKey{id: id}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.method, key.sign, key.verify = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.method, key.verify = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.method, key.sign, key.verify = jwt.SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.method, key.verify = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("unsupported key type in %s", path)
+	}
+
+	return key, nil
+}
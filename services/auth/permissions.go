@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/razdacoder/mcwale-api/models"
+	"github.com/razdacoder/mcwale-api/utils"
+)
+
+type PermissionStore interface {
+	GetRole(name models.UserRole) (*models.Role, error)
+}
+
+var permissionStore PermissionStore
+
+// UsePermissionStore sets where RequirePermission looks up the permissions
+// granted to a role. It must be called before the router starts serving.
+func UsePermissionStore(store PermissionStore) {
+	permissionStore = store
+}
+
+func HasPermission(role models.UserRole, permission string) (bool, error) {
+	if role == models.Admin {
+		return true, nil
+	}
+	if permissionStore == nil {
+		return false, fmt.Errorf("permission store not configured")
+	}
+	r, err := permissionStore.GetRole(role)
+	if err != nil {
+		return false, err
+	}
+
+	return r.HasPermission(permission), nil
+}
+
+func RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			writer.Header().Set("Content-Type", "application/json")
+			principal, ok := PrincipalFromContext(request.Context())
+			if !ok {
+				utils.WriteError(writer, http.StatusUnauthorized, fmt.Errorf("unauthorized"))
+				return
+			}
+
+			allowed, err := principal.Can(permission)
+			if err != nil || !allowed {
+				utils.WriteError(writer, http.StatusForbidden, fmt.Errorf("forbidden"))
+				return
+			}
+
+			next.ServeHTTP(writer, request)
+		})
+	}
+}
+
+// IsCurrentUserOr lets a user act on their own {id} resource, and anyone else
+// holding the given permission.
+func IsCurrentUserOr(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			writer.Header().Set("Content-Type", "application/json")
+			principal, ok := PrincipalFromContext(request.Context())
+			if !ok {
+				utils.WriteError(writer, http.StatusUnauthorized, fmt.Errorf("unauthorized"))
+				return
+			}
+
+			if principal.IsUser(chi.URLParam(request, "id")) {
+				next.ServeHTTP(writer, request)
+				return
+			}
+
+			allowed, err := principal.Can(permission)
+			if err != nil || !allowed {
+				utils.WriteError(writer, http.StatusForbidden, fmt.Errorf("forbidden"))
+				return
+			}
+
+			next.ServeHTTP(writer, request)
+		})
+	}
+}
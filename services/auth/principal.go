@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/razdacoder/mcwale-api/models"
+)
+
+// Principal is the authenticated caller attached to the request context by
+// IsLoggedIn. Callers using an API key have no user and are limited to the
+// key's scopes.
+type Principal struct {
+	UserID   uuid.UUID
+	Role     models.UserRole
+	TokenID  string
+	APIKeyID *uuid.UUID
+	Scopes   []string
+}
+
+func (principal *Principal) IsAPIKey() bool {
+	return principal.APIKeyID != nil
+}
+
+func (principal *Principal) IsAdmin() bool {
+	return !principal.IsAPIKey() && principal.Role == models.Admin
+}
+
+func (principal *Principal) IsUser(id string) bool {
+	return !principal.IsAPIKey() && principal.UserID.String() == id
+}
+
+func (principal *Principal) Can(permission string) (bool, error) {
+	if principal.IsAPIKey() {
+		for _, scope := range principal.Scopes {
+			if scope == permission {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return HasPermission(principal.Role, permission)
+}
+
+type ContextKey string
+
+const principalKey ContextKey = "principal"
+
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey, principal)
+}
+
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey).(*Principal)
+	return principal, ok && principal != nil
+}
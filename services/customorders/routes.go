@@ -0,0 +1,467 @@
+package customorders
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/razdacoder/mcwale-api/mailer"
+	"github.com/razdacoder/mcwale-api/models"
+	"github.com/razdacoder/mcwale-api/services/appointments"
+	"github.com/razdacoder/mcwale-api/services/audit"
+	"github.com/razdacoder/mcwale-api/services/auth"
+	"github.com/razdacoder/mcwale-api/utils"
+)
+
+// purposeViewCustomOrder marks the tokens emailed to customers that let
+// them follow a request and answer its quote without signing in.
+const purposeViewCustomOrder = "custom_order_view"
+
+// viewLinkLifetime is how long an emailed link to a request keeps working.
+// A new one is sent with each update.
+const viewLinkLifetime = 90 * 24 * time.Hour
+
+type Handler struct {
+	store  CustomOrderStore
+	booker AppointmentBooker
+	mailer mailer.Mailer
+	audit  audit.Recorder
+}
+
+func NewHandler(store CustomOrderStore, booker AppointmentBooker, mailer mailer.Mailer, recorder audit.Recorder) *Handler {
+	return &Handler{
+		store:  store,
+		booker: booker,
+		mailer: mailer,
+		audit:  recorder,
+	}
+}
+
+func customOrdersRouter(handler *Handler) chi.Router {
+	router := chi.NewRouter()
+	write := auth.RequirePermission(models.PermCustomOrdersWrite)
+
+	router.With(auth.OptionalLogin).Post("/", handler.handleCreateCustomOrder)
+	router.With(auth.IsLoggedIn, auth.RequirePermission(models.PermCustomOrdersRead)).Get("/", handler.handleGetCustomOrders)
+	router.With(auth.IsLoggedIn).Get("/mine", handler.handleGetMyCustomOrders)
+
+	router.Route("/{id}", func(router chi.Router) {
+		router.With(auth.OptionalLogin).Get("/", handler.handleGetCustomOrder)
+		router.With(auth.OptionalLogin).Post("/accept", handler.handleAcceptQuote)
+		router.With(auth.OptionalLogin).Post("/decline", handler.handleDeclineQuote)
+		router.With(auth.IsLoggedIn, write).Post("/quote", handler.handleQuote)
+		router.With(auth.IsLoggedIn, write).Post("/cancel", handler.handleCancel)
+		router.With(auth.IsLoggedIn, write).Put("/stage", handler.handleSetStage)
+	})
+
+	return router
+}
+
+func (handler *Handler) RegisterRoutes(router chi.Router) {
+	router.Mount("/custom-orders", customOrdersRouter(handler))
+}
+
+// handleCreateCustomOrder takes a request for a made-to-measure piece in one
+// of a category's styles, optionally booking a measurement appointment.
+func (handler *Handler) handleCreateCustomOrder(writer http.ResponseWriter, request *http.Request) {
+	var payload CreateCustomOrderPayload
+	if err := utils.ParseJSON(request, &payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	category, err := handler.store.GetCategory(payload.CategoryID)
+	if err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("category not found"))
+		return
+	}
+	if !slices.Contains(category.Styles, payload.Style) {
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("%s is not one of the %s styles", payload.Style, category.Title))
+		return
+	}
+
+	order := &models.CustomOrder{
+		Reference:       "BSP-" + strings.ToUpper(strings.ReplaceAll(uuid.NewString(), "-", "")[:10]),
+		FirstName:       payload.FirstName,
+		LastName:        payload.LastName,
+		Email:           payload.Email,
+		PhoneNumber:     payload.PhoneNumber,
+		Address:         payload.Address,
+		CategoryID:      category.ID,
+		Style:           payload.Style,
+		ReferenceImages: payload.ReferenceImages,
+		Notes:           payload.Notes,
+		PreferredDates:  payload.PreferredDates,
+		Status:          models.CustomOrderRequested,
+	}
+	if order.ReferenceImages == nil {
+		order.ReferenceImages = []string{}
+	}
+	if order.PreferredDates == nil {
+		order.PreferredDates = []string{}
+	}
+	if payload.NeededBy != "" {
+		neededBy, _ := time.Parse(time.DateOnly, payload.NeededBy)
+		order.NeededBy = &neededBy
+	}
+	if principal, ok := auth.PrincipalFromContext(request.Context()); ok && !principal.IsAPIKey() {
+		order.UserID = &principal.UserID
+	}
+
+	// The appointment is booked first so a slot that has gone does not leave
+	// a request behind expecting it, and cancelled again if the request
+	// cannot be saved.
+	if payload.Appointment != nil {
+		appointment, _, err := handler.booker.Book(request, appointments.CreateAppointmentPayload{
+			FirstName:         payload.FirstName,
+			LastName:          payload.LastName,
+			Email:             payload.Email,
+			PhoneNumber:       payload.PhoneNumber,
+			Address:           payload.Address,
+			Date:              payload.Appointment.Date,
+			StaffID:           payload.Appointment.StaffID,
+			AppointmentTypeID: payload.Appointment.AppointmentTypeID,
+		})
+		if err != nil {
+			utils.WriteError(writer, appointments.BookingErrorStatus(err), fmt.Errorf("measurement appointment: %v", err))
+			return
+		}
+		order.AppointmentID = &appointment.ID
+		order.Appointment = appointment
+	}
+
+	if err := handler.store.CreateCustomOrder(order); err != nil {
+		if order.Appointment != nil {
+			if err := handler.booker.Cancel(request, order.Appointment); err != nil {
+				log.Printf("failed to cancel appointment %s for unsaved custom order: %v", order.Appointment.ID, err)
+			}
+		}
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	order.Category = category
+	handler.audit.Record(request, "custom_order.create", "custom_order", order.ID.String(), nil, order)
+	handler.notify(order, "Your request for a made-to-measure piece has been received",
+		fmt.Sprintf("Thank you for your request for a %s %s. We will be in touch with a quote soon.", order.Style, category.Title))
+
+	utils.WriteJSON(writer, http.StatusCreated, order)
+}
+
+func (handler *Handler) handleGetCustomOrders(writer http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+	page := utils.ParseStringToInt(query.Get("page"), 0)
+	perPage := utils.ParseStringToInt(os.Getenv("PER_PAGE"), 10)
+	if page == 0 {
+		page = 1
+	}
+
+	orders, total, err := handler.store.GetCustomOrders(CustomOrderFilter{
+		Status: query.Get("status"),
+		Stage:  query.Get("stage"),
+		Offset: (page - 1) * perPage,
+		Limit:  perPage,
+	})
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	pages := int(math.Ceil(float64(total) / float64(perPage)))
+	utils.WriteJSON(writer, http.StatusOK, map[string]any{"results": len(orders), "page": page, "pages": pages, "total": total, "data": orders})
+}
+
+func (handler *Handler) handleGetMyCustomOrders(writer http.ResponseWriter, request *http.Request) {
+	principal, _ := auth.PrincipalFromContext(request.Context())
+	if principal.IsAPIKey() {
+		utils.WriteError(writer, http.StatusForbidden, fmt.Errorf("forbidden"))
+		return
+	}
+
+	email, err := handler.store.GetVerifiedEmail(principal.UserID)
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("user not found"))
+		return
+	}
+
+	orders, err := handler.store.GetCustomerCustomOrders(principal.UserID, email)
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, orders)
+}
+
+func (handler *Handler) handleGetCustomOrder(writer http.ResponseWriter, request *http.Request) {
+	order, ok := handler.customOrderFor(writer, request, models.PermCustomOrdersRead)
+	if !ok {
+		return
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, order)
+}
+
+// handleQuote prices a request and gives the date it will be ready by.
+// Quoting again replaces an earlier quote the customer has not answered.
+func (handler *Handler) handleQuote(writer http.ResponseWriter, request *http.Request) {
+	var payload QuotePayload
+	if err := utils.ParseJSON(request, &payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	order, err := handler.store.GetCustomOrder(chi.URLParam(request, "id"))
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("custom order not found"))
+		return
+	}
+	if !order.Status.CanTransitionTo(models.CustomOrderQuoted) {
+		utils.WriteError(writer, http.StatusConflict, fmt.Errorf("a %s request cannot be quoted", order.Status))
+		return
+	}
+	readyBy, _ := time.Parse(time.DateOnly, payload.ReadyBy)
+	now := time.Now()
+	if readyBy.Before(now) {
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("ready_by must be in the future"))
+		return
+	}
+
+	before := *order
+	order.Status = models.CustomOrderQuoted
+	order.QuotedPrice = &payload.Price
+	order.DepositAmount = &payload.DepositAmount
+	order.ReadyBy = &readyBy
+	order.QuoteNotes = payload.Notes
+	order.QuotedAt = &now
+	if err := handler.store.SaveQuote(order); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	handler.audit.Record(request, "custom_order.quote", "custom_order", order.ID.String(), before, order)
+
+	message := fmt.Sprintf("We can make your %s for %.2f, ready by %s.", order.Style, payload.Price, readyBy.Format("2 January 2006"))
+	if payload.DepositAmount > 0 {
+		message += fmt.Sprintf(" A deposit of %.2f is due when you accept.", payload.DepositAmount)
+	}
+	if payload.Notes != "" {
+		message += "\n\n" + payload.Notes
+	}
+	handler.notify(order, "Your made-to-measure quote", message+"\n\nYou can accept or decline the quote using the link below.")
+
+	utils.WriteJSON(writer, http.StatusOK, order)
+}
+
+// handleAcceptQuote turns a quote into orders the customer pays like any
+// other: one for the deposit, when there is one, and one for the balance.
+func (handler *Handler) handleAcceptQuote(writer http.ResponseWriter, request *http.Request) {
+	order, ok := handler.customOrderFor(writer, request, models.PermCustomOrdersWrite)
+	if !ok {
+		return
+	}
+	if !order.Status.CanTransitionTo(models.CustomOrderAccepted) {
+		utils.WriteError(writer, http.StatusConflict, fmt.Errorf("a %s request cannot be accepted", order.Status))
+		return
+	}
+
+	before := *order
+	now := time.Now()
+	deposit := *order.DepositAmount
+	description := fmt.Sprintf("made-to-measure %s (%s)", order.Style, order.Reference)
+	balance := handler.newOrder(order, "BSP", *order.QuotedPrice-deposit, "Balance for "+description)
+	var depositOrder *models.Order
+	if deposit > 0 {
+		depositOrder = handler.newOrder(order, "DEP", deposit, "Deposit for "+description)
+	}
+
+	order.Status = models.CustomOrderAccepted
+	order.AcceptedAt = &now
+	if err := handler.store.AcceptQuote(order, balance, depositOrder); err != nil {
+		if errors.Is(err, ErrQuoteNotOpen) {
+			utils.WriteError(writer, http.StatusConflict, err)
+			return
+		}
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	order.Order = balance
+	order.DepositOrder = depositOrder
+	handler.audit.Record(request, "custom_order.accept", "custom_order", order.ID.String(), before, order)
+
+	message := fmt.Sprintf("Thank you for accepting our quote. Your order number is %s.", balance.OrderNumber)
+	if depositOrder != nil {
+		message += fmt.Sprintf(" Please pay the deposit using order number %s so we can begin.", depositOrder.OrderNumber)
+	}
+	handler.notify(order, "Your made-to-measure order", message)
+
+	response := map[string]any{"message": "Quote Accepted", "order_number": balance.OrderNumber}
+	if depositOrder != nil {
+		response["deposit_order_number"] = depositOrder.OrderNumber
+	}
+	utils.WriteJSON(writer, http.StatusOK, response)
+}
+
+func (handler *Handler) handleDeclineQuote(writer http.ResponseWriter, request *http.Request) {
+	order, ok := handler.customOrderFor(writer, request, models.PermCustomOrdersWrite)
+	if !ok {
+		return
+	}
+	handler.updateStatus(writer, request, order, models.CustomOrderDeclined)
+}
+
+func (handler *Handler) handleCancel(writer http.ResponseWriter, request *http.Request) {
+	order, err := handler.store.GetCustomOrder(chi.URLParam(request, "id"))
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("custom order not found"))
+		return
+	}
+	handler.updateStatus(writer, request, order, models.CustomOrderCancelled)
+}
+
+func (handler *Handler) updateStatus(writer http.ResponseWriter, request *http.Request, order *models.CustomOrder, next models.CustomOrderStatus) {
+	if !order.Status.CanTransitionTo(next) {
+		utils.WriteError(writer, http.StatusConflict, fmt.Errorf("cannot change a %s request to %s", order.Status, next))
+		return
+	}
+
+	before := *order
+	order.Status = next
+	if err := handler.store.UpdateStatus(order); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	handler.audit.Record(request, "custom_order.update_status", "custom_order", order.ID.String(), before, order)
+
+	utils.WriteJSON(writer, http.StatusOK, order)
+}
+
+// handleSetStage moves an accepted order through production, letting the
+// customer know when it is ready.
+func (handler *Handler) handleSetStage(writer http.ResponseWriter, request *http.Request) {
+	var payload StagePayload
+	if err := utils.ParseJSON(request, &payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	order, err := handler.store.GetCustomOrder(chi.URLParam(request, "id"))
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("custom order not found"))
+		return
+	}
+	if order.Status != models.CustomOrderAccepted {
+		utils.WriteError(writer, http.StatusConflict, fmt.Errorf("production starts once the quote is accepted"))
+		return
+	}
+	next := models.ProductionStage(payload.Stage)
+	if !order.Stage.CanMoveTo(next) {
+		utils.WriteError(writer, http.StatusConflict, fmt.Errorf("cannot move from %q to %s", order.Stage, next))
+		return
+	}
+
+	before := order.Stage
+	order.Stage = next
+	stage := &models.CustomOrderStage{Stage: next, Note: payload.Note}
+	if err := handler.store.AddStage(order, stage); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	order.Stages = append(order.Stages, *stage)
+	handler.audit.Record(request, "custom_order.stage", "custom_order", order.ID.String(),
+		map[string]any{"stage": before}, map[string]any{"stage": next, "note": payload.Note})
+
+	if next == models.StageReady {
+		handler.notify(order, "Your made-to-measure piece is ready",
+			fmt.Sprintf("Good news: your %s is ready. We will be in touch to arrange collection or delivery.", order.Style))
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, order)
+}
+
+// customOrderFor loads the request in the URL for whoever may see it: the
+// customer who made it, anyone holding the emailed link's ?token=, or staff
+// with the given permission. It writes the error response itself.
+func (handler *Handler) customOrderFor(writer http.ResponseWriter, request *http.Request, permission string) (*models.CustomOrder, bool) {
+	id := chi.URLParam(request, "id")
+	order, err := handler.store.GetCustomOrder(id)
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("custom order not found"))
+		return nil, false
+	}
+
+	if principal, ok := auth.PrincipalFromContext(request.Context()); ok {
+		if order.UserID != nil && principal.IsUser(order.UserID.String()) {
+			return order, true
+		}
+		if allowed, _ := principal.Can(permission); allowed {
+			return order, true
+		}
+	}
+	if claims, err := auth.ParseToken(request.URL.Query().Get("token"), purposeViewCustomOrder); err == nil && claims.Subject == id {
+		return order, true
+	}
+
+	utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("custom order not found"))
+	return nil, false
+}
+
+func (handler *Handler) newOrder(order *models.CustomOrder, prefix string, total float64, note string) *models.Order {
+	return &models.Order{
+		ID:          uuid.New(),
+		OrderNumber: prefix + "-" + strings.ToUpper(strings.ReplaceAll(uuid.NewString(), "-", "")[:10]),
+		UserID:      order.UserID,
+		FirstName:   order.FirstName,
+		LastName:    order.LastName,
+		Email:       order.Email,
+		PhoneNumber: order.PhoneNumber,
+		Address1:    order.Address,
+		OrderNote:   note,
+		Total:       total,
+	}
+}
+
+// notify emails the customer an update with a link to follow their
+// request. The change stands even if this fails.
+func (handler *Handler) notify(order *models.CustomOrder, subject string, message string) {
+	token, err := auth.CreatePurposeJWT(order.ID.String(), purposeViewCustomOrder, viewLinkLifetime, nil)
+	if err != nil {
+		log.Printf("failed to create link for custom order %s: %v", order.ID, err)
+		return
+	}
+	link := mailer.Link("/custom-orders/" + order.ID.String() + "?token=" + token)
+
+	err = handler.mailer.Send(mailer.Message{
+		To:      order.Email,
+		Subject: subject,
+		Body:    fmt.Sprintf("Hi %s,\n\n%s\n\nReference: %s\n%s", order.FirstName, message, order.Reference, link),
+	})
+	if err != nil {
+		log.Printf("failed to email custom order %s: %v", order.ID, err)
+	}
+}
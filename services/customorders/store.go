@@ -0,0 +1,136 @@
+package customorders
+
+import (
+	"github.com/google/uuid"
+	"github.com/razdacoder/mcwale-api/models"
+	"gorm.io/gorm"
+)
+
+type Store struct {
+	db *gorm.DB
+}
+
+func NewStore(db *gorm.DB) *Store {
+	return &Store{
+		db: db,
+	}
+}
+
+func (store *Store) GetCategory(id uuid.UUID) (*models.Category, error) {
+	var category models.Category
+	if err := store.db.Where("id = ?", id).First(&category).Error; err != nil {
+		return nil, err
+	}
+	return &category, nil
+}
+
+func (store *Store) CreateCustomOrder(order *models.CustomOrder) error {
+	return store.db.Omit("Category", "Appointment").Create(order).Error
+}
+
+func (store *Store) GetCustomOrder(id string) (*models.CustomOrder, error) {
+	var order models.CustomOrder
+	err := store.db.Preload("Category").
+		Preload("Appointment").
+		Preload("Order").
+		Preload("DepositOrder").
+		Preload("Stages", func(db *gorm.DB) *gorm.DB { return db.Order("created_at") }).
+		Where("id = ?", id).
+		First(&order).Error
+	if err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+func (store *Store) GetCustomOrders(filter CustomOrderFilter) ([]models.CustomOrder, int64, error) {
+	var orders []models.CustomOrder
+	var total int64
+	db := store.db.Model(&models.CustomOrder{})
+
+	if filter.Status != "" {
+		db = db.Where("status = ?", filter.Status)
+	}
+	if filter.Stage != "" {
+		db = db.Where("stage = ?", filter.Stage)
+	}
+
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	result := db.Preload("Category").Order("created_at DESC").Offset(filter.Offset).Limit(filter.Limit).Find(&orders)
+	return orders, total, result.Error
+}
+
+// GetCustomerCustomOrders returns a customer's requests, including those
+// made with their email before they signed in when it is verified. email is
+// empty otherwise.
+func (store *Store) GetCustomerCustomOrders(userID uuid.UUID, email string) ([]models.CustomOrder, error) {
+	var orders []models.CustomOrder
+	db := store.db.Where("user_id = ?", userID)
+	if email != "" {
+		db = store.db.Where("user_id = ? OR email = ?", userID, email)
+	}
+	err := db.Preload("Category").Order("created_at DESC").Find(&orders).Error
+	return orders, err
+}
+
+// GetVerifiedEmail returns the user's email, or empty if they have not
+// verified it.
+func (store *Store) GetVerifiedEmail(userID uuid.UUID) (string, error) {
+	var user models.User
+	if err := store.db.Select("email", "email_verified_at").Where("id = ?", userID).First(&user).Error; err != nil {
+		return "", err
+	}
+	return user.VerifiedEmail(), nil
+}
+
+func (store *Store) SaveQuote(order *models.CustomOrder) error {
+	return store.db.Model(order).
+		Select("status", "quoted_price", "deposit_amount", "ready_by", "quote_notes", "quoted_at").
+		Updates(order).Error
+}
+
+func (store *Store) UpdateStatus(order *models.CustomOrder) error {
+	return store.db.Model(order).Select("status").Updates(order).Error
+}
+
+// AcceptQuote raises the orders for an accepted quote and records them
+// against the request, all or nothing. It returns ErrQuoteNotOpen if the
+// request is no longer quoted, so two acceptances cannot both raise orders.
+func (store *Store) AcceptQuote(order *models.CustomOrder, balance *models.Order, deposit *models.Order) error {
+	return store.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(balance).Error; err != nil {
+			return err
+		}
+		order.OrderID = &balance.ID
+		if deposit != nil {
+			if err := tx.Create(deposit).Error; err != nil {
+				return err
+			}
+			order.DepositOrderID = &deposit.ID
+		}
+		result := tx.Model(order).
+			Where("status = ?", models.CustomOrderQuoted).
+			Select("status", "order_id", "deposit_order_id", "accepted_at").
+			Updates(order)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrQuoteNotOpen
+		}
+		return nil
+	})
+}
+
+func (store *Store) AddStage(order *models.CustomOrder, stage *models.CustomOrderStage) error {
+	return store.db.Transaction(func(tx *gorm.DB) error {
+		stage.CustomOrderID = order.ID
+		if err := tx.Create(stage).Error; err != nil {
+			return err
+		}
+		return tx.Model(order).Select("stage").Updates(order).Error
+	})
+}
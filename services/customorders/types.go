@@ -0,0 +1,81 @@
+package customorders
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/razdacoder/mcwale-api/models"
+	"github.com/razdacoder/mcwale-api/services/appointments"
+)
+
+// ErrQuoteNotOpen is returned when a quote is accepted after it has already
+// been answered or withdrawn.
+var ErrQuoteNotOpen = errors.New("this quote is no longer open")
+
+type CustomOrderStore interface {
+	GetCategory(id uuid.UUID) (*models.Category, error)
+	CreateCustomOrder(order *models.CustomOrder) error
+	GetCustomOrder(id string) (*models.CustomOrder, error)
+	GetCustomOrders(filter CustomOrderFilter) ([]models.CustomOrder, int64, error)
+	GetCustomerCustomOrders(userID uuid.UUID, email string) ([]models.CustomOrder, error)
+	GetVerifiedEmail(userID uuid.UUID) (string, error)
+	SaveQuote(order *models.CustomOrder) error
+	UpdateStatus(order *models.CustomOrder) error
+	AcceptQuote(order *models.CustomOrder, balance *models.Order, deposit *models.Order) error
+	AddStage(order *models.CustomOrder, stage *models.CustomOrderStage) error
+}
+
+// AppointmentBooker books the measurement appointment a request can ask
+// for, and cancels it again if the request cannot be saved.
+type AppointmentBooker interface {
+	Book(request *http.Request, payload appointments.CreateAppointmentPayload) (*models.Appointment, *models.Order, error)
+	Cancel(request *http.Request, appointment *models.Appointment) error
+}
+
+type CustomOrderFilter struct {
+	Status string
+	Stage  string
+	Offset int
+	Limit  int
+}
+
+// CreateCustomOrderPayload is a customer's request for a made-to-measure
+// piece. ReferenceImages are URLs of images already uploaded, as for
+// products. Dates are given as YYYY-MM-DD.
+type CreateCustomOrderPayload struct {
+	FirstName       string    `json:"first_name" validate:"required"`
+	LastName        string    `json:"last_name" validate:"required"`
+	Email           string    `json:"email" validate:"required,email"`
+	PhoneNumber     string    `json:"phone_number" validate:"required"`
+	Address         string    `json:"address" validate:"required"`
+	CategoryID      uuid.UUID `json:"category_id" validate:"required"`
+	Style           string    `json:"style" validate:"required"`
+	ReferenceImages []string  `json:"reference_images" validate:"max=10,dive,url"`
+	Notes           string    `json:"notes"`
+	PreferredDates  []string  `json:"preferred_dates" validate:"max=5,dive,datetime=2006-01-02"`
+	NeededBy        string    `json:"needed_by" validate:"omitempty,datetime=2006-01-02"`
+
+	// Appointment, when given, books a measurement appointment alongside
+	// the request.
+	Appointment *MeasurementAppointmentPayload `json:"appointment"`
+}
+
+type MeasurementAppointmentPayload struct {
+	Date              time.Time  `json:"date" validate:"required"`
+	StaffID           *uuid.UUID `json:"staff_id"`
+	AppointmentTypeID *uuid.UUID `json:"appointment_type_id"`
+}
+
+type QuotePayload struct {
+	Price         float64 `json:"price" validate:"required,gt=0"`
+	DepositAmount float64 `json:"deposit_amount" validate:"min=0,ltefield=Price"`
+	ReadyBy       string  `json:"ready_by" validate:"required,datetime=2006-01-02"`
+	Notes         string  `json:"notes"`
+}
+
+type StagePayload struct {
+	Stage string `json:"stage" validate:"required,oneof=cutting sewing fitting ready"`
+	Note  string `json:"note"`
+}
@@ -0,0 +1,64 @@
+package jobs
+
+import (
+	"math"
+	"net/http"
+	"os"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/razdacoder/mcwale-api/models"
+	"github.com/razdacoder/mcwale-api/services/auth"
+	"github.com/razdacoder/mcwale-api/utils"
+)
+
+type Handler struct {
+	store JobStore
+}
+
+func NewHandler(store JobStore) *Handler {
+	return &Handler{
+		store: store,
+	}
+}
+
+func jobsRouter(handler *Handler) chi.Router {
+	router := chi.NewRouter()
+	router.Use(auth.IsLoggedIn, auth.RequirePermission(models.PermJobsRead))
+
+	router.Get("/", handler.handleGetJobs)
+	router.Get("/{job}/runs", handler.handleGetRuns)
+
+	return router
+}
+
+func (handler *Handler) RegisterRoutes(router chi.Router) {
+	router.Mount("/jobs", jobsRouter(handler))
+}
+
+// handleGetJobs shows the latest run of each background job.
+func (handler *Handler) handleGetJobs(writer http.ResponseWriter, request *http.Request) {
+	runs, err := handler.store.GetLatestRuns()
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, runs)
+}
+
+func (handler *Handler) handleGetRuns(writer http.ResponseWriter, request *http.Request) {
+	page := utils.ParseStringToInt(request.URL.Query().Get("page"), 0)
+	perPage := utils.ParseStringToInt(os.Getenv("PER_PAGE"), 10)
+	if page == 0 {
+		page = 1
+	}
+
+	runs, total, err := handler.store.GetRuns(chi.URLParam(request, "job"), (page-1)*perPage, perPage)
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	pages := int(math.Ceil(float64(total) / float64(perPage)))
+	utils.WriteJSON(writer, http.StatusOK, map[string]any{"results": len(runs), "page": page, "pages": pages, "total": total, "data": runs})
+}
@@ -0,0 +1,79 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/razdacoder/mcwale-api/models"
+)
+
+// runRetention is how long job runs are kept for admins to look back on.
+const runRetention = 7 * 24 * time.Hour
+
+type Runner struct {
+	store JobStore
+	host  string
+	jobs  []Job
+}
+
+func NewRunner(store JobStore) *Runner {
+	host, _ := os.Hostname()
+	return &Runner{
+		store: store,
+		host:  host,
+	}
+}
+
+func (runner *Runner) Add(job Job) {
+	runner.jobs = append(runner.jobs, job)
+}
+
+// Start runs every job on its interval until ctx is cancelled, then waits
+// for any pass in progress to finish.
+func (runner *Runner) Start(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, job := range runner.jobs {
+		wg.Add(1)
+		go func(job Job) {
+			defer wg.Done()
+			ticker := time.NewTicker(job.Interval)
+			defer ticker.Stop()
+			for {
+				runner.runOnce(ctx, job)
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}(job)
+	}
+	wg.Wait()
+}
+
+func (runner *Runner) runOnce(ctx context.Context, job Job) {
+	run := &models.JobRun{Job: job.Name, Host: runner.host, StartedAt: time.Now()}
+	if err := runner.store.StartRun(run); err != nil {
+		log.Printf("job %s: failed to record run: %v", job.Name, err)
+	}
+
+	result, err := job.Run(ctx)
+	finished := time.Now()
+	run.FinishedAt = &finished
+	run.Processed = result.Processed
+	run.Failed = result.Failed
+	if err != nil {
+		run.Error = err.Error()
+		log.Printf("job %s: %v", job.Name, err)
+	}
+	if err := runner.store.FinishRun(run); err != nil {
+		log.Printf("job %s: failed to record run: %v", job.Name, err)
+	}
+
+	if err := runner.store.PruneRuns(finished.Add(-runRetention)); err != nil {
+		log.Printf("job %s: failed to prune runs: %v", job.Name, err)
+	}
+}
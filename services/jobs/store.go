@@ -0,0 +1,48 @@
+package jobs
+
+import (
+	"time"
+
+	"github.com/razdacoder/mcwale-api/models"
+	"gorm.io/gorm"
+)
+
+type Store struct {
+	db *gorm.DB
+}
+
+func NewStore(db *gorm.DB) *Store {
+	return &Store{
+		db: db,
+	}
+}
+
+func (store *Store) StartRun(run *models.JobRun) error {
+	return store.db.Create(run).Error
+}
+
+func (store *Store) FinishRun(run *models.JobRun) error {
+	return store.db.Model(run).Select("finished_at", "processed", "failed", "error").Updates(run).Error
+}
+
+// GetLatestRuns returns the most recent run of every job.
+func (store *Store) GetLatestRuns() ([]models.JobRun, error) {
+	var runs []models.JobRun
+	err := store.db.Raw(`SELECT DISTINCT ON (job) * FROM job_runs ORDER BY job, started_at DESC`).Scan(&runs).Error
+	return runs, err
+}
+
+func (store *Store) GetRuns(job string, offset, limit int) ([]models.JobRun, int64, error) {
+	var runs []models.JobRun
+	var total int64
+	query := store.db.Model(&models.JobRun{}).Where("job = ?", job)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := query.Order("started_at DESC").Offset(offset).Limit(limit).Find(&runs).Error
+	return runs, total, err
+}
+
+func (store *Store) PruneRuns(before time.Time) error {
+	return store.db.Where("started_at < ?", before).Delete(&models.JobRun{}).Error
+}
@@ -0,0 +1,30 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/razdacoder/mcwale-api/models"
+)
+
+type JobStore interface {
+	StartRun(run *models.JobRun) error
+	FinishRun(run *models.JobRun) error
+	GetLatestRuns() ([]models.JobRun, error)
+	GetRuns(job string, offset, limit int) ([]models.JobRun, int64, error)
+	PruneRuns(before time.Time) error
+}
+
+// Result is what a single pass of a job got through.
+type Result struct {
+	Processed int
+	Failed    int
+}
+
+// Job is a piece of background work run every Interval. Jobs must be safe
+// to run on several replicas at once.
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) (Result, error)
+}
@@ -0,0 +1,317 @@
+package measurements
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/razdacoder/mcwale-api/models"
+	"github.com/razdacoder/mcwale-api/services/audit"
+	"github.com/razdacoder/mcwale-api/services/auth"
+	"github.com/razdacoder/mcwale-api/utils"
+)
+
+type Handler struct {
+	store MeasurementStore
+	audit audit.Recorder
+}
+
+func NewHandler(store MeasurementStore, recorder audit.Recorder) *Handler {
+	return &Handler{
+		store: store,
+		audit: recorder,
+	}
+}
+
+func measurementsRouter(handler *Handler) chi.Router {
+	router := chi.NewRouter()
+	router.Use(auth.IsLoggedIn)
+	read := auth.RequirePermission(models.PermMeasurementsRead)
+	write := auth.RequirePermission(models.PermMeasurementsWrite)
+
+	router.Get("/me", handler.handleGetMyMeasurements)
+	router.With(read).Get("/", handler.handleGetSets)
+	router.With(write).Post("/", handler.handleCreateSet)
+	router.With(read).Get("/{id}", handler.handleGetSet)
+	router.With(write).Patch("/{id}", handler.handleRenameSet)
+	router.With(write).Post("/{id}/revisions", handler.handleAddRevision)
+
+	return router
+}
+
+func (handler *Handler) RegisterRoutes(router chi.Router) {
+	router.Mount("/measurements", measurementsRouter(handler))
+}
+
+// handleGetMyMeasurements shows signed in customers their current
+// measurements, including any taken before they had an account.
+func (handler *Handler) handleGetMyMeasurements(writer http.ResponseWriter, request *http.Request) {
+	principal, _ := auth.PrincipalFromContext(request.Context())
+	if principal.IsAPIKey() {
+		utils.WriteError(writer, http.StatusForbidden, fmt.Errorf("forbidden"))
+		return
+	}
+
+	user, err := handler.store.GetUser(principal.UserID)
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("user not found"))
+		return
+	}
+
+	sets, err := handler.store.GetSets(&user.ID, user.VerifiedEmail())
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, sets)
+}
+
+// handleGetSets lists a customer's measurement sets, picked by ?user_id=,
+// ?email= or the customer of ?appointment_id=.
+func (handler *Handler) handleGetSets(writer http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+	email := query.Get("email")
+
+	var userID *uuid.UUID
+	if value := query.Get("user_id"); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid user_id"))
+			return
+		}
+		userID = &id
+	}
+	if value := query.Get("appointment_id"); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid appointment_id"))
+			return
+		}
+		appointment, err := handler.store.GetAppointment(id)
+		if err != nil {
+			utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("appointment not found"))
+			return
+		}
+		email = appointment.Email
+	}
+	if userID == nil && email == "" {
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("user_id, email or appointment_id is required"))
+		return
+	}
+
+	sets, err := handler.store.GetSets(userID, email)
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, sets)
+}
+
+func (handler *Handler) handleGetSet(writer http.ResponseWriter, request *http.Request) {
+	set, err := handler.store.GetSet(chi.URLParam(request, "id"))
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("measurement set not found"))
+		return
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, set)
+}
+
+func (handler *Handler) handleCreateSet(writer http.ResponseWriter, request *http.Request) {
+	var payload CreateSetPayload
+	if err := utils.ParseJSON(request, &payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	set, err := handler.newSet(payload)
+	if err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+	revision, err := handler.newRevision(request, set, payload.RevisionPayload)
+	if err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := handler.store.CreateSet(set, revision); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	set.Latest = revision
+	handler.audit.Record(request, "measurements.create", "measurement_set", set.ID.String(), nil, set)
+
+	utils.WriteJSON(writer, http.StatusCreated, set)
+}
+
+func (handler *Handler) handleRenameSet(writer http.ResponseWriter, request *http.Request) {
+	var payload RenameSetPayload
+	if err := utils.ParseJSON(request, &payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	set, err := handler.store.GetSet(chi.URLParam(request, "id"))
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("measurement set not found"))
+		return
+	}
+	before := set.Name
+
+	set.Name = payload.Name
+	if err := handler.store.RenameSet(set); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	handler.audit.Record(request, "measurements.rename", "measurement_set", set.ID.String(),
+		map[string]any{"name": before}, map[string]any{"name": set.Name})
+
+	utils.WriteJSON(writer, http.StatusOK, set)
+}
+
+// handleAddRevision records measurements taken again. The earlier
+// revisions are kept as the set's history.
+func (handler *Handler) handleAddRevision(writer http.ResponseWriter, request *http.Request) {
+	var payload RevisionPayload
+	if err := utils.ParseJSON(request, &payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	set, err := handler.store.GetSet(chi.URLParam(request, "id"))
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("measurement set not found"))
+		return
+	}
+
+	revision, err := handler.newRevision(request, set, payload)
+	if err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+	if err := handler.store.AddRevision(set, revision); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	handler.audit.Record(request, "measurements.revise", "measurement_set", set.ID.String(), set.Latest, revision)
+
+	utils.WriteJSON(writer, http.StatusCreated, revision)
+}
+
+// newSet works out whose measurements these are. A set is tied to a user
+// account whenever one exists for the customer's email, so it shows up for
+// them even if it was recorded against an appointment.
+func (handler *Handler) newSet(payload CreateSetPayload) (*models.MeasurementSet, error) {
+	set := &models.MeasurementSet{Name: payload.Name, Email: payload.Email}
+
+	if payload.AppointmentID != nil && set.Email == "" {
+		appointment, err := handler.store.GetAppointment(*payload.AppointmentID)
+		if err != nil {
+			return nil, fmt.Errorf("appointment not found")
+		}
+		set.Email = appointment.Email
+	}
+
+	if payload.UserID != nil {
+		user, err := handler.store.GetUser(*payload.UserID)
+		if err != nil {
+			return nil, fmt.Errorf("user not found")
+		}
+		set.UserID = &user.ID
+		if set.Email == "" {
+			set.Email = user.Email
+		}
+	} else if set.Email != "" {
+		// Only an account that has verified the email is linked to it.
+		if user, err := handler.store.GetUserByEmail(set.Email); err == nil && user.VerifiedEmail() != "" {
+			set.UserID = &user.ID
+		}
+	}
+
+	if set.UserID == nil && set.Email == "" {
+		return nil, fmt.Errorf("user_id, email or appointment_id is required")
+	}
+	return set, nil
+}
+
+// newRevision builds the next revision of a set. Anything not measured
+// this time is carried over from the revision taken just before it,
+// converted to this revision's unit, so back-dated measurements are not
+// filled in from later ones. set must have its revisions loaded, newest
+// first.
+func (handler *Handler) newRevision(request *http.Request, set *models.MeasurementSet, payload RevisionPayload) (*models.MeasurementRevision, error) {
+	now := time.Now()
+	revision := &models.MeasurementRevision{
+		Unit:          payload.Unit,
+		Values:        models.Measurements{},
+		Notes:         payload.Notes,
+		AppointmentID: payload.AppointmentID,
+		TakenAt:       now,
+		CreatedAt:     now,
+	}
+
+	if payload.TakenAt != nil {
+		if payload.TakenAt.After(now) {
+			return nil, fmt.Errorf("taken_at cannot be in the future")
+		}
+		revision.TakenAt = *payload.TakenAt
+	}
+
+	for _, previous := range set.Revisions {
+		if !previous.TakenAt.After(revision.TakenAt) {
+			revision.Values = previous.Values.Convert(previous.Unit, payload.Unit)
+			break
+		}
+	}
+	for name, value := range payload.Values {
+		revision.Values[name] = value
+	}
+
+	if payload.AppointmentID != nil {
+		appointment, err := handler.store.GetAppointment(*payload.AppointmentID)
+		if err != nil {
+			return nil, fmt.Errorf("appointment not found")
+		}
+		if set.Email != "" && appointment.Email != set.Email {
+			return nil, fmt.Errorf("appointment is for a different customer")
+		}
+	}
+
+	if payload.TakenByID != nil {
+		member, err := handler.store.GetStaffMember(*payload.TakenByID)
+		if err != nil {
+			return nil, fmt.Errorf("staff member not found")
+		}
+		revision.TakenByID = &member.ID
+	} else if principal, ok := auth.PrincipalFromContext(request.Context()); ok && !principal.IsAPIKey() {
+		if member, err := handler.store.GetStaffByUserID(principal.UserID); err == nil {
+			revision.TakenByID = &member.ID
+		}
+	}
+
+	return revision, nil
+}
@@ -0,0 +1,141 @@
+package measurements
+
+import (
+	"github.com/google/uuid"
+	"github.com/razdacoder/mcwale-api/models"
+	"gorm.io/gorm"
+)
+
+type Store struct {
+	db *gorm.DB
+}
+
+func NewStore(db *gorm.DB) *Store {
+	return &Store{
+		db: db,
+	}
+}
+
+// GetSets returns a customer's measurement sets, found by their account or
+// the email they book with, each with its latest revision.
+func (store *Store) GetSets(userID *uuid.UUID, email string) ([]models.MeasurementSet, error) {
+	query := store.db.Order("name")
+	switch {
+	case userID != nil && email != "":
+		query = query.Where("user_id = ? OR email = ?", *userID, email)
+	case userID != nil:
+		query = query.Where("user_id = ?", *userID)
+	default:
+		query = query.Where("email = ?", email)
+	}
+
+	var sets []models.MeasurementSet
+	if err := query.Find(&sets).Error; err != nil {
+		return nil, err
+	}
+	if len(sets) == 0 {
+		return sets, nil
+	}
+
+	ids := make([]uuid.UUID, 0, len(sets))
+	for _, set := range sets {
+		ids = append(ids, set.ID)
+	}
+	var latest []models.MeasurementRevision
+	err := store.db.Preload("TakenBy").
+		Select("DISTINCT ON (measurement_set_id) *").
+		Where("measurement_set_id IN ?", ids).
+		Order("measurement_set_id, taken_at DESC, created_at DESC").
+		Find(&latest).Error
+	if err != nil {
+		return nil, err
+	}
+
+	byID := map[uuid.UUID]*models.MeasurementRevision{}
+	for i := range latest {
+		byID[latest[i].MeasurementSetID] = &latest[i]
+	}
+	for i := range sets {
+		sets[i].Latest = byID[sets[i].ID]
+	}
+	return sets, nil
+}
+
+// GetSet returns a measurement set with its full history, newest first.
+func (store *Store) GetSet(id string) (*models.MeasurementSet, error) {
+	var set models.MeasurementSet
+	err := store.db.Preload("Revisions", func(db *gorm.DB) *gorm.DB {
+		return db.Order("taken_at DESC, created_at DESC")
+	}).Preload("Revisions.TakenBy").Where("id = ?", id).First(&set).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(set.Revisions) > 0 {
+		set.Latest = &set.Revisions[0]
+	}
+	return &set, nil
+}
+
+func (store *Store) CreateSet(set *models.MeasurementSet, revision *models.MeasurementRevision) error {
+	return store.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Revisions").Create(set).Error; err != nil {
+			return err
+		}
+		revision.MeasurementSetID = set.ID
+		return tx.Omit("TakenBy").Create(revision).Error
+	})
+}
+
+func (store *Store) RenameSet(set *models.MeasurementSet) error {
+	return store.db.Model(set).Select("name").Updates(set).Error
+}
+
+func (store *Store) AddRevision(set *models.MeasurementSet, revision *models.MeasurementRevision) error {
+	return store.db.Transaction(func(tx *gorm.DB) error {
+		revision.MeasurementSetID = set.ID
+		if err := tx.Omit("TakenBy").Create(revision).Error; err != nil {
+			return err
+		}
+		return tx.Model(set).Update("updated_at", revision.CreatedAt).Error
+	})
+}
+
+func (store *Store) GetUser(id uuid.UUID) (*models.User, error) {
+	var user models.User
+	if err := store.db.Where("id = ?", id).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (store *Store) GetUserByEmail(email string) (*models.User, error) {
+	var user models.User
+	if err := store.db.Where("email = ?", email).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (store *Store) GetAppointment(id uuid.UUID) (*models.Appointment, error) {
+	var appointment models.Appointment
+	if err := store.db.Where("id = ?", id).First(&appointment).Error; err != nil {
+		return nil, err
+	}
+	return &appointment, nil
+}
+
+func (store *Store) GetStaffMember(id uuid.UUID) (*models.StaffMember, error) {
+	var member models.StaffMember
+	if err := store.db.Where("id = ?", id).First(&member).Error; err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+func (store *Store) GetStaffByUserID(userID uuid.UUID) (*models.StaffMember, error) {
+	var member models.StaffMember
+	if err := store.db.Where("user_id = ?", userID).First(&member).Error; err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
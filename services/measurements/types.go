@@ -0,0 +1,46 @@
+package measurements
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/razdacoder/mcwale-api/models"
+)
+
+type MeasurementStore interface {
+	GetSets(userID *uuid.UUID, email string) ([]models.MeasurementSet, error)
+	GetSet(id string) (*models.MeasurementSet, error)
+	CreateSet(set *models.MeasurementSet, revision *models.MeasurementRevision) error
+	RenameSet(set *models.MeasurementSet) error
+	AddRevision(set *models.MeasurementSet, revision *models.MeasurementRevision) error
+	GetUser(id uuid.UUID) (*models.User, error)
+	GetUserByEmail(email string) (*models.User, error)
+	GetAppointment(id uuid.UUID) (*models.Appointment, error)
+	GetStaffMember(id uuid.UUID) (*models.StaffMember, error)
+	GetStaffByUserID(userID uuid.UUID) (*models.StaffMember, error)
+}
+
+// CreateSetPayload names whose measurements these are by user, email or
+// the appointment they were taken at.
+type CreateSetPayload struct {
+	UserID *uuid.UUID `json:"user_id"`
+	Email  string     `json:"email" validate:"omitempty,email"`
+	Name   string     `json:"name" validate:"required"`
+	RevisionPayload
+}
+
+type RenameSetPayload struct {
+	Name string `json:"name" validate:"required"`
+}
+
+// RevisionPayload records a sitting. Measurements left out are carried over
+// from the previous revision. TakenByID and TakenAt default to the signed in
+// staff member and now, and are there for copying up old paper records.
+type RevisionPayload struct {
+	Unit          models.MeasurementUnit `json:"unit" validate:"required,oneof=cm in"`
+	Values        models.Measurements    `json:"values" validate:"required,min=1,dive,keys,required,endkeys,gt=0"`
+	Notes         string                 `json:"notes"`
+	AppointmentID *uuid.UUID             `json:"appointment_id"`
+	TakenByID     *uuid.UUID             `json:"taken_by_id"`
+	TakenAt       *time.Time             `json:"taken_at"`
+}
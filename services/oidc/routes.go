@@ -0,0 +1,350 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/razdacoder/mcwale-api/models"
+	"github.com/razdacoder/mcwale-api/services/auth"
+	"github.com/razdacoder/mcwale-api/utils"
+)
+
+const (
+	purposeState    = "oidc_state"
+	stateCookieName = "oidc_state"
+	stateLifetime   = 10 * time.Minute
+)
+
+type Handler struct {
+	store     IdentityStore
+	providers map[string]*Provider
+}
+
+func NewHandler(store IdentityStore, providers map[string]*Provider) *Handler {
+	return &Handler{
+		store:     store,
+		providers: providers,
+	}
+}
+
+func oidcRouter(handler *Handler) chi.Router {
+	router := chi.NewRouter()
+
+	router.Get("/", handler.handleGetProviders)
+	router.With(auth.IsLoggedIn).Get("/identities", handler.handleGetIdentities)
+
+	router.Route("/{provider}", func(router chi.Router) {
+		router.Get("/authorize", handler.handleAuthorize)
+		router.Post("/callback", handler.handleCallback)
+		router.With(auth.IsLoggedIn).Post("/link", handler.handleLink)
+		router.With(auth.IsLoggedIn).Delete("/link", handler.handleUnlink)
+	})
+
+	return router
+}
+
+func (handler *Handler) RegisterRoutes(router chi.Router) {
+	router.Mount("/auth/oidc", oidcRouter(handler))
+}
+
+func (handler *Handler) provider(request *http.Request) (*Provider, error) {
+	name := chi.URLParam(request, "provider")
+	provider, ok := handler.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %s", name)
+	}
+	return provider, nil
+}
+
+// authorizationURL starts a flow. The state is a signed token carrying the
+// nonce, so the callback needs no server side session. It is bound to the
+// browser that started the flow by a random value kept in an HttpOnly
+// cookie, so a state issued to someone else cannot be replayed to sign a
+// victim in or link an account to theirs. linkUser is empty for sign in and
+// holds the user ID when linking an existing account.
+func (handler *Handler) authorizationURL(writer http.ResponseWriter, request *http.Request, provider *Provider, linkUser string) (string, error) {
+	nonce, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	binding, err := randomString()
+	if err != nil {
+		return "", err
+	}
+
+	state, err := auth.CreatePurposeJWT(linkUser, purposeState, stateLifetime, map[string]string{
+		"provider": provider.Name,
+		"nonce":    nonce,
+		"binding":  auth.HashToken(binding),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := provider.AuthorizationURL(state, nonce)
+	if err != nil {
+		return "", err
+	}
+
+	http.SetCookie(writer, stateCookie(binding, int(stateLifetime.Seconds())))
+	return authURL, nil
+}
+
+// stateCookie holds the value a flow's state is bound to. A negative maxAge
+// clears it. The storefront runs on another origin and posts the callback
+// with credentials, so the cookie has to be SameSite=None, which browsers
+// only accept when it is also Secure.
+func stateCookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     stateCookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+	}
+}
+
+// boundToRequest reports whether the state was issued to the browser making
+// the callback.
+func boundToRequest(request *http.Request, state *auth.Claims) bool {
+	cookie, err := request.Cookie(stateCookieName)
+	if err != nil || cookie.Value == "" || state.Data["binding"] == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(auth.HashToken(cookie.Value)), []byte(state.Data["binding"])) == 1
+}
+
+func randomString() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+func (handler *Handler) handleGetProviders(writer http.ResponseWriter, request *http.Request) {
+	names := []string{}
+	for name := range handler.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	utils.WriteJSON(writer, http.StatusOK, names)
+}
+
+func (handler *Handler) handleGetIdentities(writer http.ResponseWriter, request *http.Request) {
+	principal, ok := auth.PrincipalFromContext(request.Context())
+	if !ok || principal.IsAPIKey() {
+		utils.WriteError(writer, http.StatusUnauthorized, fmt.Errorf("unauthorized"))
+		return
+	}
+
+	identities, err := handler.store.GetIdentities(principal.UserID)
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, identities)
+}
+
+func (handler *Handler) handleAuthorize(writer http.ResponseWriter, request *http.Request) {
+	provider, err := handler.provider(request)
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, err)
+		return
+	}
+
+	authURL, err := handler.authorizationURL(writer, request, provider, "")
+	if err != nil {
+		utils.WriteError(writer, http.StatusBadGateway, err)
+		return
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, map[string]string{"authorization_url": authURL})
+}
+
+func (handler *Handler) handleLink(writer http.ResponseWriter, request *http.Request) {
+	principal, ok := auth.PrincipalFromContext(request.Context())
+	if !ok || principal.IsAPIKey() {
+		utils.WriteError(writer, http.StatusUnauthorized, fmt.Errorf("unauthorized"))
+		return
+	}
+
+	provider, err := handler.provider(request)
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, err)
+		return
+	}
+
+	authURL, err := handler.authorizationURL(writer, request, provider, principal.UserID.String())
+	if err != nil {
+		utils.WriteError(writer, http.StatusBadGateway, err)
+		return
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, map[string]string{"authorization_url": authURL})
+}
+
+func (handler *Handler) handleUnlink(writer http.ResponseWriter, request *http.Request) {
+	principal, ok := auth.PrincipalFromContext(request.Context())
+	if !ok || principal.IsAPIKey() {
+		utils.WriteError(writer, http.StatusUnauthorized, fmt.Errorf("unauthorized"))
+		return
+	}
+
+	if err := handler.store.DeleteIdentity(principal.UserID, chi.URLParam(request, "provider")); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, map[string]string{"message": "Account Unlinked"})
+}
+
+func (handler *Handler) handleCallback(writer http.ResponseWriter, request *http.Request) {
+	provider, err := handler.provider(request)
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, err)
+		return
+	}
+
+	var payload CallbackPayload
+	if err := utils.ParseJSON(request, &payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	state, err := auth.ParseToken(payload.State, purposeState)
+	if err != nil || state.Data["provider"] != provider.Name || !boundToRequest(request, state) {
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid state"))
+		return
+	}
+	// The state is single use from the browser's point of view.
+	http.SetCookie(writer, stateCookie("", -1))
+
+	idToken, err := provider.Exchange(payload.Code)
+	if err != nil {
+		utils.WriteError(writer, http.StatusBadGateway, err)
+		return
+	}
+
+	claims, err := provider.VerifyIDToken(idToken, state.Data["nonce"])
+	if err != nil {
+		utils.WriteError(writer, http.StatusUnauthorized, err)
+		return
+	}
+
+	if state.Subject != "" {
+		handler.linkIdentity(writer, provider, state.Subject, claims)
+		return
+	}
+
+	user, err := handler.findOrCreateUser(provider, claims)
+	if err != nil {
+		utils.WriteError(writer, http.StatusUnauthorized, err)
+		return
+	}
+
+	token, err := auth.CreateJWT(user.ID, string(*user.UserRole))
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := handler.store.RecordLogin(user.ID); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, map[string]string{"token": token})
+}
+
+func (handler *Handler) linkIdentity(writer http.ResponseWriter, provider *Provider, userID string, claims *IDTokenClaims) {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid state"))
+		return
+	}
+
+	if existing, err := handler.store.GetIdentity(provider.Name, claims.Subject); err == nil {
+		if existing.UserID != id {
+			utils.WriteError(writer, http.StatusConflict, fmt.Errorf("this %s account is linked to another user", provider.Name))
+			return
+		}
+		utils.WriteJSON(writer, http.StatusOK, map[string]string{"message": "Account Linked"})
+		return
+	}
+
+	err = handler.store.CreateIdentity(&models.UserIdentity{
+		UserID:   id,
+		Provider: provider.Name,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	})
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, map[string]string{"message": "Account Linked"})
+}
+
+// findOrCreateUser resolves the provider account to a user: first by an
+// existing link, then by verified email (linking it), and finally by
+// creating a new customer without a password.
+func (handler *Handler) findOrCreateUser(provider *Provider, claims *IDTokenClaims) (*models.User, error) {
+	if identity, err := handler.store.GetIdentity(provider.Name, claims.Subject); err == nil {
+		return handler.store.GetUserByID(identity.UserID)
+	}
+
+	if claims.Email == "" || !bool(claims.EmailVerified) {
+		return nil, fmt.Errorf("%s did not provide a verified email address", provider.Name)
+	}
+	email := claims.Email
+
+	identity := &models.UserIdentity{
+		Provider: provider.Name,
+		Subject:  claims.Subject,
+		Email:    email,
+	}
+
+	if user, err := handler.store.GetUserByEmail(email); err == nil {
+		identity.UserID = user.ID
+		if err := handler.store.CreateIdentity(identity); err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+
+	// The provider has verified the email, which is all registering would.
+	role := models.Customer
+	now := time.Now()
+	user := &models.User{
+		Firstname:       claims.GivenName,
+		Lastname:        claims.FamilyName,
+		Email:           email,
+		EmailVerifiedAt: &now,
+		UserRole:        &role,
+	}
+	if err := handler.store.CreateUserWithIdentity(user, identity); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
@@ -0,0 +1,321 @@
+package oidc
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/razdacoder/mcwale-api/models"
+)
+
+func TestMain(m *testing.M) {
+	os.Setenv("JWT_SECRET", "oidc-test-secret")
+	os.Exit(m.Run())
+}
+
+// fakeProvider is a stand-in OpenID provider serving discovery, a JWKS and
+// a token endpoint. Each code it knows maps to the nonce its ID token
+// carries.
+type fakeProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]string
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeProvider{key: key, codes: map[string]string{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(writer http.ResponseWriter, request *http.Request) {
+		json.NewEncoder(writer).Encode(discoveryDocument{
+			Issuer:                fake.server.URL,
+			AuthorizationEndpoint: fake.server.URL + "/authorize",
+			TokenEndpoint:         fake.server.URL + "/token",
+			JWKSURI:               fake.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(writer http.ResponseWriter, request *http.Request) {
+		encode := base64.RawURLEncoding.EncodeToString
+		json.NewEncoder(writer).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"n":   encode(key.N.Bytes()),
+			"e":   encode(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(writer http.ResponseWriter, request *http.Request) {
+		request.ParseForm()
+		fake.mu.Lock()
+		nonce, ok := fake.codes[request.PostForm.Get("code")]
+		fake.mu.Unlock()
+		if !ok || request.PostForm.Get("client_id") != "client" {
+			writer.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(writer).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(writer).Encode(map[string]string{"id_token": fake.idToken(t, nonce)})
+	})
+	fake.server = httptest.NewServer(mux)
+	t.Cleanup(fake.server.Close)
+
+	return fake
+}
+
+func (fake *fakeProvider) issue(code, nonce string) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.codes[code] = nonce
+}
+
+func (fake *fakeProvider) idToken(t *testing.T, nonce string) string {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, IDTokenClaims{
+		Email:         "ada@example.com",
+		EmailVerified: true,
+		Nonce:         nonce,
+		GivenName:     "Ada",
+		FamilyName:    "Lovelace",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    fake.server.URL,
+			Subject:   "provider-user-1",
+			Audience:  jwt.ClaimStrings{"client"},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		},
+	})
+	token.Header["kid"] = "test"
+	signed, err := token.SignedString(fake.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// memoryStore is an in-memory IdentityStore.
+type memoryStore struct {
+	users      map[uuid.UUID]*models.User
+	identities []models.UserIdentity
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{users: map[uuid.UUID]*models.User{}}
+}
+
+func (store *memoryStore) GetIdentity(provider, subject string) (*models.UserIdentity, error) {
+	for _, identity := range store.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return &identity, nil
+		}
+	}
+	return nil, fmt.Errorf("identity not found")
+}
+
+func (store *memoryStore) GetUserByID(id uuid.UUID) (*models.User, error) {
+	if user, ok := store.users[id]; ok {
+		return user, nil
+	}
+	return nil, fmt.Errorf("user not found")
+}
+
+func (store *memoryStore) GetUserByEmail(email string) (*models.User, error) {
+	for _, user := range store.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return nil, fmt.Errorf("user not found")
+}
+
+func (store *memoryStore) CreateUserWithIdentity(user *models.User, identity *models.UserIdentity) error {
+	user.ID = uuid.New()
+	store.users[user.ID] = user
+	identity.UserID = user.ID
+	return store.CreateIdentity(identity)
+}
+
+func (store *memoryStore) CreateIdentity(identity *models.UserIdentity) error {
+	identity.ID = uuid.New()
+	store.identities = append(store.identities, *identity)
+	return nil
+}
+
+func (store *memoryStore) DeleteIdentity(userID uuid.UUID, provider string) error {
+	return nil
+}
+
+func (store *memoryStore) GetIdentities(userID uuid.UUID) ([]models.UserIdentity, error) {
+	return store.identities, nil
+}
+
+func (store *memoryStore) RecordLogin(id uuid.UUID) error {
+	return nil
+}
+
+type testFlow struct {
+	state  string
+	nonce  string
+	cookie *http.Cookie
+}
+
+func setup(t *testing.T) (*fakeProvider, *memoryStore, http.Handler) {
+	fake := newFakeProvider(t)
+	store := newMemoryStore()
+	handler := NewHandler(store, map[string]*Provider{
+		"test": {
+			Name:         "test",
+			Issuer:       fake.server.URL,
+			ClientID:     "client",
+			ClientSecret: "secret",
+			RedirectURL:  "https://shop.example.com/auth/callback",
+			Scopes:       []string{"openid", "email", "profile"},
+		},
+	})
+	return fake, store, oidcRouter(handler)
+}
+
+// authorize starts a sign in and returns what the browser is left holding.
+func authorize(t *testing.T, router http.Handler) testFlow {
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/test/authorize", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("authorize returned %d: %s", recorder.Code, recorder.Body)
+	}
+
+	var body map[string]string
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := url.Parse(body["authorization_url"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cookie *http.Cookie
+	for _, c := range recorder.Result().Cookies() {
+		if c.Name == stateCookieName {
+			cookie = c
+		}
+	}
+	if cookie == nil {
+		t.Fatal("authorize did not set the state cookie")
+	}
+
+	return testFlow{
+		state:  authURL.Query().Get("state"),
+		nonce:  authURL.Query().Get("nonce"),
+		cookie: cookie,
+	}
+}
+
+func callback(router http.Handler, code, state string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(CallbackPayload{Code: code, State: state})
+	request := httptest.NewRequest(http.MethodPost, "/test/callback", bytes.NewReader(body))
+	if cookie != nil {
+		request.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestAuthorizeSetsStateCookie(t *testing.T) {
+	_, _, router := setup(t)
+	flow := authorize(t, router)
+
+	if flow.state == "" || flow.nonce == "" {
+		t.Fatal("authorization URL is missing state or nonce")
+	}
+	if !flow.cookie.HttpOnly || !flow.cookie.Secure || flow.cookie.SameSite != http.SameSiteNoneMode {
+		t.Errorf("state cookie must be HttpOnly, Secure and SameSite=None, got %+v", flow.cookie)
+	}
+}
+
+func TestCallbackSignsIn(t *testing.T) {
+	fake, store, router := setup(t)
+	flow := authorize(t, router)
+	fake.issue("good-code", flow.nonce)
+
+	recorder := callback(router, "good-code", flow.state, flow.cookie)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("callback returned %d: %s", recorder.Code, recorder.Body)
+	}
+	var body map[string]string
+	json.Unmarshal(recorder.Body.Bytes(), &body)
+	if body["token"] == "" {
+		t.Error("callback did not return a token")
+	}
+	if len(store.users) != 1 || len(store.identities) != 1 {
+		t.Errorf("expected one new user and identity, got %d and %d", len(store.users), len(store.identities))
+	}
+}
+
+func TestCallbackRejectsUnknownCode(t *testing.T) {
+	_, _, router := setup(t)
+	flow := authorize(t, router)
+
+	recorder := callback(router, "unknown-code", flow.state, flow.cookie)
+	if recorder.Code != http.StatusBadGateway {
+		t.Fatalf("expected %d, got %d: %s", http.StatusBadGateway, recorder.Code, recorder.Body)
+	}
+}
+
+func TestCallbackRejectsNonceMismatch(t *testing.T) {
+	fake, store, router := setup(t)
+	flow := authorize(t, router)
+	fake.issue("good-code", "someone-elses-nonce")
+
+	recorder := callback(router, "good-code", flow.state, flow.cookie)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected %d, got %d: %s", http.StatusUnauthorized, recorder.Code, recorder.Body)
+	}
+	if len(store.users) != 0 {
+		t.Error("a user was created despite the nonce mismatch")
+	}
+}
+
+func TestCallbackRejectsStateMismatch(t *testing.T) {
+	fake, store, router := setup(t)
+	attacker := authorize(t, router)
+	victim := authorize(t, router)
+	fake.issue("attacker-code", attacker.nonce)
+
+	tests := []struct {
+		name   string
+		state  string
+		cookie *http.Cookie
+	}{
+		{"state from another browser", attacker.state, victim.cookie},
+		{"no state cookie", attacker.state, nil},
+		{"tampered state", attacker.state + "x", attacker.cookie},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := callback(router, "attacker-code", test.state, test.cookie)
+			if recorder.Code != http.StatusBadRequest {
+				t.Fatalf("expected %d, got %d: %s", http.StatusBadRequest, recorder.Code, recorder.Body)
+			}
+		})
+	}
+	if len(store.users) != 0 {
+		t.Error("a user was created despite the state mismatch")
+	}
+}
@@ -0,0 +1,90 @@
+package oidc
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/razdacoder/mcwale-api/models"
+	"gorm.io/gorm"
+)
+
+type Store struct {
+	db *gorm.DB
+}
+
+func NewStore(db *gorm.DB) *Store {
+	return &Store{
+		db: db,
+	}
+}
+
+func (store *Store) GetIdentity(provider, subject string) (*models.UserIdentity, error) {
+	var identity models.UserIdentity
+	result := store.db.Model(&models.UserIdentity{}).Where("provider = ? AND subject = ?", provider, subject).First(&identity)
+	return &identity, result.Error
+}
+
+func (store *Store) GetUserByID(id uuid.UUID) (*models.User, error) {
+	var user models.User
+	result := store.db.Model(&models.User{}).Where("id = ?", id).First(&user)
+	return &user, result.Error
+}
+
+func (store *Store) GetUserByEmail(email string) (*models.User, error) {
+	var user models.User
+	result := store.db.Model(&models.User{}).Where("email = ?", email).First(&user)
+	return &user, result.Error
+}
+
+func (store *Store) CreateUserWithIdentity(user *models.User, identity *models.UserIdentity) error {
+	return store.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		identity.UserID = user.ID
+		return tx.Create(identity).Error
+	})
+}
+
+func (store *Store) CreateIdentity(identity *models.UserIdentity) error {
+	result := store.db.Create(identity)
+	return result.Error
+}
+
+// DeleteIdentity unlinks a provider, refusing to remove the user's last way
+// of signing in.
+func (store *Store) DeleteIdentity(userID uuid.UUID, provider string) error {
+	return store.db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Where("id = ?", userID).First(&user).Error; err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.Model(&models.UserIdentity{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+			return err
+		}
+		if user.Password == "" && count <= 1 {
+			return fmt.Errorf("set a password before unlinking your only sign in method")
+		}
+		result := tx.Where("user_id = ? AND provider = ?", userID, provider).Delete(&models.UserIdentity{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("no %s account linked", provider)
+		}
+		return nil
+	})
+}
+
+func (store *Store) GetIdentities(userID uuid.UUID) ([]models.UserIdentity, error) {
+	var identities []models.UserIdentity
+	result := store.db.Where("user_id = ?", userID).Find(&identities)
+	return identities, result.Error
+}
+
+func (store *Store) RecordLogin(id uuid.UUID) error {
+	results := store.db.Model(&models.User{}).Where("id = ?", id).UpdateColumn("last_login_at", time.Now())
+	return results.Error
+}
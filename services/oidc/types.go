@@ -0,0 +1,22 @@
+package oidc
+
+import (
+	"github.com/google/uuid"
+	"github.com/razdacoder/mcwale-api/models"
+)
+
+type IdentityStore interface {
+	GetIdentity(provider, subject string) (*models.UserIdentity, error)
+	GetUserByID(id uuid.UUID) (*models.User, error)
+	GetUserByEmail(email string) (*models.User, error)
+	CreateUserWithIdentity(user *models.User, identity *models.UserIdentity) error
+	CreateIdentity(identity *models.UserIdentity) error
+	DeleteIdentity(userID uuid.UUID, provider string) error
+	GetIdentities(userID uuid.UUID) ([]models.UserIdentity, error)
+	RecordLogin(id uuid.UUID) error
+}
+
+type CallbackPayload struct {
+	Code  string `json:"code" validate:"required"`
+	State string `json:"state" validate:"required"`
+}
@@ -5,18 +5,22 @@ import (
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/go-playground/validator"
+	"github.com/go-playground/validator/v10"
+	"github.com/razdacoder/mcwale-api/models"
+	"github.com/razdacoder/mcwale-api/services/audit"
 	"github.com/razdacoder/mcwale-api/services/auth"
 	"github.com/razdacoder/mcwale-api/utils"
 )
 
 type Handler struct {
 	store *Store
+	audit audit.Recorder
 }
 
-func NewHandler(store *Store) *Handler {
+func NewHandler(store *Store, recorder audit.Recorder) *Handler {
 	return &Handler{
 		store: store,
+		audit: recorder,
 	}
 }
 
@@ -24,14 +28,14 @@ func orderRoutes(handler *Handler) chi.Router {
 	router := chi.NewRouter()
 
 	router.Route("/", func(router chi.Router) {
-		router.Post("/", handler.handleCreateOrder)
-		router.Get("/", handler.handleGetOrders)
+		router.With(auth.OptionalLogin).Post("/", handler.handleCreateOrder)
+		router.With(auth.IsLoggedIn, auth.RequirePermission(models.PermOrdersRead)).Get("/", handler.handleGetOrders)
 	})
 
 	router.Route("/{id}", func(router chi.Router) {
-		router.Get("/", handler.handleGetOrder)
-		router.With(auth.IsLoggedIn, auth.IsAdmin).Patch("/", handler.handleUpdateOrderStatus)
-		router.With(auth.IsLoggedIn, auth.IsAdmin).Delete("/", handler.handleDeleteOrder)
+		router.With(auth.IsLoggedIn).Get("/", handler.handleGetOrder)
+		router.With(auth.IsLoggedIn, auth.RequirePermission(models.PermOrdersWrite)).Patch("/", handler.handleUpdateOrderStatus)
+		router.With(auth.IsLoggedIn, auth.RequirePermission(models.PermOrdersDelete)).Delete("/", handler.handleDeleteOrder)
 	})
 
 	return router
@@ -54,14 +58,70 @@ func (handler *Handler) handleCreateOrder(writer http.ResponseWriter, request *h
 		return
 	}
 
-	orderNumber, err := handler.store.CreateOrder(payload)
+	if err := handler.resolveAddresses(request, &payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	order, err := handler.store.CreateOrder(payload)
 
 	if err != nil {
 		utils.WriteError(writer, http.StatusInternalServerError, err)
 		return
 	}
+	handler.audit.Record(request, "order.create", "order", order.ID.String(), nil, order)
+
+	utils.WriteJSON(writer, http.StatusCreated, map[string]string{"order_number": order.OrderNumber})
+}
+
+// resolveAddresses ties the order to the signed in customer and snapshots any
+// saved addresses referenced by the payload onto it.
+func (handler *Handler) resolveAddresses(request *http.Request, payload *CreateOrderPayload) error {
+	principal, ok := auth.PrincipalFromContext(request.Context())
+	if ok && !principal.IsAPIKey() {
+		payload.UserID = &principal.UserID
+	}
+
+	if payload.UserID == nil && (payload.ShippingAddressID != nil || payload.BillingAddressID != nil) {
+		return fmt.Errorf("sign in to use a saved address")
+	}
+
+	if payload.ShippingAddressID != nil {
+		address, err := handler.store.GetUserAddress(*payload.UserID, *payload.ShippingAddressID)
+		if err != nil {
+			return fmt.Errorf("shipping address not found")
+		}
+		payload.FirstName = address.FirstName
+		payload.LastName = address.LastName
+		payload.PhoneNumber = address.PhoneNumber
+		payload.Address1 = address.Address1
+		payload.Address2 = address.Address2
+		payload.Town = address.Town
+		payload.State = address.State
+		payload.Country = address.Country
+		payload.PostalCode = address.PostalCode
+	}
+
+	if payload.BillingAddressID != nil {
+		address, err := handler.store.GetUserAddress(*payload.UserID, *payload.BillingAddressID)
+		if err != nil {
+			return fmt.Errorf("billing address not found")
+		}
+		payload.Billing = address.Snapshot()
+	}
+
+	if payload.Email == "" {
+		if payload.UserID == nil {
+			return fmt.Errorf("email is required")
+		}
+		email, err := handler.store.GetUserEmail(*payload.UserID)
+		if err != nil {
+			return err
+		}
+		payload.Email = email
+	}
 
-	utils.WriteJSON(writer, http.StatusCreated, map[string]string{"order_number": orderNumber})
+	return nil
 }
 
 func (handler *Handler) handleGetOrders(writer http.ResponseWriter, request *http.Request) {
@@ -86,6 +146,20 @@ func (handler *Handler) handleGetOrder(writer http.ResponseWriter, request *http
 		return
 	}
 
+	// Customers can see their own orders; anyone else needs orders:read.
+	principal, _ := auth.PrincipalFromContext(request.Context())
+	if order.UserID == nil || !principal.IsUser(order.UserID.String()) {
+		allowed, err := principal.Can(models.PermOrdersRead)
+		if err != nil {
+			utils.WriteError(writer, http.StatusInternalServerError, err)
+			return
+		}
+		if !allowed {
+			utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("order not found"))
+			return
+		}
+	}
+
 	utils.WriteJSON(writer, http.StatusOK, order)
 }
 
@@ -109,11 +183,23 @@ func (handler *Handler) handleUpdateOrderStatus(writer http.ResponseWriter, requ
 		return
 	}
 
-	err := handler.store.UpdateOrderStatus(id, payload.Status)
+	before, err := handler.store.GetOrderByID(id)
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, err)
+		return
+	}
+
+	err = handler.store.UpdateOrderStatus(id, payload.Status)
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	after, err := handler.store.GetOrderByID(id)
 	if err != nil {
 		utils.WriteError(writer, http.StatusInternalServerError, err)
 		return
 	}
+	handler.audit.Record(request, "order.update_status", "order", id, before, after)
 
 	utils.WriteJSON(writer, http.StatusOK, map[string]string{"message": "Order Status updated"})
 
@@ -125,10 +211,16 @@ func (handler *Handler) handleDeleteOrder(writer http.ResponseWriter, request *h
 		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("no order id"))
 		return
 	}
-	err := handler.store.DeleteOrder(id)
+	order, err := handler.store.GetOrderByID(id)
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, err)
+		return
+	}
+	err = handler.store.DeleteOrder(id)
 	if err != nil {
 		utils.WriteError(writer, http.StatusNotFound, err)
 		return
 	}
+	handler.audit.Record(request, "order.delete", "order", id, order, nil)
 	utils.WriteJSON(writer, http.StatusNoContent, map[string]string{"message": "Order Deleted"})
 }
@@ -2,6 +2,7 @@ package orders
 
 import (
 	"github.com/google/uuid"
+	"github.com/razdacoder/mcwale-api/db"
 	"github.com/razdacoder/mcwale-api/models"
 	"gorm.io/gorm"
 )
@@ -16,10 +17,11 @@ func NewStore(db *gorm.DB) *Store {
 	}
 }
 
-func (store *Store) CreateOrder(payload CreateOrderPayload) (string, error) {
+func (store *Store) CreateOrder(payload CreateOrderPayload) (*models.Order, error) {
 	order := &models.Order{
 		ID:          uuid.New(),
 		OrderNumber: payload.OrderNumber,
+		UserID:      payload.UserID,
 		FirstName:   payload.FirstName,
 		LastName:    payload.LastName,
 		Email:       payload.Email,
@@ -32,6 +34,9 @@ func (store *Store) CreateOrder(payload CreateOrderPayload) (string, error) {
 		PostalCode:  payload.PostalCode,
 		OrderNote:   payload.OrderNote,
 		Total:       payload.Total,
+
+		ShippingAddressID: payload.ShippingAddressID,
+		Billing:           payload.Billing,
 	}
 
 	for _, itemPayload := range payload.Items {
@@ -49,16 +54,28 @@ func (store *Store) CreateOrder(payload CreateOrderPayload) (string, error) {
 	tx := store.db.Begin()
 	if err := tx.Create(order).Error; err != nil {
 		tx.Rollback()
-		return "", err
+		return nil, err
 	}
 
 	if err := tx.Model(order).Association("Items").Append(order.Items); err != nil {
 		tx.Rollback()
-		return "", err
+		return nil, err
 	}
 
 	tx.Commit()
-	return order.OrderNumber, nil
+	return order, nil
+}
+
+func (store *Store) GetUserAddress(userID uuid.UUID, id uuid.UUID) (*models.Address, error) {
+	var address models.Address
+	result := store.db.Model(&models.Address{}).Where("id = ? AND user_id = ?", id, userID).First(&address)
+	return &address, result.Error
+}
+
+func (store *Store) GetUserEmail(userID uuid.UUID) (string, error) {
+	var user models.User
+	result := store.db.Model(&models.User{}).Select("email").Where("id = ?", userID).First(&user)
+	return user.Email, result.Error
 }
 
 func (store *Store) GetOrders() ([]models.Order, error) {
@@ -73,7 +90,11 @@ func (store *Store) GetOrders() ([]models.Order, error) {
 
 func (store *Store) GetOrderByID(id string) (*models.Order, error) {
 	var order models.Order
-	results := store.db.Model(&models.Order{}).Where("id = ?", id).Preload("Items.Product.Category").First(&order)
+	// Past orders keep showing products and categories that have since been deleted.
+	results := store.db.Model(&models.Order{}).Where("id = ?", id).
+		Preload("Items.Product", db.Unscoped).
+		Preload("Items.Product.Category", db.Unscoped).
+		First(&order)
 	if results.Error != nil {
 		return nil, results.Error
 	}
@@ -91,6 +112,6 @@ func (store *Store) DeleteOrder(id string) error {
 	if err != nil {
 		return err
 	}
-	results := store.db.Select("Items").Delete(&order)
+	results := store.db.Delete(&order)
 	return results.Error
 }
@@ -10,21 +10,27 @@ type OrderStore interface {
 	GetOrders() ([]models.Order, error)
 }
 
+// CreateOrderPayload takes either raw shipping fields or, for signed in
+// customers, a saved ShippingAddressID whose fields are copied onto the order.
 type CreateOrderPayload struct {
-	OrderNumber string                   `json:"order_number" validate:"required"`
-	FirstName   string                   `json:"first_name" validate:"required"`
-	LastName    string                   `json:"last_name" validate:"required"`
-	Email       string                   `json:"email" validate:"required"`
-	PhoneNumber string                   `json:"phone_number" validate:"required"`
-	Address1    string                   `json:"address_line_1" validate:"required"`
-	Address2    string                   `json:"address_line_2"`
-	Town        string                   `json:"town" validate:"required"`
-	State       string                   `json:"state" validate:"required"`
-	Country     string                   `json:"country" validate:"required"`
-	PostalCode  string                   `json:"postal_code" validate:"required"`
-	OrderNote   string                   `json:"order_note"`
-	Total       float64                  `json:"total" validate:"required"`
-	Items       []CreateOrderItemPayload `json:"items" validate:"required"`
+	OrderNumber       string                   `json:"order_number" validate:"required"`
+	ShippingAddressID *uuid.UUID               `json:"shipping_address_id"`
+	BillingAddressID  *uuid.UUID               `json:"billing_address_id"`
+	FirstName         string                   `json:"first_name" validate:"required_without=ShippingAddressID"`
+	LastName          string                   `json:"last_name" validate:"required_without=ShippingAddressID"`
+	Email             string                   `json:"email"`
+	PhoneNumber       string                   `json:"phone_number" validate:"required_without=ShippingAddressID"`
+	Address1          string                   `json:"address_line_1" validate:"required_without=ShippingAddressID"`
+	Address2          string                   `json:"address_line_2"`
+	Town              string                   `json:"town" validate:"required_without=ShippingAddressID"`
+	State             string                   `json:"state" validate:"required_without=ShippingAddressID"`
+	Country           string                   `json:"country" validate:"required_without=ShippingAddressID"`
+	PostalCode        string                   `json:"postal_code" validate:"required_without=ShippingAddressID"`
+	OrderNote         string                   `json:"order_note"`
+	Total             float64                  `json:"total" validate:"required"`
+	Items             []CreateOrderItemPayload `json:"items" validate:"required"`
+	UserID            *uuid.UUID               `json:"-"`
+	Billing           models.AddressSnapshot   `json:"-"`
 }
 
 type CreateOrderItemPayload struct {
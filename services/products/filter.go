@@ -0,0 +1,173 @@
+package products
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxFilterValues caps how many values a list filter can take.
+	maxFilterValues = 20
+	// maxQueryLength caps the length of a search query.
+	maxQueryLength = 200
+)
+
+// sortOptions are the accepted values of sortBy.
+var sortOptions = map[string]bool{
+	"":                  true,
+	"relevance":         true,
+	"new_arrivals":      true,
+	"price_low_to_high": true,
+	"price_high_to_low": true,
+	"rating":            true,
+}
+
+// ParseProductFilter reads the filter shared by every product listing from
+// a query string:
+//
+//	q                     search query, in web search syntax
+//	category, style,      lists of values, comma separated or repeated
+//	size, color
+//	min_price, max_price  price bounds, inclusive
+//	in_stock, on_sale,    true to keep only products that are in stock,
+//	featured              discounted or featured
+//	sortBy                relevance, new_arrivals, price_low_to_high,
+//	                      price_high_to_low or rating
+//
+// Paging is left to the caller.
+func ParseProductFilter(query url.Values) (ProductFilter, error) {
+	filter := ProductFilter{
+		Query:  strings.TrimSpace(query.Get("q")),
+		SortBy: query.Get("sortBy"),
+	}
+	if len(filter.Query) > maxQueryLength {
+		return filter, fmt.Errorf("q must be at most %d characters", maxQueryLength)
+	}
+	if !sortOptions[filter.SortBy] {
+		return filter, fmt.Errorf("invalid sortBy %q", filter.SortBy)
+	}
+
+	var err error
+	lists := []struct {
+		key    string
+		values *[]string
+	}{
+		{"category", &filter.Categories},
+		{"style", &filter.Styles},
+		{"size", &filter.Sizes},
+		{"color", &filter.Colors},
+	}
+	for _, list := range lists {
+		if *list.values, err = parseList(query, list.key); err != nil {
+			return filter, err
+		}
+	}
+
+	if filter.MinPrice, err = parsePrice(query, "min_price"); err != nil {
+		return filter, err
+	}
+	if filter.MaxPrice, err = parsePrice(query, "max_price"); err != nil {
+		return filter, err
+	}
+	if filter.MaxPrice != 0 && filter.MinPrice > filter.MaxPrice {
+		return filter, fmt.Errorf("min_price cannot be more than max_price")
+	}
+
+	flags := []struct {
+		key   string
+		value *bool
+	}{
+		{"in_stock", &filter.InStock},
+		{"on_sale", &filter.OnSale},
+		{"featured", &filter.Featured},
+	}
+	for _, flag := range flags {
+		if *flag.value, err = parseFlag(query, flag.key); err != nil {
+			return filter, err
+		}
+	}
+	return filter, nil
+}
+
+// parseList collects a list filter given either as repeated parameters or
+// as one comma separated value, dropping blanks and duplicates.
+func parseList(query url.Values, key string) ([]string, error) {
+	var values []string
+	seen := map[string]bool{}
+	for _, raw := range query[key] {
+		for _, value := range strings.Split(raw, ",") {
+			value = strings.TrimSpace(value)
+			if value == "" || seen[value] {
+				continue
+			}
+			seen[value] = true
+			values = append(values, value)
+		}
+	}
+	if len(values) > maxFilterValues {
+		return nil, fmt.Errorf("%s can take at most %d values", key, maxFilterValues)
+	}
+	return values, nil
+}
+
+func parsePrice(query url.Values, key string) (float64, error) {
+	value := query.Get(key)
+	if value == "" {
+		return 0, nil
+	}
+	price, err := strconv.ParseFloat(value, 64)
+	if err != nil || price < 0 {
+		return 0, fmt.Errorf("%s must be a number no less than 0", key)
+	}
+	return price, nil
+}
+
+func parseFlag(query url.Values, key string) (bool, error) {
+	value := query.Get(key)
+	if value == "" {
+		return false, nil
+	}
+	flag, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false", key)
+	}
+	return flag, nil
+}
+
+// conditions are SQL conditions to be joined with AND.
+type conditions struct {
+	parts []string
+	args  []interface{}
+}
+
+func (c *conditions) add(part string, args ...interface{}) {
+	c.parts = append(c.parts, part)
+	c.args = append(c.args, args...)
+}
+
+func (c *conditions) sql() (string, []interface{}) {
+	return strings.Join(c.parts, " AND "), c.args
+}
+
+// variantConditions are the filter's conditions on a single variant, or
+// nil if it asks for no size or color. Stock only becomes a variant
+// condition alongside a size or color, because products without variants
+// are always in stock.
+func variantConditions(filter ProductFilter, skip string) *conditions {
+	variant := &conditions{}
+	if len(filter.Sizes) > 0 && skip != "size" {
+		variant.add("product_variants.size IN ?", filter.Sizes)
+	}
+	if len(filter.Colors) > 0 && skip != "color" {
+		variant.add("product_variants.color IN ?", filter.Colors)
+	}
+	if len(variant.parts) == 0 {
+		return nil
+	}
+	if filter.InStock && skip != "in_stock" {
+		variant.add("product_variants.stock > 0")
+	}
+	return variant
+}
@@ -0,0 +1,59 @@
+package products
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// distinctValues is a comma separated list of n different values.
+func distinctValues(n int) string {
+	values := make([]string, n)
+	for i := range values {
+		values[i] = fmt.Sprintf("style-%d", i)
+	}
+	return strings.Join(values, ",")
+}
+
+func TestParseProductFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    ProductFilter
+		wantErr bool
+	}{
+		{"empty", "", ProductFilter{}, false},
+		{"query is trimmed", "q=+royal+agbada+&sortBy=relevance", ProductFilter{Query: "royal agbada", SortBy: "relevance"}, false},
+		{"comma separated list", "category=men,women", ProductFilter{Categories: []string{"men", "women"}}, false},
+		{"repeated list", "size=M&size=L,XL", ProductFilter{Sizes: []string{"M", "L", "XL"}}, false},
+		{"blanks and duplicates dropped", "color=red,,+red+,blue&color=red", ProductFilter{Colors: []string{"red", "blue"}}, false},
+		{"prices", "min_price=10&max_price=99.5", ProductFilter{MinPrice: 10, MaxPrice: 99.5}, false},
+		{"only a minimum price", "min_price=10", ProductFilter{MinPrice: 10}, false},
+		{"flags", "in_stock=true&on_sale=1&featured=false", ProductFilter{InStock: true, OnSale: true}, false},
+		{"unknown sort", "sortBy=cheapest", ProductFilter{}, true},
+		{"query too long", "q=" + strings.Repeat("a", maxQueryLength+1), ProductFilter{}, true},
+		{"too many values", "style=" + distinctValues(maxFilterValues+1), ProductFilter{}, true},
+		{"as many values as allowed", "style=" + distinctValues(maxFilterValues), ProductFilter{Styles: strings.Split(distinctValues(maxFilterValues), ",")}, false},
+		{"price not a number", "min_price=cheap", ProductFilter{}, true},
+		{"negative price", "max_price=-1", ProductFilter{}, true},
+		{"minimum above maximum", "min_price=50&max_price=20", ProductFilter{}, true},
+		{"flag not a boolean", "in_stock=yes", ProductFilter{}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, err := url.ParseQuery(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ParseProductFilter(query)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ParseProductFilter(%q) error = %v, want error %v", test.query, err, test.wantErr)
+			}
+			if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ParseProductFilter(%q) = %+v, want %+v", test.query, got, test.want)
+			}
+		})
+	}
+}
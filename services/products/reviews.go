@@ -0,0 +1,278 @@
+package products
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/razdacoder/mcwale-api/models"
+	"github.com/razdacoder/mcwale-api/services/auth"
+	"github.com/razdacoder/mcwale-api/utils"
+)
+
+func reviewsRouter(handler *Handler) chi.Router {
+	router := chi.NewRouter()
+	router.Use(auth.IsLoggedIn)
+
+	moderate := auth.RequirePermission(models.PermReviewsModerate)
+	router.Get("/mine", handler.handleGetMyReviews)
+	router.With(moderate).Get("/", handler.handleGetReviews)
+	router.With(moderate).Post("/{id}/approve", handler.handleApproveReview)
+	router.With(moderate).Post("/{id}/reject", handler.handleRejectReview)
+	router.With(moderate).Put("/{id}/reply", handler.handleReplyToReview)
+
+	return router
+}
+
+// handleGetProductReviews lists a product's approved reviews a page at a
+// time, with its rating and how many reviews gave each number of stars.
+// sortBy is newest (the default), highest or lowest.
+func (handler *Handler) handleGetProductReviews(writer http.ResponseWriter, request *http.Request) {
+	product, err := handler.store.GetSingleProduct(chi.URLParam(request, "slug"))
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("product not found"))
+		return
+	}
+
+	query := request.URL.Query()
+	page := utils.ParseStringToInt(query.Get("page"), 0)
+	perPage := utils.ParseStringToInt(os.Getenv("PER_PAGE"), 10)
+	if page == 0 {
+		page = 1
+	}
+
+	reviews, total, err := handler.store.GetProductReviews(product.ID, query.Get("sortBy"), (page-1)*perPage, perPage)
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	breakdown, err := handler.store.GetRatingBreakdown(product.ID)
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	pages := int(math.Ceil(float64(total) / float64(perPage)))
+	utils.WriteJSON(writer, http.StatusOK, map[string]any{
+		"rating_average": product.RatingAverage,
+		"rating_count":   product.RatingCount,
+		"breakdown":      breakdown,
+		"results":        len(reviews),
+		"page":           page,
+		"pages":          pages,
+		"total":          total,
+		"data":           reviews,
+	})
+}
+
+// handleCreateReview records a signed in customer's review of a product,
+// one per product. It is marked as a verified purchase if the customer has
+// had the product delivered, and waits for moderation before it is shown.
+func (handler *Handler) handleCreateReview(writer http.ResponseWriter, request *http.Request) {
+	product, err := handler.store.GetSingleProduct(chi.URLParam(request, "slug"))
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("product not found"))
+		return
+	}
+
+	principal, _ := auth.PrincipalFromContext(request.Context())
+	if principal.IsAPIKey() {
+		utils.WriteError(writer, http.StatusForbidden, fmt.Errorf("reviews must be written by a customer"))
+		return
+	}
+
+	var payload CreateReviewPayload
+	if err := utils.ParseJSON(request, &payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	user, err := handler.store.GetUser(principal.UserID)
+	if err != nil {
+		utils.WriteError(writer, http.StatusUnauthorized, fmt.Errorf("user not found"))
+		return
+	}
+	reviewed, err := handler.store.HasReviewed(product.ID, user.ID)
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	if reviewed {
+		utils.WriteError(writer, http.StatusConflict, fmt.Errorf("you have already reviewed this product"))
+		return
+	}
+	verified, err := handler.store.HasDeliveredOrder(user, product.ID)
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	review := &models.ProductReview{
+		ProductID:        product.ID,
+		UserID:           user.ID,
+		AuthorName:       authorName(user),
+		Rating:           payload.Rating,
+		Title:            payload.Title,
+		Body:             payload.Body,
+		Photos:           payload.Photos,
+		VerifiedPurchase: verified,
+		Status:           models.ReviewPending,
+	}
+	if review.Photos == nil {
+		review.Photos = []string{}
+	}
+	if err := handler.store.CreateReview(review); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	handler.audit.Record(request, "review.create", "product_review", review.ID.String(), nil, review)
+
+	utils.WriteJSON(writer, http.StatusCreated, review)
+}
+
+// authorName is how a reviewer is shown: their first name and last
+// initial.
+func authorName(user *models.User) string {
+	name := strings.TrimSpace(user.Firstname)
+	if last := strings.TrimSpace(user.Lastname); last != "" {
+		name += " " + strings.ToUpper(string([]rune(last)[:1])) + "."
+	}
+	return name
+}
+
+func (handler *Handler) handleGetMyReviews(writer http.ResponseWriter, request *http.Request) {
+	principal, _ := auth.PrincipalFromContext(request.Context())
+	reviews, err := handler.store.GetUserReviews(principal.UserID)
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, reviews)
+}
+
+// handleGetReviews is the moderation queue, listing pending reviews oldest
+// first unless another ?status is asked for.
+func (handler *Handler) handleGetReviews(writer http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+	status := query.Get("status")
+	if status == "" {
+		status = string(models.ReviewPending)
+	}
+	page := utils.ParseStringToInt(query.Get("page"), 0)
+	perPage := utils.ParseStringToInt(os.Getenv("PER_PAGE"), 10)
+	if page == 0 {
+		page = 1
+	}
+
+	reviews, total, err := handler.store.GetReviews(status, (page-1)*perPage, perPage)
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	pages := int(math.Ceil(float64(total) / float64(perPage)))
+	utils.WriteJSON(writer, http.StatusOK, map[string]any{
+		"results": len(reviews),
+		"page":    page,
+		"pages":   pages,
+		"total":   total,
+		"data":    reviews,
+	})
+}
+
+func (handler *Handler) handleApproveReview(writer http.ResponseWriter, request *http.Request) {
+	handler.moderateReview(writer, request, models.ReviewApproved, "")
+}
+
+func (handler *Handler) handleRejectReview(writer http.ResponseWriter, request *http.Request) {
+	var payload RejectReviewPayload
+	if err := utils.ParseJSON(request, &payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	handler.moderateReview(writer, request, models.ReviewRejected, payload.Reason)
+}
+
+// moderateReview approves or rejects a review. Either can be undone later;
+// the product's rating follows whatever is currently approved.
+func (handler *Handler) moderateReview(writer http.ResponseWriter, request *http.Request, status models.ReviewStatus, reason string) {
+	review, err := handler.store.GetReview(chi.URLParam(request, "id"))
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("review not found"))
+		return
+	}
+	if review.Status == status {
+		utils.WriteError(writer, http.StatusConflict, fmt.Errorf("this review is already %s", status))
+		return
+	}
+
+	before := *review
+	now := time.Now()
+	review.Status = status
+	review.RejectionReason = reason
+	review.ModeratedAt = &now
+	if err := handler.store.ModerateReview(review); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	action := "review.approve"
+	if status == models.ReviewRejected {
+		action = "review.reject"
+	}
+	handler.audit.Record(request, action, "product_review", review.ID.String(), before, review)
+
+	utils.WriteJSON(writer, http.StatusOK, review)
+}
+
+// handleReplyToReview posts, or replaces, the shop's public reply to a
+// review.
+func (handler *Handler) handleReplyToReview(writer http.ResponseWriter, request *http.Request) {
+	var payload ReplyReviewPayload
+	if err := utils.ParseJSON(request, &payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	review, err := handler.store.GetReview(chi.URLParam(request, "id"))
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("review not found"))
+		return
+	}
+
+	before := *review
+	now := time.Now()
+	review.Reply = payload.Reply
+	review.RepliedAt = &now
+	if err := handler.store.SaveReply(review); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	handler.audit.Record(request, "review.reply", "product_review", review.ID.String(), before, review)
+
+	utils.WriteJSON(writer, http.StatusOK, review)
+}
@@ -2,24 +2,29 @@ package products
 
 import (
 	"fmt"
+	"math"
 	"net/http"
 	"os"
-	"strconv"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/go-playground/validator"
+	"github.com/go-playground/validator/v10"
 	"github.com/razdacoder/mcwale-api/models"
+	"github.com/razdacoder/mcwale-api/services/audit"
 	"github.com/razdacoder/mcwale-api/services/auth"
 	"github.com/razdacoder/mcwale-api/utils"
 )
 
 type Handler struct {
-	store *Store
+	store       *Store
+	audit       audit.Recorder
+	suggestions *suggestionIndex
 }
 
-func NewHandler(store *Store) *Handler {
+func NewHandler(store *Store, recorder audit.Recorder) *Handler {
 	return &Handler{
-		store: store,
+		store:       store,
+		audit:       recorder,
+		suggestions: newSuggestionIndex(),
 	}
 }
 
@@ -28,13 +33,13 @@ func categoriesRouter(handler *Handler) chi.Router {
 
 	router.Route("/", func(router chi.Router) {
 		router.Get("/", handler.handleGetAllCategories)
-		router.Post("/", handler.handleCreateCategory)
+		router.With(auth.IsLoggedIn, auth.RequirePermission(models.PermCategoriesWrite)).Post("/", handler.handleCreateCategory)
 	})
 
 	router.Route("/{slug}", func(router chi.Router) {
 		router.Get("/", handler.handleGetSingleCategory)
-		router.With(auth.IsLoggedIn, auth.IsAdmin).Patch("/", handler.handleUpdateCategory)
-		router.With(auth.IsLoggedIn, auth.IsAdmin).Delete("/", handler.handleDeleteCategory)
+		router.With(auth.IsLoggedIn, auth.RequirePermission(models.PermCategoriesWrite)).Patch("/", handler.handleUpdateCategory)
+		router.With(auth.IsLoggedIn, auth.RequirePermission(models.PermCategoriesDelete)).Delete("/", handler.handleDeleteCategory)
 	})
 
 	return router
@@ -48,17 +53,23 @@ func productsRouter(handler *Handler) chi.Router {
 	router.Get("/featured", handler.handleGetFeaturedProducts)
 	router.Get("/related/{slug}", handler.handleGetRelatedProducts)
 	router.Get("/search", handler.handleSearch)
+	router.Get("/suggest", handler.handleSuggest)
 
 	router.Route("/", func(router chi.Router) {
 		router.Get("/", handler.handleGetAllProducts)
 
-		router.With(auth.IsLoggedIn, auth.IsAdmin).Post("/", handler.handleCreateProduct)
+		router.With(auth.IsLoggedIn, auth.RequirePermission(models.PermProductsWrite)).Post("/", handler.handleCreateProduct)
 	})
 
 	router.Route("/{slug}", func(router chi.Router) {
 		router.Get("/", handler.handleGetSingleProduct)
-		router.With(auth.IsLoggedIn, auth.IsAdmin).Patch("/", handler.handleUpdateProduct)
-		router.With(auth.IsLoggedIn, auth.IsAdmin).Delete("/", handler.handleDeleteProduct)
+		router.Get("/reviews", handler.handleGetProductReviews)
+		router.With(auth.IsLoggedIn).Post("/reviews", handler.handleCreateReview)
+		router.With(auth.IsLoggedIn, auth.RequirePermission(models.PermProductsWrite)).Post("/variants", handler.handleCreateVariant)
+		router.With(auth.IsLoggedIn, auth.RequirePermission(models.PermProductsWrite)).Patch("/variants/{variantID}", handler.handleUpdateVariant)
+		router.With(auth.IsLoggedIn, auth.RequirePermission(models.PermProductsWrite)).Delete("/variants/{variantID}", handler.handleDeleteVariant)
+		router.With(auth.IsLoggedIn, auth.RequirePermission(models.PermProductsWrite)).Patch("/", handler.handleUpdateProduct)
+		router.With(auth.IsLoggedIn, auth.RequirePermission(models.PermProductsDelete)).Delete("/", handler.handleDeleteProduct)
 	})
 
 	return router
@@ -67,6 +78,7 @@ func productsRouter(handler *Handler) chi.Router {
 func (handler *Handler) RegisterRoutes(router chi.Router) {
 	router.Mount("/categories", categoriesRouter(handler))
 	router.Mount("/products", productsRouter(handler))
+	router.Mount("/reviews", reviewsRouter(handler))
 }
 
 func (handler *Handler) handleGetAllCategories(writer http.ResponseWriter, request *http.Request) {
@@ -92,10 +104,13 @@ func (handler *Handler) handleCreateCategory(writer http.ResponseWriter, request
 		return
 	}
 
-	if err := handler.store.CreateCategory(payload); err != nil {
+	category, err := handler.store.CreateCategory(payload)
+	if err != nil {
 		utils.WriteError(writer, http.StatusInternalServerError, err)
 		return
 	}
+	handler.suggestions.invalidate()
+	handler.audit.Record(request, "category.create", "category", category.ID.String(), nil, category)
 
 	utils.WriteJSON(writer, http.StatusCreated, map[string]string{"message": "Category Created"})
 }
@@ -127,11 +142,19 @@ func (handler *Handler) handleUpdateCategory(writer http.ResponseWriter, request
 		return
 	}
 
-	err := handler.store.UpdateCategory(slug, &patch)
+	before, err := handler.store.GetSingleCategory(slug)
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, err)
+		return
+	}
+
+	after, err := handler.store.UpdateCategory(slug, &patch)
 	if err != nil {
 		utils.WriteError(writer, http.StatusInternalServerError, err)
 		return
 	}
+	handler.suggestions.invalidate()
+	handler.audit.Record(request, "category.update", "category", after.ID.String(), before, after)
 
 	utils.WriteJSON(writer, http.StatusOK, map[string]string{"message": "Updated Successfully"})
 }
@@ -142,11 +165,18 @@ func (handler *Handler) handleDeleteCategory(writer http.ResponseWriter, request
 		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("no slug found"))
 		return
 	}
-	err := handler.store.DeleteCategory(slug)
+	category, err := handler.store.GetSingleCategory(slug)
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, err)
+		return
+	}
+	err = handler.store.DeleteCategory(slug)
 	if err != nil {
 		utils.WriteError(writer, http.StatusBadRequest, err)
 		return
 	}
+	handler.suggestions.invalidate()
+	handler.audit.Record(request, "category.delete", "category", category.ID.String(), category, nil)
 	utils.WriteJSON(writer, http.StatusNoContent, map[string]string{"message": "Deleted Successfully"})
 }
 
@@ -163,33 +193,27 @@ func (handler *Handler) handleCreateProduct(writer http.ResponseWriter, request
 		return
 	}
 
-	if err := handler.store.CreateProduct(payload); err != nil {
+	product, err := handler.store.CreateProduct(payload)
+	if err != nil {
 		utils.WriteError(writer, http.StatusInternalServerError, err)
 		return
 	}
+	handler.suggestions.invalidate()
+	handler.audit.Record(request, "product.create", "product", product.ID.String(), nil, product)
 
 	utils.WriteJSON(writer, http.StatusCreated, map[string]string{"message": "Product Created"})
 }
 
+// handleGetAllProducts lists products matching the filter described by
+// ParseProductFilter, with counts of the matches for each filter option.
 func (handler *Handler) handleGetAllProducts(writer http.ResponseWriter, request *http.Request) {
-	query := request.URL.Query()
-	minPrice, _ := strconv.ParseFloat(query.Get("min_price"), 64)
-	maxPrice, _ := strconv.ParseFloat(query.Get("max_price"), 64)
-	category_slug := query.Get("category")
-	sortBy := query.Get("sortBy")
-	page := utils.ParseStringToInt(query.Get("page"), 0)
-	perPage := utils.ParseStringToInt(os.Getenv("PER_PAGE"), 10)
-	if page == 0 {
-		page = 1
-	}
-	offset := (page - 1) * perPage
-	products, pages, err := handler.store.GetAllProducts(category_slug, minPrice, maxPrice, offset, sortBy)
+	filter, err := ParseProductFilter(request.URL.Query())
 	if err != nil {
-		utils.WriteError(writer, http.StatusInternalServerError, err)
+		utils.WriteError(writer, http.StatusBadRequest, err)
 		return
 	}
 
-	utils.WriteJSON(writer, http.StatusOK, map[string]any{"results": len(products), "page": page, "data": products, "pages": pages})
+	handler.writeProducts(writer, request, filter)
 }
 
 func (handler *Handler) handleGetSingleProduct(writer http.ResponseWriter, request *http.Request) {
@@ -218,11 +242,19 @@ func (handler *Handler) handleUpdateProduct(writer http.ResponseWriter, request
 		utils.WriteError(writer, http.StatusBadRequest, err)
 		return
 	}
-	err := handler.store.UpdateProduct(slug, &patch)
+	before, err := handler.store.GetSingleProduct(slug)
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, err)
+		return
+	}
+
+	after, err := handler.store.UpdateProduct(slug, &patch)
 	if err != nil {
 		utils.WriteError(writer, http.StatusInternalServerError, err)
 		return
 	}
+	handler.suggestions.invalidate()
+	handler.audit.Record(request, "product.update", "product", after.ID.String(), before, after)
 
 	utils.WriteJSON(writer, http.StatusOK, map[string]string{"message": "Updated Successfully"})
 }
@@ -233,11 +265,18 @@ func (handler *Handler) handleDeleteProduct(writer http.ResponseWriter, request
 		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("no slug found"))
 		return
 	}
-	err := handler.store.DeleteProduct(slug)
+	product, err := handler.store.GetSingleProduct(slug)
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, err)
+		return
+	}
+	err = handler.store.DeleteProduct(slug)
 	if err != nil {
 		utils.WriteError(writer, http.StatusBadRequest, err)
 		return
 	}
+	handler.suggestions.invalidate()
+	handler.audit.Record(request, "product.delete", "product", product.ID.String(), product, nil)
 	utils.WriteJSON(writer, http.StatusNoContent, map[string]string{"message": "Deleted Successfully"})
 }
 
@@ -247,24 +286,18 @@ func (handler *Handler) handleGetProductsByCategory(writer http.ResponseWriter,
 		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("no slug found"))
 		return
 	}
-	query := request.URL.Query()
-	style := query.Get("style")
-	sortBy := query.Get("sortBy")
-	minPrice, _ := strconv.ParseFloat(query.Get("min_price"), 64)
-	maxPrice, _ := strconv.ParseFloat(query.Get("max_price"), 64)
-	page := utils.ParseStringToInt(query.Get("page"), 0)
-	perPage := utils.ParseStringToInt(os.Getenv("PER_PAGE"), 10)
-	if page == 0 {
-		page = 1
+	if _, err := handler.store.GetSingleCategory(slug); err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("category not found"))
+		return
 	}
-	offset := (page - 1) * perPage
-	products, err := handler.store.GetProductsByCategory(slug, style, minPrice, maxPrice, offset, sortBy)
+	filter, err := ParseProductFilter(request.URL.Query())
 	if err != nil {
-		utils.WriteError(writer, http.StatusInternalServerError, err)
+		utils.WriteError(writer, http.StatusBadRequest, err)
 		return
 	}
+	filter.Categories = []string{slug}
 
-	utils.WriteJSON(writer, http.StatusOK, map[string]any{"results": len(products), "page": page, "data": products})
+	handler.writeProducts(writer, request, filter)
 }
 
 func (handler *Handler) handleGetRecentProducts(writer http.ResponseWriter, request *http.Request) {
@@ -302,22 +335,49 @@ func (handler *Handler) handleGetRelatedProducts(writer http.ResponseWriter, req
 	utils.WriteJSON(writer, http.StatusOK, products)
 }
 
+// handleSearch searches products with ?q, which takes web search syntax:
+// quoted phrases, "or" and -excluded words, along with the rest of the
+// filter described by ParseProductFilter. Matches come with highlights,
+// and mode in the response says whether the query matched exactly or only
+// by similarity.
 func (handler *Handler) handleSearch(writer http.ResponseWriter, request *http.Request) {
-	query := request.URL.Query()
-	searchQuery := query.Get("q")
-	sortBy := query.Get("sortBy")
-	page := utils.ParseStringToInt(query.Get("page"), 0)
+	filter, err := ParseProductFilter(request.URL.Query())
+	if err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	handler.writeProducts(writer, request, filter)
+}
+
+// writeProducts writes a page of the products matching the filter along
+// with facet counts for narrowing it further.
+func (handler *Handler) writeProducts(writer http.ResponseWriter, request *http.Request, filter ProductFilter) {
+	page := utils.ParseStringToInt(request.URL.Query().Get("page"), 0)
 	perPage := utils.ParseStringToInt(os.Getenv("PER_PAGE"), 10)
 	if page == 0 {
 		page = 1
 	}
-	offset := (page - 1) * perPage
+	filter.Offset = (page - 1) * perPage
+	filter.Limit = perPage
 
-	products, err := handler.store.SearchProduct(searchQuery, offset, sortBy)
+	result, err := handler.store.ListProducts(filter)
 	if err != nil {
 		utils.WriteError(writer, http.StatusInternalServerError, err)
 		return
 	}
+	if page == 1 && filter.Query != "" {
+		handler.recordSearch(filter.Query, result)
+	}
 
-	utils.WriteJSON(writer, http.StatusOK, map[string]any{"results": len(products), "page": page, "data": products})
+	pages := int(math.Ceil(float64(result.Total) / float64(perPage)))
+	utils.WriteJSON(writer, http.StatusOK, map[string]any{
+		"results": len(result.Hits),
+		"page":    page,
+		"pages":   pages,
+		"total":   result.Total,
+		"mode":    result.Mode,
+		"facets":  result.Facets,
+		"data":    result.Hits,
+	})
 }
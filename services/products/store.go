@@ -1,12 +1,16 @@
 package products
 
 import (
-	"math"
-	"os"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/razdacoder/mcwale-api/models"
 	"github.com/razdacoder/mcwale-api/utils"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Store struct {
@@ -25,7 +29,7 @@ func (store *Store) GetAllCategories() ([]models.Category, error) {
 	return categories, result.Error
 }
 
-func (store *Store) CreateCategory(payload CreateCategoryPayload) error {
+func (store *Store) CreateCategory(payload CreateCategoryPayload) (*models.Category, error) {
 	category := &models.Category{
 		Title:  payload.Title,
 		Slug:   payload.Slug,
@@ -33,7 +37,7 @@ func (store *Store) CreateCategory(payload CreateCategoryPayload) error {
 		Image:  payload.Image,
 	}
 	result := store.db.Create(category)
-	return result.Error
+	return category, result.Error
 }
 
 func (store *Store) GetSingleCategory(slug string) (*models.Category, error) {
@@ -42,13 +46,18 @@ func (store *Store) GetSingleCategory(slug string) (*models.Category, error) {
 	return &category, result.Error
 }
 
-func (store *Store) UpdateCategory(slug string, category *models.Category) error {
+func (store *Store) UpdateCategory(slug string, category *models.Category) (*models.Category, error) {
 	existingCategory, err := store.GetSingleCategory(slug)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	results := store.db.Model(&existingCategory).Updates(&category)
-	return results.Error
+	if results.Error != nil {
+		return nil, results.Error
+	}
+	var updated models.Category
+	results = store.db.Where("id = ?", existingCategory.ID).First(&updated)
+	return &updated, results.Error
 }
 
 func (store *Store) DeleteCategory(slug string) error {
@@ -56,11 +65,19 @@ func (store *Store) DeleteCategory(slug string) error {
 	if err != nil {
 		return err
 	}
-	results := store.db.Select("Products").Delete(&category)
-	return results.Error
+	// The category and its products are stamped with the same deletion time
+	// so restoring the category brings back exactly these products.
+	deletedAt := time.Now()
+	return store.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.Product{}).Where("category_id = ?", category.ID).Update("deleted_at", deletedAt).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(category).Update("deleted_at", deletedAt).Error
+	})
 }
 
-func (store *Store) CreateProduct(payload CreateProductPayload) error {
+func (store *Store) CreateProduct(payload CreateProductPayload) (*models.Product, error) {
 	product := &models.Product{
 		Title:              payload.Title,
 		Slug:               payload.Slug,
@@ -72,61 +89,39 @@ func (store *Store) CreateProduct(payload CreateProductPayload) error {
 		DiscountPercentage: payload.DiscountPercentage,
 		CategoryID:         payload.CategoryID,
 	}
-
-	result := store.db.Create(product)
-	return result.Error
-}
-
-func (store *Store) GetAllProducts(category_slug string, minPrice, maxPrice float64, offset int, sortBy string) ([]models.Product, int, error) {
-	var products []models.Product
-	db := store.db.Model(&models.Product{})
-	var total int64
-
-	if minPrice != 0 {
-		db = db.Where("price >= ?", minPrice)
-	}
-	if category_slug != "" {
-		category, err := store.GetSingleCategory(category_slug)
-		if err != nil {
-			return nil, 0, err
-		}
-		db = db.Where("category_id = ?", category.ID)
-	}
-	if maxPrice != 0 {
-		db = db.Where("price <= ?", maxPrice)
+	for _, variant := range payload.Variants {
+		product.Variants = append(product.Variants, models.ProductVariant{
+			Size:  strings.TrimSpace(variant.Size),
+			Color: strings.TrimSpace(variant.Color),
+			SKU:   variant.SKU,
+			Stock: variant.Stock,
+		})
 	}
 
-	switch sortBy {
-	case "new_arrivals":
-		db = db.Order("created_at DESC")
-	case "price_low_to_high":
-		db = db.Order("price ASC")
-	case "price_high_to_low":
-		db = db.Order("price DESC")
-	default:
-		db = db.Order("created_at DESC")
-	}
-	db.Count(&total)
-
-	pages := int(math.Ceil(float64(total) / float64(utils.ParseStringToInt(os.Getenv("PER_PAGE"), 10))))
-	result := db.Offset(offset).Limit(utils.ParseStringToInt(os.Getenv("PER_PAGE"), 10)).Preload("Category").Find(&products)
-	return products, pages, result.Error
+	result := store.db.Create(product)
+	return product, result.Error
 }
 
 func (store *Store) GetSingleProduct(slug string) (*models.Product, error) {
 	var product models.Product
-	result := store.db.Model(&models.Product{}).Where("slug = ?", slug).Preload("Category").First(&product)
+	result := store.db.Model(&models.Product{}).Where("slug = ?", slug).
+		Preload("Category").
+		Preload("Variants", func(db *gorm.DB) *gorm.DB { return db.Order("size, color") }).
+		First(&product)
 	return &product, result.Error
 }
 
-func (store *Store) UpdateProduct(slug string, product *models.Product) error {
+func (store *Store) UpdateProduct(slug string, product *models.Product) (*models.Product, error) {
 	existingProduct, err := store.GetSingleProduct(slug)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	productMap := utils.ParseProductUpdate(product)
 	results := store.db.Model(&existingProduct).Select("title", "images", "is_featured", "description", "style", "price", "discount_percentage").Updates(productMap)
-	return results.Error
+	if results.Error != nil {
+		return nil, results.Error
+	}
+	return store.GetSingleProduct(slug)
 }
 
 func (store *Store) DeleteProduct(slug string) error {
@@ -138,38 +133,34 @@ func (store *Store) DeleteProduct(slug string) error {
 	return results.Error
 }
 
-func (store *Store) GetProductsByCategory(slug, style string, minPrice, maxPrice float64, offset int, sortBy string) ([]models.Product, error) {
-	var products []models.Product
-	category, err := store.GetSingleCategory(slug)
-	if err != nil {
+func (store *Store) GetVariant(productID uuid.UUID, id string) (*models.ProductVariant, error) {
+	var variant models.ProductVariant
+	if err := store.db.Where("id = ? AND product_id = ?", id, productID).First(&variant).Error; err != nil {
 		return nil, err
 	}
-	db := store.db.Model(&models.Product{}).Where("category_id = ?", category.ID)
+	return &variant, nil
+}
 
-	if style != "" {
-		db = db.Where("style = ?", style)
-	}
-	if minPrice != 0 {
-		db = db.Where("price >= ?", minPrice)
-	}
+// VariantExists reports whether the product already comes in the size and
+// color, other than as the variant being edited.
+func (store *Store) VariantExists(productID uuid.UUID, size, color string, except uuid.UUID) (bool, error) {
+	var count int64
+	err := store.db.Model(&models.ProductVariant{}).
+		Where("product_id = ? AND size = ? AND color = ? AND id <> ?", productID, size, color, except).
+		Count(&count).Error
+	return count > 0, err
+}
 
-	if maxPrice != 0 {
-		db = db.Where("price <= ?", maxPrice)
-	}
+func (store *Store) CreateVariant(variant *models.ProductVariant) error {
+	return store.db.Create(variant).Error
+}
 
-	switch sortBy {
-	case "new_arrivals":
-		db = db.Order("created_at DESC")
-	case "price_low_to_high":
-		db = db.Order("price ASC")
-	case "price_high_to_low":
-		db = db.Order("price DESC")
-	default:
-		db = db.Order("created_at DESC")
-	}
+func (store *Store) UpdateVariant(variant *models.ProductVariant) error {
+	return store.db.Model(variant).Select("size", "color", "sku", "stock").Updates(variant).Error
+}
 
-	results := db.Offset(offset).Limit(utils.ParseStringToInt(os.Getenv("PER_PAGE"), 10)).Preload("Category").Find(&products)
-	return products, results.Error
+func (store *Store) DeleteVariant(variant *models.ProductVariant) error {
+	return store.db.Delete(variant).Error
 }
 
 func (store *Store) GetRecentProducts() ([]models.Product, error) {
@@ -195,26 +186,477 @@ func (store *Store) GetRelatedProducts(slug string) ([]models.Product, error) {
 	return products, results.Error
 }
 
-func (store *Store) SearchProduct(query string, offset int, sortBy string) ([]models.Product, error) {
-	var products []models.Product
+// headlineOptions configure the highlighted snippets in search results.
+const (
+	titleHeadline       = "StartSel=<mark>, StopSel=</mark>, HighlightAll=true"
+	descriptionHeadline = "StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=25, MinWords=10"
+)
+
+// ListProducts returns a page of the products matching the filter, with
+// facet counts. A query is ranked against products' title, category, style
+// and description, in that order of weight; when nothing matches it falls
+// back to trigram similarity on titles and styles so misspelt queries still
+// find something.
+func (store *Store) ListProducts(filter ProductFilter) (*SearchResult, error) {
+	result := &SearchResult{Mode: SearchAll}
+	if filter.Query != "" {
+		result.Mode = SearchFullText
+		if err := store.filterProducts(filter, result.Mode, "").Count(&result.Total).Error; err != nil {
+			return nil, err
+		}
+		if result.Total == 0 {
+			result.Mode = SearchFuzzy
+		}
+	}
+	if result.Mode != SearchFullText {
+		if err := store.filterProducts(filter, result.Mode, "").Count(&result.Total).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	hits, err := store.searchHits(filter, result.Mode)
+	if err != nil {
+		return nil, err
+	}
+	result.Hits = hits
+
+	facets, err := store.searchFacets(filter, result.Mode)
+	if err != nil {
+		return nil, err
+	}
+	result.Facets = *facets
+	return result, nil
+}
+
+// filterProducts selects the products matching the filter in the given
+// mode, leaving out the filter named by skip so its facet can be counted.
+func (store *Store) filterProducts(filter ProductFilter, mode, skip string) *gorm.DB {
 	db := store.db.Model(&models.Product{})
 
-	if query != "" {
-		db = db.Where("(title ILIKE ? OR style ILIKE ?)",
-			"%"+query+"%", "%"+query+"%")
+	switch mode {
+	case SearchFullText:
+		db = db.Where("products.search_vector @@ websearch_to_tsquery('english', ?)", filter.Query)
+	case SearchFuzzy:
+		db = db.Where("(? <% products.title OR ? <% products.style)", filter.Query, filter.Query)
+	}
+
+	if len(filter.Categories) > 0 && skip != "category" {
+		db = db.Where("products.category_id IN (SELECT id FROM categories WHERE slug IN ? AND deleted_at IS NULL)", filter.Categories)
+	}
+	if len(filter.Styles) > 0 && skip != "style" {
+		db = db.Where("products.style IN ?", filter.Styles)
+	}
+	if skip != "price" {
+		if filter.MinPrice != 0 {
+			db = db.Where("products.price >= ?", filter.MinPrice)
+		}
+		if filter.MaxPrice != 0 {
+			db = db.Where("products.price <= ?", filter.MaxPrice)
+		}
+	}
+	if filter.OnSale && skip != "on_sale" {
+		db = db.Where("products.discount_percentage > 0")
+	}
+	if filter.Featured && skip != "featured" {
+		db = db.Where("products.is_featured = ?", true)
+	}
+
+	// Size, color and stock must all hold for the same variant: asking for
+	// a red medium in stock should not match a red small and a blue medium.
+	variant := variantConditions(filter, skip)
+	if variant != nil {
+		query, args := variant.sql()
+		db = db.Where("EXISTS (SELECT 1 FROM product_variants WHERE product_variants.product_id = products.id AND "+query+")", args...)
+	} else if filter.InStock && skip != "in_stock" {
+		db = db.Where("(NOT EXISTS (SELECT 1 FROM product_variants WHERE product_variants.product_id = products.id) OR " +
+			"EXISTS (SELECT 1 FROM product_variants WHERE product_variants.product_id = products.id AND product_variants.stock > 0))")
+	}
+	return db
+}
+
+func (store *Store) searchHits(filter ProductFilter, mode string) ([]SearchHit, error) {
+	var rows []struct {
+		ID                   uuid.UUID
+		Rank                 float64
+		TitleHighlight       string
+		DescriptionHighlight string
+	}
+	db := store.filterProducts(filter, mode, "")
+	switch mode {
+	case SearchFullText:
+		db = db.Select(`products.id,
+			ts_rank_cd(products.search_vector, websearch_to_tsquery('english', ?), 32) AS rank,
+			ts_headline('english', products.title, websearch_to_tsquery('english', ?), '`+titleHeadline+`') AS title_highlight,
+			ts_headline('english', products.description, websearch_to_tsquery('english', ?), '`+descriptionHeadline+`') AS description_highlight`,
+			filter.Query, filter.Query, filter.Query)
+	case SearchFuzzy:
+		db = db.Select("products.id, GREATEST(word_similarity(?, products.title), word_similarity(?, products.style)) AS rank",
+			filter.Query, filter.Query)
+	default:
+		db = db.Select("products.id, 0 AS rank")
+	}
+
+	if mode != SearchAll && (filter.SortBy == "" || filter.SortBy == "relevance") {
+		db = db.Order("rank DESC").Order("created_at DESC")
+	} else {
+		db = sortProducts(db, filter.SortBy)
+	}
+	if err := db.Offset(filter.Offset).Limit(filter.Limit).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	ids := make([]uuid.UUID, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.ID)
+	}
+	var products []models.Product
+	if err := store.db.Preload("Category").Preload("Variants").Where("id IN ?", ids).Find(&products).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uuid.UUID]models.Product, len(products))
+	for _, product := range products {
+		byID[product.ID] = product
+	}
+
+	hits := make([]SearchHit, 0, len(rows))
+	for _, row := range rows {
+		product, ok := byID[row.ID]
+		if !ok {
+			continue
+		}
+		hits = append(hits, SearchHit{
+			Product:              product,
+			Rank:                 row.Rank,
+			TitleHighlight:       row.TitleHighlight,
+			DescriptionHighlight: row.DescriptionHighlight,
+		})
+	}
+	return hits, nil
+}
+
+func (store *Store) searchFacets(filter ProductFilter, mode string) (*SearchFacets, error) {
+	facets := &SearchFacets{}
+
+	var categoryCounts []struct {
+		CategoryID uuid.UUID
+		Count      int64
 	}
+	err := store.filterProducts(filter, mode, "category").
+		Select("products.category_id, COUNT(*) AS count").
+		Group("products.category_id").
+		Scan(&categoryCounts).Error
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uuid.UUID, 0, len(categoryCounts))
+	for _, row := range categoryCounts {
+		ids = append(ids, row.CategoryID)
+	}
+	var categories []models.Category
+	if err := store.db.Where("id IN ?", ids).Find(&categories).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uuid.UUID]models.Category, len(categories))
+	for _, category := range categories {
+		byID[category.ID] = category
+	}
+	facets.Categories = []Facet{}
+	for _, row := range categoryCounts {
+		if category, ok := byID[row.CategoryID]; ok {
+			facets.Categories = append(facets.Categories, Facet{Value: category.Slug, Label: category.Title, Count: row.Count})
+		}
+	}
+	sort.Slice(facets.Categories, func(i, j int) bool { return facets.Categories[i].Count > facets.Categories[j].Count })
 
+	facets.Styles = []Facet{}
+	err = store.filterProducts(filter, mode, "style").
+		Select("products.style AS value, products.style AS label, COUNT(*) AS count").
+		Group("products.style").
+		Order("count DESC").
+		Scan(&facets.Styles).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if facets.Sizes, err = store.variantFacet(filter, mode, "size"); err != nil {
+		return nil, err
+	}
+	if facets.Colors, err = store.variantFacet(filter, mode, "color"); err != nil {
+		return nil, err
+	}
+
+	// Each flag counts the matches there would be with it turned on.
+	flags := []struct {
+		count *int64
+		set   func(*ProductFilter)
+	}{
+		{&facets.Availability.InStock, func(f *ProductFilter) { f.InStock = true }},
+		{&facets.Availability.OnSale, func(f *ProductFilter) { f.OnSale = true }},
+		{&facets.Availability.Featured, func(f *ProductFilter) { f.Featured = true }},
+	}
+	for _, flag := range flags {
+		flagged := filter
+		flag.set(&flagged)
+		if err := store.filterProducts(flagged, mode, "").Count(flag.count).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	// Each product falls in the last bucket whose lower bound it reaches.
+	bucket := "CASE"
+	args := []interface{}{}
+	for i := len(priceBuckets) - 1; i > 0; i-- {
+		bucket += fmt.Sprintf(" WHEN products.price >= ? THEN %d", i)
+		args = append(args, priceBuckets[i])
+	}
+	bucket += " ELSE 0 END"
+	var priceCounts []struct {
+		Bucket int
+		Count  int64
+	}
+	err = store.filterProducts(filter, mode, "price").
+		Select(bucket+" AS bucket, COUNT(*) AS count", args...).
+		Group("bucket").
+		Scan(&priceCounts).Error
+	if err != nil {
+		return nil, err
+	}
+	counts := map[int]int64{}
+	for _, row := range priceCounts {
+		counts[row.Bucket] = row.Count
+	}
+	for i, lower := range priceBuckets {
+		facet := PriceFacet{Min: lower, Count: counts[i]}
+		if i+1 < len(priceBuckets) {
+			upper := priceBuckets[i+1]
+			facet.Max = &upper
+		}
+		facets.Prices = append(facets.Prices, facet)
+	}
+	return facets, nil
+}
+
+// variantFacet counts the matching products available in each size or
+// color, keeping to variants that also match the filter's other variant
+// conditions.
+func (store *Store) variantFacet(filter ProductFilter, mode, column string) ([]Facet, error) {
+	facet := []Facet{}
+	db := store.filterProducts(filter, mode, column).
+		Joins("JOIN product_variants ON product_variants.product_id = products.id")
+	if variant := variantConditions(filter, column); variant != nil {
+		query, args := variant.sql()
+		db = db.Where(query, args...)
+	} else if filter.InStock {
+		db = db.Where("product_variants.stock > 0")
+	}
+	err := db.Select(fmt.Sprintf("product_variants.%[1]s AS value, product_variants.%[1]s AS label, COUNT(DISTINCT products.id) AS count", column)).
+		Group("product_variants." + column).
+		Order("count DESC").
+		Scan(&facet).Error
+	return facet, err
+}
+
+// sortProducts applies one of the listing sort options, newest first by
+// default.
+func sortProducts(db *gorm.DB, sortBy string) *gorm.DB {
 	switch sortBy {
 	case "new_arrivals":
-		db = db.Order("created_at DESC")
+		return db.Order("created_at DESC")
 	case "price_low_to_high":
-		db = db.Order("price ASC")
+		return db.Order("price ASC")
 	case "price_high_to_low":
-		db = db.Order("price DESC")
+		return db.Order("price DESC")
+	case "rating":
+		return db.Order("rating_average DESC").Order("rating_count DESC").Order("created_at DESC")
 	default:
-		db = db.Order("created_at DESC")
+		return db.Order("created_at DESC")
+	}
+}
+
+func (store *Store) GetUser(id uuid.UUID) (*models.User, error) {
+	var user models.User
+	if err := store.db.Where("id = ?", id).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// HasDeliveredOrder reports whether the customer has received the product,
+// counting orders placed with their verified email before they signed up.
+func (store *Store) HasDeliveredOrder(user *models.User, productID uuid.UUID) (bool, error) {
+	var count int64
+	db := store.db.Model(&models.OrderItem{}).
+		Joins("JOIN orders ON orders.id = order_items.order_id AND orders.deleted_at IS NULL").
+		Where("order_items.product_id = ? AND orders.status = ?", productID, models.Delivered)
+	if email := user.VerifiedEmail(); email != "" {
+		db = db.Where("orders.user_id = ? OR orders.email = ?", user.ID, email)
+	} else {
+		db = db.Where("orders.user_id = ?", user.ID)
+	}
+	err := db.Count(&count).Error
+	return count > 0, err
+}
+
+func (store *Store) HasReviewed(productID, userID uuid.UUID) (bool, error) {
+	var count int64
+	err := store.db.Model(&models.ProductReview{}).Where("product_id = ? AND user_id = ?", productID, userID).Count(&count).Error
+	return count > 0, err
+}
+
+func (store *Store) CreateReview(review *models.ProductReview) error {
+	return store.db.Omit("Product", "User").Create(review).Error
+}
+
+func (store *Store) GetReview(id string) (*models.ProductReview, error) {
+	var review models.ProductReview
+	if err := store.db.Preload("Product").Where("id = ?", id).First(&review).Error; err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
+
+// GetProductReviews returns a page of a product's approved reviews, newest
+// first unless sorted by rating.
+func (store *Store) GetProductReviews(productID uuid.UUID, sortBy string, offset, limit int) ([]models.ProductReview, int64, error) {
+	var reviews []models.ProductReview
+	var total int64
+	db := store.db.Model(&models.ProductReview{}).Where("product_id = ? AND status = ?", productID, models.ReviewApproved)
+
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
 	}
 
-	result := db.Offset(offset).Limit(utils.ParseStringToInt(os.Getenv("PER_PAGE"), 10)).Preload("Category").Find(&products)
-	return products, result.Error
+	switch sortBy {
+	case "highest":
+		db = db.Order("rating DESC")
+	case "lowest":
+		db = db.Order("rating ASC")
+	}
+	result := db.Order("created_at DESC").Offset(offset).Limit(limit).Find(&reviews)
+	return reviews, total, result.Error
+}
+
+// GetRatingBreakdown counts a product's approved reviews by star rating.
+func (store *Store) GetRatingBreakdown(productID uuid.UUID) (map[int]int64, error) {
+	var rows []struct {
+		Rating int
+		Count  int64
+	}
+	err := store.db.Model(&models.ProductReview{}).
+		Select("rating, COUNT(*) AS count").
+		Where("product_id = ? AND status = ?", productID, models.ReviewApproved).
+		Group("rating").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	breakdown := map[int]int64{1: 0, 2: 0, 3: 0, 4: 0, 5: 0}
+	for _, row := range rows {
+		breakdown[row.Rating] = row.Count
+	}
+	return breakdown, nil
+}
+
+// GetReviews is the moderation queue: reviews with the given status,
+// oldest first so nothing waits too long.
+func (store *Store) GetReviews(status string, offset, limit int) ([]models.ProductReview, int64, error) {
+	var reviews []models.ProductReview
+	var total int64
+	db := store.db.Model(&models.ProductReview{})
+	if status != "" {
+		db = db.Where("status = ?", status)
+	}
+
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	result := db.Preload("Product").Order("created_at ASC").Offset(offset).Limit(limit).Find(&reviews)
+	return reviews, total, result.Error
+}
+
+func (store *Store) GetUserReviews(userID uuid.UUID) ([]models.ProductReview, error) {
+	var reviews []models.ProductReview
+	result := store.db.Preload("Product").Where("user_id = ?", userID).Order("created_at DESC").Find(&reviews)
+	return reviews, result.Error
+}
+
+// ModerateReview saves a review's new status and brings its product's
+// rating up to date in the same transaction.
+func (store *Store) ModerateReview(review *models.ProductReview) error {
+	return store.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(review).Select("status", "rejection_reason", "moderated_at").Updates(review).Error
+		if err != nil {
+			return err
+		}
+		return RefreshRatings(tx, review.ProductID)
+	})
+}
+
+func (store *Store) SaveReply(review *models.ProductReview) error {
+	return store.db.Model(review).Select("reply", "replied_at").Updates(review).Error
+}
+
+// RefreshRatings recalculates the rating aggregates of the given products
+// from their approved reviews.
+func RefreshRatings(tx *gorm.DB, productIDs ...uuid.UUID) error {
+	if len(productIDs) == 0 {
+		return nil
+	}
+	return tx.Exec(`UPDATE products SET
+		rating_average = (SELECT COALESCE(ROUND(AVG(rating), 2), 0) FROM product_reviews WHERE product_id = products.id AND status = ?),
+		rating_count = (SELECT COUNT(*) FROM product_reviews WHERE product_id = products.id AND status = ?)
+		WHERE id IN ?`, models.ReviewApproved, models.ReviewApproved, productIDs).Error
+}
+
+// RecordSearch counts a use of a search term today. A term only counts if a
+// product matches every word in it, so words cannot be slipped into trending
+// searches behind an "or" or a "-" that the search itself honours.
+func (store *Store) RecordSearch(term string, day time.Time) error {
+	var matches int64
+	err := store.db.Model(&models.Product{}).
+		Where("search_vector @@ plainto_tsquery('english', ?)", term).
+		Limit(1).
+		Count(&matches).Error
+	if err != nil || matches == 0 {
+		return err
+	}
+
+	return store.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "term"}, {Name: "day"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"count": gorm.Expr("search_term_counts.count + 1")}),
+	}).Create(&models.SearchTermCount{Term: term, Day: day, Count: 1}).Error
+}
+
+// GetPopularSearches returns the most used search terms since the given
+// day.
+func (store *Store) GetPopularSearches(since time.Time, limit int) ([]PopularSearch, error) {
+	var searches []PopularSearch
+	err := store.db.Model(&models.SearchTermCount{}).
+		Select("term, SUM(count) AS count").
+		Where("day >= ?", since).
+		Group("term").
+		Order("count DESC").
+		Limit(limit).
+		Scan(&searches).Error
+	return searches, err
+}
+
+// GetSuggestionSources loads everything the search box can suggest: every
+// category, every style in use and every product title.
+func (store *Store) GetSuggestionSources() ([]models.Category, []string, []models.Product, error) {
+	var categories []models.Category
+	if err := store.db.Select("id", "title", "slug").Find(&categories).Error; err != nil {
+		return nil, nil, nil, err
+	}
+	var styles []string
+	if err := store.db.Model(&models.Product{}).Distinct().Pluck("style", &styles).Error; err != nil {
+		return nil, nil, nil, err
+	}
+	var products []models.Product
+	if err := store.db.Select("id", "title", "slug", "rating_count").Find(&products).Error; err != nil {
+		return nil, nil, nil, err
+	}
+	return categories, styles, products, nil
 }
@@ -0,0 +1,239 @@
+package products
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/razdacoder/mcwale-api/utils"
+)
+
+const (
+	// maxNodeSuggestions caps how many completions each prefix keeps.
+	maxNodeSuggestions = 20
+	// trendingDays is how far back searches count towards trending.
+	trendingDays = 7
+	// popularDays is how far back searches count towards suggestions.
+	popularDays = 30
+	// maxSearchTermLength is the longest search term that is counted.
+	maxSearchTermLength = 100
+)
+
+// trieNode holds the best completions for the prefix leading to it, so a
+// lookup only walks the characters typed.
+type trieNode struct {
+	children map[rune]*trieNode
+	top      []*Suggestion
+}
+
+func newTrieNode() *trieNode {
+	return &trieNode{children: map[rune]*trieNode{}}
+}
+
+func (node *trieNode) insert(key string, suggestion *Suggestion) {
+	for _, char := range key {
+		child, ok := node.children[char]
+		if !ok {
+			child = newTrieNode()
+			node.children[char] = child
+		}
+		node = child
+		node.add(suggestion)
+	}
+}
+
+// insertWords indexes a suggestion from the start of every word in key, so
+// "agbada" finds "Royal Agbada".
+func (node *trieNode) insertWords(key string, suggestion *Suggestion) {
+	for i := range key {
+		if i == 0 || key[i-1] == ' ' {
+			node.insert(key[i:], suggestion)
+		}
+	}
+}
+
+func (node *trieNode) add(suggestion *Suggestion) {
+	for _, existing := range node.top {
+		if existing == suggestion {
+			return
+		}
+	}
+	index := sort.Search(len(node.top), func(i int) bool { return ranksBefore(suggestion, node.top[i]) })
+	if index >= maxNodeSuggestions {
+		return
+	}
+	node.top = append(node.top, nil)
+	copy(node.top[index+1:], node.top[index:])
+	node.top[index] = suggestion
+	if len(node.top) > maxNodeSuggestions {
+		node.top = node.top[:maxNodeSuggestions]
+	}
+}
+
+func ranksBefore(a, b *Suggestion) bool {
+	if a.weight != b.weight {
+		return a.weight > b.weight
+	}
+	if a.popularity != b.popularity {
+		return a.popularity > b.popularity
+	}
+	return a.Text < b.Text
+}
+
+func (node *trieNode) lookup(prefix string, limit int) []Suggestion {
+	for _, char := range prefix {
+		child, ok := node.children[char]
+		if !ok {
+			return []Suggestion{}
+		}
+		node = child
+	}
+	suggestions := make([]Suggestion, 0, min(limit, len(node.top)))
+	for _, suggestion := range node.top {
+		if len(suggestions) == limit {
+			break
+		}
+		suggestions = append(suggestions, *suggestion)
+	}
+	return suggestions
+}
+
+// suggestionIndex is an in-memory trie of categories, styles, popular
+// searches and product titles. It is rebuilt on the next lookup after the
+// catalog changes, and periodically so changes made through other API
+// instances and newly popular searches show up too.
+type suggestionIndex struct {
+	mu      sync.Mutex
+	root    *trieNode
+	builtAt time.Time
+	stale   bool
+	refresh time.Duration
+}
+
+// newSuggestionIndex reads SUGGEST_REFRESH, how often the index is rebuilt
+// regardless of changes, defaulting to 5m.
+func newSuggestionIndex() *suggestionIndex {
+	refresh, err := time.ParseDuration(os.Getenv("SUGGEST_REFRESH"))
+	if err != nil || refresh <= 0 {
+		refresh = 5 * time.Minute
+	}
+	return &suggestionIndex{refresh: refresh}
+}
+
+// invalidate marks the index to be rebuilt after a catalog change.
+func (index *suggestionIndex) invalidate() {
+	index.mu.Lock()
+	index.stale = true
+	index.mu.Unlock()
+}
+
+func (index *suggestionIndex) lookup(store *Store, prefix string, limit int) ([]Suggestion, error) {
+	index.mu.Lock()
+	if index.root == nil || index.stale || time.Since(index.builtAt) > index.refresh {
+		root, err := buildSuggestions(store)
+		if err != nil {
+			index.mu.Unlock()
+			return nil, err
+		}
+		index.root = root
+		index.builtAt = time.Now()
+		index.stale = false
+	}
+	root := index.root
+	index.mu.Unlock()
+
+	// A built trie is never modified, so it can be read without the lock.
+	return root.lookup(prefix, limit), nil
+}
+
+func buildSuggestions(store *Store) (*trieNode, error) {
+	categories, styles, products, err := store.GetSuggestionSources()
+	if err != nil {
+		return nil, err
+	}
+	popular, err := store.GetPopularSearches(time.Now().AddDate(0, 0, -popularDays), 500)
+	if err != nil {
+		return nil, err
+	}
+
+	root := newTrieNode()
+	seen := map[string]bool{}
+	add := func(suggestion *Suggestion) {
+		key := normalizeSearch(suggestion.Text)
+		if key == "" || seen[key] {
+			return
+		}
+		seen[key] = true
+		root.insertWords(key, suggestion)
+	}
+
+	for _, category := range categories {
+		add(&Suggestion{Text: category.Title, Kind: SuggestCategory, Slug: category.Slug, weight: 4})
+	}
+	for _, style := range styles {
+		add(&Suggestion{Text: style, Kind: SuggestStyle, weight: 3})
+	}
+	for _, search := range popular {
+		add(&Suggestion{Text: search.Term, Kind: SuggestQuery, weight: 2, popularity: search.Count})
+	}
+	for _, product := range products {
+		add(&Suggestion{Text: product.Title, Kind: SuggestProduct, Slug: product.Slug, weight: 1, popularity: int64(product.RatingCount)})
+	}
+	return root, nil
+}
+
+// normalizeSearch lowercases a search term and collapses its whitespace.
+func normalizeSearch(term string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(term), unicode.IsSpace), " ")
+}
+
+// handleSuggest completes what has been typed into the search box with
+// matching categories, styles, popular searches and products, up to
+// ?limit (default 8, at most 20). With no ?q it returns trending searches.
+func (handler *Handler) handleSuggest(writer http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+	limit := utils.ParseStringToInt(query.Get("limit"), 8)
+	limit = max(1, min(limit, maxNodeSuggestions))
+	prefix := normalizeSearch(query.Get("q"))
+
+	if prefix == "" {
+		trending, err := handler.store.GetPopularSearches(time.Now().AddDate(0, 0, -trendingDays), limit)
+		if err != nil {
+			utils.WriteError(writer, http.StatusInternalServerError, err)
+			return
+		}
+		suggestions := make([]Suggestion, 0, len(trending))
+		for _, search := range trending {
+			suggestions = append(suggestions, Suggestion{Text: search.Term, Kind: SuggestQuery})
+		}
+		utils.WriteJSON(writer, http.StatusOK, map[string]any{"query": "", "trending": true, "suggestions": suggestions})
+		return
+	}
+
+	suggestions, err := handler.suggestions.lookup(handler.store, prefix, limit)
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	utils.WriteJSON(writer, http.StatusOK, map[string]any{"query": prefix, "trending": false, "suggestions": suggestions})
+}
+
+// recordSearch counts a search towards popular and trending searches.
+// Searches that only matched by similarity, or not at all, are not counted
+// so typos do not trend.
+func (handler *Handler) recordSearch(term string, result *SearchResult) {
+	term = normalizeSearch(term)
+	if term == "" || result.Mode != SearchFullText || result.Total == 0 || len(term) > maxSearchTermLength {
+		return
+	}
+	now := time.Now()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if err := handler.store.RecordSearch(term, day); err != nil {
+		log.Printf("failed to record search %q: %v", term, err)
+	}
+}
@@ -0,0 +1,99 @@
+package products
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func suggestionTexts(suggestions []Suggestion) []string {
+	texts := []string{}
+	for _, suggestion := range suggestions {
+		texts = append(texts, suggestion.Text)
+	}
+	return texts
+}
+
+func TestNormalizeSearch(t *testing.T) {
+	tests := []struct {
+		term string
+		want string
+	}{
+		{"Agbada", "agbada"},
+		{"  Royal   Agbada ", "royal agbada"},
+		{"kaftan\tand\ncap", "kaftan and cap"},
+		{"   ", ""},
+	}
+	for _, test := range tests {
+		if got := normalizeSearch(test.term); got != test.want {
+			t.Errorf("normalizeSearch(%q) = %q, want %q", test.term, got, test.want)
+		}
+	}
+}
+
+func TestTrieLookup(t *testing.T) {
+	root := newTrieNode()
+	for _, suggestion := range []*Suggestion{
+		{Text: "Agbada", Kind: SuggestCategory, weight: 4},
+		{Text: "Ankara", Kind: SuggestStyle, weight: 3},
+		{Text: "agbada for weddings", Kind: SuggestQuery, weight: 2, popularity: 3},
+		{Text: "ankara dress", Kind: SuggestQuery, weight: 2, popularity: 9},
+		{Text: "Royal Agbada", Kind: SuggestProduct, weight: 1, popularity: 12},
+		{Text: "Ankara Wrap Dress", Kind: SuggestProduct, weight: 1, popularity: 2},
+		{Text: "Aso Oke Cap", Kind: SuggestProduct, weight: 1, popularity: 2},
+	} {
+		root.insertWords(normalizeSearch(suggestion.Text), suggestion)
+	}
+
+	tests := []struct {
+		name   string
+		prefix string
+		limit  int
+		want   []string
+	}{
+		{"ranked by kind then popularity", "a", 20, []string{
+			"Agbada", "Ankara", "ankara dress", "agbada for weddings", "Royal Agbada", "Ankara Wrap Dress", "Aso Oke Cap",
+		}},
+		{"narrowed by each character", "ag", 20, []string{"Agbada", "agbada for weddings", "Royal Agbada"}},
+		{"later words", "dress", 20, []string{"ankara dress", "Ankara Wrap Dress"}},
+		{"across words", "ankara d", 20, []string{"ankara dress"}},
+		{"limited", "a", 2, []string{"Agbada", "Ankara"}},
+		{"no match", "kaftan", 20, []string{}},
+		{"middle of a word", "bada", 20, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := suggestionTexts(root.lookup(test.prefix, test.limit))
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("lookup(%q) = %v, want %v", test.prefix, got, test.want)
+			}
+		})
+	}
+}
+
+func TestTrieSuggestionAddedOnce(t *testing.T) {
+	root := newTrieNode()
+	// "cap" starts two words of the key, so the suggestion reaches the "c"
+	// node twice.
+	suggestion := &Suggestion{Text: "Cap and cap", weight: 1}
+	root.insertWords(normalizeSearch(suggestion.Text), suggestion)
+
+	if got := suggestionTexts(root.lookup("c", 20)); !reflect.DeepEqual(got, []string{"Cap and cap"}) {
+		t.Errorf("lookup(\"c\") = %v, want the suggestion once", got)
+	}
+}
+
+func TestTrieKeepsOnlyTheBest(t *testing.T) {
+	root := newTrieNode()
+	for i := 0; i < maxNodeSuggestions+5; i++ {
+		root.insert(fmt.Sprintf("shirt %02d", i), &Suggestion{Text: fmt.Sprintf("shirt %02d", i), weight: 1, popularity: int64(i)})
+	}
+
+	got := root.lookup("shirt", 100)
+	if len(got) != maxNodeSuggestions {
+		t.Fatalf("got %d suggestions, want %d", len(got), maxNodeSuggestions)
+	}
+	if got[0].Text != fmt.Sprintf("shirt %02d", maxNodeSuggestions+4) || got[len(got)-1].Text != "shirt 05" {
+		t.Errorf("kept %v, want the most popular %d", suggestionTexts(got), maxNodeSuggestions)
+	}
+}
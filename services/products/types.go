@@ -38,6 +38,22 @@ type CreateProductPayload struct {
 	Description        string    `json:"description" validate:"required"`
 	DiscountPercentage float64   `json:"discount_percentage"`
 	CategoryID         uuid.UUID `json:"category_id" validate:"required"`
+
+	Variants []VariantPayload `json:"variants" validate:"omitempty,dive"`
+}
+
+type VariantPayload struct {
+	Size  string `json:"size" validate:"required,max=40"`
+	Color string `json:"color" validate:"required,max=40"`
+	SKU   string `json:"sku" validate:"max=64"`
+	Stock int    `json:"stock" validate:"min=0"`
+}
+
+type UpdateVariantPayload struct {
+	Size  *string `json:"size" validate:"omitempty,min=1,max=40"`
+	Color *string `json:"color" validate:"omitempty,min=1,max=40"`
+	SKU   *string `json:"sku" validate:"omitempty,max=64"`
+	Stock *int    `json:"stock" validate:"omitempty,min=0"`
 }
 
 type CategoryReturn struct {
@@ -51,3 +67,124 @@ type CategoryReturn struct {
 	UpdatedAt time.Time        `json:"-"`
 	DeletedAt *time.Time       `json:"-"`
 }
+
+// CreateReviewPayload is a customer's review. Photos are image URLs.
+type CreateReviewPayload struct {
+	Rating int      `json:"rating" validate:"required,min=1,max=5"`
+	Title  string   `json:"title" validate:"required,max=120"`
+	Body   string   `json:"body" validate:"required,max=5000"`
+	Photos []string `json:"photos" validate:"omitempty,max=5,dive,url"`
+}
+
+type RejectReviewPayload struct {
+	Reason string `json:"reason" validate:"max=500"`
+}
+
+type ReplyReviewPayload struct {
+	Reply string `json:"reply" validate:"required,max=2000"`
+}
+
+// ProductFilter narrows a product listing or search; see ParseProductFilter
+// for how it is written in a query string. An empty Query matches every
+// product; SortBy defaults to relevance when there is a query. Within a
+// list any value matches, and every filter given must match.
+type ProductFilter struct {
+	Query      string
+	Categories []string
+	Styles     []string
+	Sizes      []string
+	Colors     []string
+	MinPrice   float64
+	MaxPrice   float64
+	InStock    bool
+	OnSale     bool
+	Featured   bool
+	SortBy     string
+	Offset     int
+	Limit      int
+}
+
+// Search modes report how the results were matched.
+const (
+	SearchAll      = "all"
+	SearchFullText = "fulltext"
+	SearchFuzzy    = "fuzzy"
+)
+
+// SearchHit is a matching product with its relevance and the parts of its
+// title and description that matched, wrapped in <mark> tags.
+type SearchHit struct {
+	models.Product
+	Rank                 float64 `json:"rank,omitempty"`
+	TitleHighlight       string  `json:"title_highlight,omitempty"`
+	DescriptionHighlight string  `json:"description_highlight,omitempty"`
+}
+
+type Facet struct {
+	Value string `json:"value"`
+	Label string `json:"label"`
+	Count int64  `json:"count"`
+}
+
+// PriceFacet counts the products in a price bucket. The last bucket has no
+// upper bound.
+type PriceFacet struct {
+	Min   float64  `json:"min"`
+	Max   *float64 `json:"max"`
+	Count int64    `json:"count"`
+}
+
+// AvailabilityFacet counts the matches that are in stock, on sale and
+// featured.
+type AvailabilityFacet struct {
+	InStock  int64 `json:"in_stock"`
+	OnSale   int64 `json:"on_sale"`
+	Featured int64 `json:"featured"`
+}
+
+// SearchFacets count the matches for each filter option. Each facet
+// ignores its own filter, so picking a style still shows the other styles
+// on offer.
+type SearchFacets struct {
+	Categories   []Facet           `json:"categories"`
+	Styles       []Facet           `json:"styles"`
+	Sizes        []Facet           `json:"sizes"`
+	Colors       []Facet           `json:"colors"`
+	Prices       []PriceFacet      `json:"prices"`
+	Availability AvailabilityFacet `json:"availability"`
+}
+
+type SearchResult struct {
+	Hits   []SearchHit
+	Total  int64
+	Mode   string
+	Facets SearchFacets
+}
+
+// priceBuckets are the lower bounds of the price facet's buckets.
+var priceBuckets = []float64{0, 10000, 25000, 50000, 100000}
+
+type PopularSearch struct {
+	Term  string `json:"term"`
+	Count int64  `json:"count"`
+}
+
+// Suggestion kinds, in the order they are preferred when several start
+// with what was typed.
+const (
+	SuggestCategory = "category"
+	SuggestStyle    = "style"
+	SuggestQuery    = "query"
+	SuggestProduct  = "product"
+)
+
+// Suggestion completes what a shopper has typed into the search box. Slug
+// links categories and products to their pages.
+type Suggestion struct {
+	Text string `json:"text"`
+	Kind string `json:"kind"`
+	Slug string `json:"slug,omitempty"`
+
+	weight     int
+	popularity int64
+}
@@ -0,0 +1,139 @@
+package products
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/razdacoder/mcwale-api/models"
+	"github.com/razdacoder/mcwale-api/utils"
+)
+
+// handleCreateVariant adds a size and color to a product. Each combination
+// can only be added once.
+func (handler *Handler) handleCreateVariant(writer http.ResponseWriter, request *http.Request) {
+	product, err := handler.store.GetSingleProduct(chi.URLParam(request, "slug"))
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("product not found"))
+		return
+	}
+
+	var payload VariantPayload
+	if err := utils.ParseJSON(request, &payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	variant := &models.ProductVariant{
+		ProductID: product.ID,
+		Size:      strings.TrimSpace(payload.Size),
+		Color:     strings.TrimSpace(payload.Color),
+		SKU:       payload.SKU,
+		Stock:     payload.Stock,
+	}
+	exists, err := handler.store.VariantExists(product.ID, variant.Size, variant.Color, uuid.Nil)
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	if exists {
+		utils.WriteError(writer, http.StatusConflict, fmt.Errorf("this product already comes in %s %s", variant.Color, variant.Size))
+		return
+	}
+	if err := handler.store.CreateVariant(variant); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	handler.audit.Record(request, "product_variant.create", "product_variant", variant.ID.String(), nil, variant)
+
+	utils.WriteJSON(writer, http.StatusCreated, variant)
+}
+
+// handleUpdateVariant changes a variant's size, color, SKU or stock.
+func (handler *Handler) handleUpdateVariant(writer http.ResponseWriter, request *http.Request) {
+	product, err := handler.store.GetSingleProduct(chi.URLParam(request, "slug"))
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("product not found"))
+		return
+	}
+
+	var payload UpdateVariantPayload
+	if err := utils.ParseJSON(request, &payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	variant, err := handler.store.GetVariant(product.ID, chi.URLParam(request, "variantID"))
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("variant not found"))
+		return
+	}
+
+	before := *variant
+	if payload.Size != nil {
+		variant.Size = strings.TrimSpace(*payload.Size)
+	}
+	if payload.Color != nil {
+		variant.Color = strings.TrimSpace(*payload.Color)
+	}
+	if payload.SKU != nil {
+		variant.SKU = *payload.SKU
+	}
+	if payload.Stock != nil {
+		variant.Stock = *payload.Stock
+	}
+	if variant.Size != before.Size || variant.Color != before.Color {
+		exists, err := handler.store.VariantExists(product.ID, variant.Size, variant.Color, variant.ID)
+		if err != nil {
+			utils.WriteError(writer, http.StatusInternalServerError, err)
+			return
+		}
+		if exists {
+			utils.WriteError(writer, http.StatusConflict, fmt.Errorf("this product already comes in %s %s", variant.Color, variant.Size))
+			return
+		}
+	}
+	if err := handler.store.UpdateVariant(variant); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	handler.audit.Record(request, "product_variant.update", "product_variant", variant.ID.String(), before, variant)
+
+	utils.WriteJSON(writer, http.StatusOK, variant)
+}
+
+func (handler *Handler) handleDeleteVariant(writer http.ResponseWriter, request *http.Request) {
+	product, err := handler.store.GetSingleProduct(chi.URLParam(request, "slug"))
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("product not found"))
+		return
+	}
+	variant, err := handler.store.GetVariant(product.ID, chi.URLParam(request, "variantID"))
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("variant not found"))
+		return
+	}
+
+	if err := handler.store.DeleteVariant(variant); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	handler.audit.Record(request, "product_variant.delete", "product_variant", variant.ID.String(), variant, nil)
+
+	utils.WriteJSON(writer, http.StatusNoContent, map[string]string{"message": "Deleted Successfully"})
+}
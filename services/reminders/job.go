@@ -0,0 +1,167 @@
+package reminders
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/razdacoder/mcwale-api/mailer"
+	"github.com/razdacoder/mcwale-api/models"
+	"github.com/razdacoder/mcwale-api/services/appointments"
+	"github.com/razdacoder/mcwale-api/services/jobs"
+)
+
+const (
+	defaultOffsets = "48h,2h"
+	batchSize      = 50
+	maxAttempts    = 3
+)
+
+type Reminders struct {
+	store    ReminderStore
+	mailer   mailer.Mailer
+	schedule *appointments.Schedule
+	offsets  []time.Duration
+	interval time.Duration
+}
+
+// New configures appointment reminders from the environment:
+//
+//	REMINDER_OFFSETS   how long before a fitting to remind, defaults to "48h,2h"
+//	REMINDER_INTERVAL  how often to check for due reminders, defaults to 1m
+func New(store ReminderStore, mailer mailer.Mailer, schedule *appointments.Schedule) (*Reminders, error) {
+	spec := os.Getenv("REMINDER_OFFSETS")
+	if spec == "" {
+		spec = defaultOffsets
+	}
+	var offsets []time.Duration
+	for _, value := range strings.Split(spec, ",") {
+		offset, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || offset < time.Minute {
+			return nil, fmt.Errorf("invalid REMINDER_OFFSETS entry %q", value)
+		}
+		offsets = append(offsets, offset)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] > offsets[j] })
+
+	interval := time.Minute
+	if value := os.Getenv("REMINDER_INTERVAL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid REMINDER_INTERVAL %q", value)
+		}
+		interval = parsed
+	}
+
+	return &Reminders{
+		store:    store,
+		mailer:   mailer,
+		schedule: schedule,
+		offsets:  offsets,
+		interval: interval,
+	}, nil
+}
+
+func (reminders *Reminders) Job() jobs.Job {
+	return jobs.Job{
+		Name:     "appointment_reminders",
+		Interval: reminders.interval,
+		Run:      reminders.run,
+	}
+}
+
+func (reminders *Reminders) run(ctx context.Context) (jobs.Result, error) {
+	result := jobs.Result{}
+	now := time.Now()
+	for _, offset := range reminders.offsets {
+		if _, err := reminders.store.QueueReminders(offset, now); err != nil {
+			return result, err
+		}
+	}
+
+	// If the worker has fallen behind, several reminders for one appointment
+	// can be due at once; only the latest of them is sent.
+	reminded := map[uuid.UUID]bool{}
+	for ctx.Err() == nil {
+		handled, err := reminders.store.ProcessDue(now, batchSize, func(reminder *models.AppointmentReminder) {
+			if reminded[reminder.AppointmentID] {
+				reminder.Status = models.ReminderSkipped
+				return
+			}
+			status := reminders.deliver(reminder, now)
+			if status == models.ReminderSent {
+				reminded[reminder.AppointmentID] = true
+			}
+			switch status {
+			case models.ReminderSent:
+				result.Processed++
+			case models.ReminderFailed:
+				result.Failed++
+			}
+		})
+		if err != nil {
+			return result, err
+		}
+		if handled < batchSize {
+			break
+		}
+	}
+	return result, nil
+}
+
+// deliver sends a due reminder, or skips it if the appointment has since
+// been cancelled, moved or already started. Failed sends are retried on a
+// later pass, backing off, until maxAttempts is reached.
+func (reminders *Reminders) deliver(reminder *models.AppointmentReminder, now time.Time) models.ReminderStatus {
+	appointment := reminder.Appointment
+	open := appointment != nil &&
+		(appointment.Status == models.AppointmentRequested || appointment.Status == models.AppointmentConfirmed)
+	if !open || !appointment.Date.Equal(reminder.StartsAt) || !now.Before(appointment.Date) {
+		reminder.Status = models.ReminderSkipped
+		return reminder.Status
+	}
+
+	reminder.Attempts++
+	err := reminders.send(appointment)
+	if err != nil {
+		reminder.LastError = err.Error()
+		reminder.DueAt = now.Add(time.Duration(reminder.Attempts) * 5 * time.Minute)
+		if reminder.Attempts >= maxAttempts {
+			reminder.Status = models.ReminderFailed
+		}
+		return models.ReminderFailed
+	}
+
+	reminder.Status = models.ReminderSent
+	reminder.SentAt = &now
+	reminder.LastError = ""
+	return reminder.Status
+}
+
+func (reminders *Reminders) send(appointment *models.Appointment) error {
+	link, err := appointments.ManageLink(appointment)
+	if err != nil {
+		return err
+	}
+
+	what := "fitting"
+	if appointment.AppointmentType != nil {
+		what = appointment.AppointmentType.Name
+	}
+	with := ""
+	if appointment.Staff != nil {
+		with = " with " + appointment.Staff.DisplayName
+	}
+	when := reminders.schedule.Format(appointment.Date)
+
+	return reminders.mailer.Send(mailer.Message{
+		To:      appointment.Email,
+		Subject: "Reminder: your appointment on " + when,
+		Body: fmt.Sprintf("Hi %s,\n\nThis is a reminder of your %s%s on %s.\n\nIf you can no longer make it, please reschedule or cancel using the link below so we can offer the slot to someone else.\n\n%s",
+			appointment.FirstName, what, with, when, link),
+	})
+}
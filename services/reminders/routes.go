@@ -0,0 +1,51 @@
+package reminders
+
+import (
+	"math"
+	"net/http"
+	"os"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/razdacoder/mcwale-api/models"
+	"github.com/razdacoder/mcwale-api/services/auth"
+	"github.com/razdacoder/mcwale-api/utils"
+)
+
+type Handler struct {
+	store ReminderStore
+}
+
+func NewHandler(store ReminderStore) *Handler {
+	return &Handler{
+		store: store,
+	}
+}
+
+func (handler *Handler) RegisterRoutes(router chi.Router) {
+	router.With(auth.IsLoggedIn, auth.RequirePermission(models.PermJobsRead)).Get("/reminders", handler.handleGetReminders)
+}
+
+// handleGetReminders lists queued and past reminders, optionally filtered
+// by ?status=, along with how many there are in each status.
+func (handler *Handler) handleGetReminders(writer http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+	page := utils.ParseStringToInt(query.Get("page"), 0)
+	perPage := utils.ParseStringToInt(os.Getenv("PER_PAGE"), 10)
+	if page == 0 {
+		page = 1
+	}
+
+	reminders, total, err := handler.store.GetReminders(query.Get("status"), (page-1)*perPage, perPage)
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	counts, err := handler.store.CountByStatus()
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	pages := int(math.Ceil(float64(total) / float64(perPage)))
+	utils.WriteJSON(writer, http.StatusOK, map[string]any{"results": len(reminders), "page": page, "pages": pages, "total": total, "counts": counts, "data": reminders})
+}
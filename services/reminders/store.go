@@ -0,0 +1,108 @@
+package reminders
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/razdacoder/mcwale-api/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Store struct {
+	db *gorm.DB
+}
+
+func NewStore(db *gorm.DB) *Store {
+	return &Store{
+		db: db,
+	}
+}
+
+// QueueReminders adds a pending reminder for every open upcoming
+// appointment that is still more than offset away. Reminders that already
+// exist are left alone, so this is safe to run from several workers.
+func (store *Store) QueueReminders(offset time.Duration, now time.Time) (int64, error) {
+	minutes := int(offset.Minutes())
+	result := store.db.Exec(`INSERT INTO appointment_reminders (appointment_id, offset_minutes, starts_at, due_at, status, attempts, created_at, updated_at)
+		SELECT id, ?, date, date - make_interval(mins => ?), ?, 0, ?, ?
+		FROM appointments
+		WHERE status IN ? AND date - make_interval(mins => ?) > ?
+		ON CONFLICT (appointment_id, offset_minutes, starts_at) DO NOTHING`,
+		minutes, minutes, models.ReminderPending, now, now,
+		[]models.AppointmentStatus{models.AppointmentRequested, models.AppointmentConfirmed}, minutes, now)
+	return result.RowsAffected, result.Error
+}
+
+// ProcessDue locks up to limit reminders that have fallen due and passes
+// each to handle, latest due first, saving whatever state it leaves them
+// in. Rows locked by another worker are skipped rather than waited on, so
+// no reminder is handled twice.
+func (store *Store) ProcessDue(now time.Time, limit int, handle func(reminder *models.AppointmentReminder)) (int, error) {
+	count := 0
+	err := store.db.Transaction(func(tx *gorm.DB) error {
+		var due []models.AppointmentReminder
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND due_at <= ?", models.ReminderPending, now).
+			Order("due_at DESC").Limit(limit).Find(&due).Error
+		if err != nil || len(due) == 0 {
+			return err
+		}
+
+		ids := make([]uuid.UUID, 0, len(due))
+		for _, reminder := range due {
+			ids = append(ids, reminder.AppointmentID)
+		}
+		var appointments []models.Appointment
+		if err := tx.Preload("AppointmentType").Preload("Staff").Where("id IN ?", ids).Find(&appointments).Error; err != nil {
+			return err
+		}
+		byID := make(map[uuid.UUID]*models.Appointment, len(appointments))
+		for i := range appointments {
+			byID[appointments[i].ID] = &appointments[i]
+		}
+
+		for i := range due {
+			reminder := &due[i]
+			reminder.Appointment = byID[reminder.AppointmentID]
+			handle(reminder)
+			err := tx.Model(reminder).Select("status", "due_at", "attempts", "last_error", "sent_at").Updates(reminder).Error
+			if err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+func (store *Store) GetReminders(status string, offset, limit int) ([]models.AppointmentReminder, int64, error) {
+	var reminders []models.AppointmentReminder
+	var total int64
+	query := store.db.Model(&models.AppointmentReminder{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := query.Order("due_at DESC").Offset(offset).Limit(limit).Find(&reminders).Error
+	return reminders, total, err
+}
+
+func (store *Store) CountByStatus() (map[models.ReminderStatus]int64, error) {
+	var rows []struct {
+		Status models.ReminderStatus
+		Count  int64
+	}
+	err := store.db.Model(&models.AppointmentReminder{}).Select("status, count(*) AS count").Group("status").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	counts := map[models.ReminderStatus]int64{}
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, nil
+}
@@ -0,0 +1,14 @@
+package reminders
+
+import (
+	"time"
+
+	"github.com/razdacoder/mcwale-api/models"
+)
+
+type ReminderStore interface {
+	QueueReminders(offset time.Duration, now time.Time) (int64, error)
+	ProcessDue(now time.Time, limit int, handle func(reminder *models.AppointmentReminder)) (int, error)
+	GetReminders(status string, offset, limit int) ([]models.AppointmentReminder, int64, error)
+	CountByStatus() (map[models.ReminderStatus]int64, error)
+}
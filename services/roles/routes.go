@@ -0,0 +1,153 @@
+package roles
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/razdacoder/mcwale-api/models"
+	"github.com/razdacoder/mcwale-api/services/audit"
+	"github.com/razdacoder/mcwale-api/services/auth"
+	"github.com/razdacoder/mcwale-api/utils"
+)
+
+type Handler struct {
+	store RoleStore
+	audit audit.Recorder
+}
+
+func NewHandler(store RoleStore, recorder audit.Recorder) *Handler {
+	return &Handler{
+		store: store,
+		audit: recorder,
+	}
+}
+
+func rolesRouter(handler *Handler) chi.Router {
+	router := chi.NewRouter()
+	router.Use(auth.IsLoggedIn, auth.RequirePermission(models.PermRolesManage))
+
+	router.Get("/", handler.handleGetRoles)
+	router.Post("/", handler.handleCreateRole)
+	router.Get("/permissions", handler.handleGetPermissions)
+
+	router.Route("/{name}", func(router chi.Router) {
+		router.Get("/", handler.handleGetRole)
+		router.Patch("/", handler.handleUpdateRole)
+		router.Delete("/", handler.handleDeleteRole)
+	})
+
+	return router
+}
+
+func (handler *Handler) RegisterRoutes(router chi.Router) {
+	router.Mount("/roles", rolesRouter(handler))
+}
+
+func (handler *Handler) handleGetRoles(writer http.ResponseWriter, request *http.Request) {
+	roles, err := handler.store.GetRoles()
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, roles)
+}
+
+func (handler *Handler) handleGetPermissions(writer http.ResponseWriter, request *http.Request) {
+	utils.WriteJSON(writer, http.StatusOK, models.Permissions)
+}
+
+func (handler *Handler) handleCreateRole(writer http.ResponseWriter, request *http.Request) {
+	var payload CreateRolePayload
+	if err := utils.ParseJSON(request, &payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	if err := validatePermissions(payload.Permissions); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := handler.store.CreateRole(payload); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	handler.audit.Record(request, "role.create", "role", payload.Name, nil, payload)
+
+	utils.WriteJSON(writer, http.StatusCreated, map[string]string{"message": "Role Created"})
+}
+
+func (handler *Handler) handleGetRole(writer http.ResponseWriter, request *http.Request) {
+	name := chi.URLParam(request, "name")
+	role, err := handler.store.GetRole(models.UserRole(name))
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, err)
+		return
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, role)
+}
+
+func (handler *Handler) handleUpdateRole(writer http.ResponseWriter, request *http.Request) {
+	name := chi.URLParam(request, "name")
+
+	var payload UpdateRolePayload
+	if err := utils.ParseJSON(request, &payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := validatePermissions(payload.Permissions); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	before, err := handler.store.GetRole(models.UserRole(name))
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, err)
+		return
+	}
+
+	if err := handler.store.UpdateRole(models.UserRole(name), payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	after, err := handler.store.GetRole(models.UserRole(name))
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	handler.audit.Record(request, "role.update", "role", name, before, after)
+
+	utils.WriteJSON(writer, http.StatusOK, map[string]string{"message": "Updated Successfully"})
+}
+
+func (handler *Handler) handleDeleteRole(writer http.ResponseWriter, request *http.Request) {
+	name := chi.URLParam(request, "name")
+	if err := handler.store.DeleteRole(models.UserRole(name)); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+	handler.audit.Record(request, "role.delete", "role", name, nil, nil)
+
+	utils.WriteJSON(writer, http.StatusNoContent, nil)
+}
+
+func validatePermissions(permissions []string) error {
+	for _, permission := range permissions {
+		if !models.IsValidPermission(permission) {
+			return fmt.Errorf("unknown permission %s", permission)
+		}
+	}
+	return nil
+}
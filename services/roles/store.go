@@ -0,0 +1,75 @@
+package roles
+
+import (
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/razdacoder/mcwale-api/models"
+	"gorm.io/gorm"
+)
+
+type Store struct {
+	db *gorm.DB
+}
+
+func NewStore(db *gorm.DB) *Store {
+	return &Store{
+		db: db,
+	}
+}
+
+func (store *Store) GetRoles() ([]models.Role, error) {
+	var roles []models.Role
+	result := store.db.Order("name ASC").Find(&roles)
+	return roles, result.Error
+}
+
+func (store *Store) GetRole(name models.UserRole) (*models.Role, error) {
+	var role models.Role
+	result := store.db.Model(&models.Role{}).Where("name = ?", name).First(&role)
+	return &role, result.Error
+}
+
+func (store *Store) CreateRole(payload CreateRolePayload) error {
+	role := &models.Role{
+		Name:        models.UserRole(payload.Name),
+		Description: payload.Description,
+		Permissions: payload.Permissions,
+	}
+	result := store.db.Create(role)
+	return result.Error
+}
+
+func (store *Store) UpdateRole(name models.UserRole, payload UpdateRolePayload) error {
+	role, err := store.GetRole(name)
+	if err != nil {
+		return err
+	}
+	updates := map[string]interface{}{}
+	if payload.Description != nil {
+		updates["description"] = *payload.Description
+	}
+	if payload.Permissions != nil {
+		updates["permissions"] = pq.StringArray(payload.Permissions)
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	results := store.db.Model(role).Updates(updates)
+	return results.Error
+}
+
+func (store *Store) DeleteRole(name models.UserRole) error {
+	if name == models.Admin || name == models.Customer {
+		return fmt.Errorf("role %s cannot be deleted", name)
+	}
+	var count int64
+	if err := store.db.Model(&models.User{}).Where("user_role = ?", name).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("role %s is still assigned to %d users", name, count)
+	}
+	results := store.db.Where("name = ?", name).Delete(&models.Role{})
+	return results.Error
+}
@@ -0,0 +1,22 @@
+package roles
+
+import "github.com/razdacoder/mcwale-api/models"
+
+type RoleStore interface {
+	GetRoles() ([]models.Role, error)
+	GetRole(name models.UserRole) (*models.Role, error)
+	CreateRole(payload CreateRolePayload) error
+	UpdateRole(name models.UserRole, payload UpdateRolePayload) error
+	DeleteRole(name models.UserRole) error
+}
+
+type CreateRolePayload struct {
+	Name        string   `json:"name" validate:"required"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions" validate:"required"`
+}
+
+type UpdateRolePayload struct {
+	Description *string  `json:"description"`
+	Permissions []string `json:"permissions"`
+}
@@ -0,0 +1,387 @@
+package staff
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/razdacoder/mcwale-api/mailer"
+	"github.com/razdacoder/mcwale-api/models"
+	"github.com/razdacoder/mcwale-api/services/audit"
+	"github.com/razdacoder/mcwale-api/services/auth"
+	"github.com/razdacoder/mcwale-api/utils"
+)
+
+type Handler struct {
+	store StaffStore
+	audit audit.Recorder
+}
+
+func NewHandler(store StaffStore, recorder audit.Recorder) *Handler {
+	return &Handler{
+		store: store,
+		audit: recorder,
+	}
+}
+
+func staffRouter(handler *Handler) chi.Router {
+	router := chi.NewRouter()
+	manage := auth.RequirePermission(models.PermStaffManage)
+
+	router.With(auth.OptionalLogin).Get("/", handler.handleGetStaff)
+	router.With(auth.IsLoggedIn, manage).Post("/", handler.handleCreateStaff)
+	router.With(auth.IsLoggedIn).Get("/me/appointments", handler.handleGetMyAppointments)
+	router.With(auth.IsLoggedIn).Post("/me/calendar-feed", handler.handleRotateMyCalendarFeed)
+
+	router.Route("/{id}", func(router chi.Router) {
+		router.Get("/", handler.handleGetStaffMember)
+
+		router.Group(func(router chi.Router) {
+			router.Use(auth.IsLoggedIn, manage)
+			router.Patch("/", handler.handleUpdateStaff)
+			router.Delete("/", handler.handleDeactivateStaff)
+			router.Put("/hours", handler.handleSetWorkingHours)
+			router.Get("/time-off", handler.handleGetTimeOff)
+			router.Post("/time-off", handler.handleCreateTimeOff)
+			router.Delete("/time-off/{timeOffID}", handler.handleDeleteTimeOff)
+			router.Post("/calendar-feed", handler.handleRotateCalendarFeed)
+		})
+	})
+
+	return router
+}
+
+func (handler *Handler) RegisterRoutes(router chi.Router) {
+	router.Mount("/staff", staffRouter(handler))
+}
+
+// handleGetStaff lists who customers can book with. Staff managers may add
+// ?include_inactive=true to see everyone.
+func (handler *Handler) handleGetStaff(writer http.ResponseWriter, request *http.Request) {
+	includeInactive := false
+	if request.URL.Query().Get("include_inactive") == "true" {
+		principal, ok := auth.PrincipalFromContext(request.Context())
+		if ok {
+			includeInactive, _ = principal.Can(models.PermStaffManage)
+		}
+	}
+
+	staff, err := handler.store.GetStaff(includeInactive)
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, staff)
+}
+
+func (handler *Handler) handleGetStaffMember(writer http.ResponseWriter, request *http.Request) {
+	member, err := handler.store.GetStaffByID(chi.URLParam(request, "id"))
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("staff member not found"))
+		return
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, member)
+}
+
+func (handler *Handler) handleCreateStaff(writer http.ResponseWriter, request *http.Request) {
+	var payload CreateStaffPayload
+	if err := utils.ParseJSON(request, &payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	if err := handler.checkUser(payload.UserID); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	member := &models.StaffMember{
+		UserID:      payload.UserID,
+		DisplayName: payload.DisplayName,
+		Bio:         payload.Bio,
+		IsActive:    true,
+	}
+	if err := handler.store.CreateStaff(member); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	handler.audit.Record(request, "staff.create", "staff", member.ID.String(), nil, member)
+
+	utils.WriteJSON(writer, http.StatusCreated, member)
+}
+
+func (handler *Handler) handleUpdateStaff(writer http.ResponseWriter, request *http.Request) {
+	var payload UpdateStaffPayload
+	if err := utils.ParseJSON(request, &payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	member, err := handler.store.GetStaffByID(chi.URLParam(request, "id"))
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("staff member not found"))
+		return
+	}
+	before := *member
+
+	if payload.UserID != nil {
+		if err := handler.checkUser(payload.UserID); err != nil {
+			utils.WriteError(writer, http.StatusBadRequest, err)
+			return
+		}
+		member.UserID = payload.UserID
+	}
+	if payload.DisplayName != nil {
+		member.DisplayName = *payload.DisplayName
+	}
+	if payload.Bio != nil {
+		member.Bio = *payload.Bio
+	}
+	if payload.IsActive != nil {
+		member.IsActive = *payload.IsActive
+	}
+
+	if err := handler.store.UpdateStaff(member); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	handler.audit.Record(request, "staff.update", "staff", member.ID.String(), before, member)
+
+	utils.WriteJSON(writer, http.StatusOK, member)
+}
+
+// handleDeactivateStaff stops a staff member taking new bookings. They are
+// kept so existing appointments still say who they are with.
+func (handler *Handler) handleDeactivateStaff(writer http.ResponseWriter, request *http.Request) {
+	member, err := handler.store.GetStaffByID(chi.URLParam(request, "id"))
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("staff member not found"))
+		return
+	}
+
+	member.IsActive = false
+	if err := handler.store.UpdateStaff(member); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	handler.audit.Record(request, "staff.deactivate", "staff", member.ID.String(), nil, nil)
+
+	utils.WriteJSON(writer, http.StatusNoContent, nil)
+}
+
+// handleSetWorkingHours replaces a staff member's weekly hours. An empty
+// list puts them back on the shop's business hours.
+func (handler *Handler) handleSetWorkingHours(writer http.ResponseWriter, request *http.Request) {
+	var payload WorkingHoursPayload
+	if err := utils.ParseJSON(request, &payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	member, err := handler.store.GetStaffByID(chi.URLParam(request, "id"))
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("staff member not found"))
+		return
+	}
+
+	hours := make([]models.StaffWorkingHours, 0, len(payload.Hours))
+	for _, entry := range payload.Hours {
+		opens, _ := time.Parse("15:04", entry.Opens)
+		closes, _ := time.Parse("15:04", entry.Closes)
+		if !closes.After(opens) {
+			utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("%s-%s closes before it opens", entry.Opens, entry.Closes))
+			return
+		}
+		hours = append(hours, models.StaffWorkingHours{
+			StaffMemberID: member.ID,
+			Weekday:       entry.Weekday,
+			Opens:         opens.Format("15:04"),
+			Closes:        closes.Format("15:04"),
+		})
+	}
+
+	if err := handler.store.ReplaceWorkingHours(member.ID, hours); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	handler.audit.Record(request, "staff.set_hours", "staff", member.ID.String(),
+		map[string]any{"hours": member.WorkingHours}, map[string]any{"hours": hours})
+
+	utils.WriteJSON(writer, http.StatusOK, hours)
+}
+
+func (handler *Handler) handleGetTimeOff(writer http.ResponseWriter, request *http.Request) {
+	member, err := handler.store.GetStaffByID(chi.URLParam(request, "id"))
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("staff member not found"))
+		return
+	}
+
+	timeOff, err := handler.store.GetTimeOff(member.ID, time.Now())
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, timeOff)
+}
+
+func (handler *Handler) handleCreateTimeOff(writer http.ResponseWriter, request *http.Request) {
+	var payload CreateTimeOffPayload
+	if err := utils.ParseJSON(request, &payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	member, err := handler.store.GetStaffByID(chi.URLParam(request, "id"))
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("staff member not found"))
+		return
+	}
+
+	timeOff := &models.StaffTimeOff{
+		StaffMemberID: member.ID,
+		StartsAt:      payload.StartsAt,
+		EndsAt:        payload.EndsAt,
+		Reason:        payload.Reason,
+	}
+	if err := handler.store.CreateTimeOff(timeOff); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	handler.audit.Record(request, "staff.time_off.create", "staff_time_off", timeOff.ID.String(), nil, timeOff)
+
+	utils.WriteJSON(writer, http.StatusCreated, timeOff)
+}
+
+func (handler *Handler) handleDeleteTimeOff(writer http.ResponseWriter, request *http.Request) {
+	member, err := handler.store.GetStaffByID(chi.URLParam(request, "id"))
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("staff member not found"))
+		return
+	}
+
+	id := chi.URLParam(request, "timeOffID")
+	if err := handler.store.DeleteTimeOff(member.ID, id); err != nil {
+		utils.WriteError(writer, http.StatusNotFound, err)
+		return
+	}
+	handler.audit.Record(request, "staff.time_off.delete", "staff_time_off", id, nil, nil)
+
+	utils.WriteJSON(writer, http.StatusNoContent, nil)
+}
+
+// handleGetMyAppointments lists the signed in staff member's appointments
+// that have not yet finished.
+func (handler *Handler) handleGetMyAppointments(writer http.ResponseWriter, request *http.Request) {
+	principal, _ := auth.PrincipalFromContext(request.Context())
+	if principal.IsAPIKey() {
+		utils.WriteError(writer, http.StatusForbidden, fmt.Errorf("forbidden"))
+		return
+	}
+
+	member, err := handler.store.GetStaffByUserID(principal.UserID)
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("you are not set up as a staff member"))
+		return
+	}
+
+	appointments, err := handler.store.GetUpcomingAppointments(member.ID, time.Now())
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, appointments)
+}
+
+// handleRotateCalendarFeed issues a new calendar subscription URL for a
+// staff member, which stops the previous one working.
+func (handler *Handler) handleRotateCalendarFeed(writer http.ResponseWriter, request *http.Request) {
+	member, err := handler.store.GetStaffByID(chi.URLParam(request, "id"))
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("staff member not found"))
+		return
+	}
+
+	handler.rotateCalendarFeed(writer, request, member)
+}
+
+func (handler *Handler) handleRotateMyCalendarFeed(writer http.ResponseWriter, request *http.Request) {
+	principal, _ := auth.PrincipalFromContext(request.Context())
+	if principal.IsAPIKey() {
+		utils.WriteError(writer, http.StatusForbidden, fmt.Errorf("forbidden"))
+		return
+	}
+
+	member, err := handler.store.GetStaffByUserID(principal.UserID)
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("you are not set up as a staff member"))
+		return
+	}
+
+	handler.rotateCalendarFeed(writer, request, member)
+}
+
+// rotateCalendarFeed stores the hash of a new feed token and returns the
+// subscription URL. The URL cannot be shown again, only replaced.
+func (handler *Handler) rotateCalendarFeed(writer http.ResponseWriter, request *http.Request, member *models.StaffMember) {
+	token, err := auth.GenerateToken()
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := handler.store.SetCalendarTokenHash(member.ID, auth.HashToken(token)); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	handler.audit.Record(request, "staff.calendar_feed.rotate", "staff", member.ID.String(), nil, nil)
+
+	utils.WriteJSON(writer, http.StatusOK, map[string]string{
+		"url": mailer.APILink("/api/v1/appointments/feeds/" + token + ".ics"),
+	})
+}
+
+func (handler *Handler) checkUser(userID *uuid.UUID) error {
+	if userID == nil {
+		return nil
+	}
+	exists, err := handler.store.UserExists(*userID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("user not found")
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package staff
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/razdacoder/mcwale-api/models"
+	"gorm.io/gorm"
+)
+
+type Store struct {
+	db *gorm.DB
+}
+
+func NewStore(db *gorm.DB) *Store {
+	return &Store{
+		db: db,
+	}
+}
+
+func (store *Store) GetStaff(includeInactive bool) ([]models.StaffMember, error) {
+	var staff []models.StaffMember
+	query := store.db.Order("display_name")
+	if !includeInactive {
+		query = query.Where("is_active = ?", true)
+	}
+	err := query.Find(&staff).Error
+	return staff, err
+}
+
+func (store *Store) GetStaffByID(id string) (*models.StaffMember, error) {
+	var member models.StaffMember
+	err := store.db.Preload("WorkingHours", func(db *gorm.DB) *gorm.DB {
+		return db.Order("weekday, opens")
+	}).Where("id = ?", id).First(&member).Error
+	if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+func (store *Store) GetStaffByUserID(userID uuid.UUID) (*models.StaffMember, error) {
+	var member models.StaffMember
+	if err := store.db.Where("user_id = ?", userID).First(&member).Error; err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+func (store *Store) CreateStaff(member *models.StaffMember) error {
+	return store.db.Create(member).Error
+}
+
+func (store *Store) UpdateStaff(member *models.StaffMember) error {
+	return store.db.Model(member).Select("user_id", "display_name", "bio", "is_active").Updates(member).Error
+}
+
+func (store *Store) SetCalendarTokenHash(staffID uuid.UUID, hash string) error {
+	return store.db.Model(&models.StaffMember{}).Where("id = ?", staffID).Update("calendar_token_hash", hash).Error
+}
+
+func (store *Store) ReplaceWorkingHours(staffID uuid.UUID, hours []models.StaffWorkingHours) error {
+	return store.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("staff_member_id = ?", staffID).Delete(&models.StaffWorkingHours{}).Error; err != nil {
+			return err
+		}
+		if len(hours) == 0 {
+			return nil
+		}
+		return tx.Create(&hours).Error
+	})
+}
+
+func (store *Store) GetTimeOff(staffID uuid.UUID, from time.Time) ([]models.StaffTimeOff, error) {
+	var timeOff []models.StaffTimeOff
+	err := store.db.Where("staff_member_id = ? AND ends_at > ?", staffID, from).Order("starts_at").Find(&timeOff).Error
+	return timeOff, err
+}
+
+func (store *Store) CreateTimeOff(timeOff *models.StaffTimeOff) error {
+	return store.db.Create(timeOff).Error
+}
+
+func (store *Store) DeleteTimeOff(staffID uuid.UUID, id string) error {
+	result := store.db.Where("staff_member_id = ? AND id = ?", staffID, id).Delete(&models.StaffTimeOff{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("time off not found")
+	}
+	return nil
+}
+
+func (store *Store) GetUpcomingAppointments(staffID uuid.UUID, from time.Time) ([]models.Appointment, error) {
+	var appointments []models.Appointment
+	err := store.db.Where("staff_id = ? AND ends_at > ? AND status <> ?", staffID, from, models.AppointmentCancelled).Order("date").Find(&appointments).Error
+	return appointments, err
+}
+
+func (store *Store) UserExists(id uuid.UUID) (bool, error) {
+	var count int64
+	err := store.db.Model(&models.User{}).Where("id = ?", id).Count(&count).Error
+	return count > 0, err
+}
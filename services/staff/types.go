@@ -0,0 +1,54 @@
+package staff
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/razdacoder/mcwale-api/models"
+)
+
+type StaffStore interface {
+	GetStaff(includeInactive bool) ([]models.StaffMember, error)
+	GetStaffByID(id string) (*models.StaffMember, error)
+	GetStaffByUserID(userID uuid.UUID) (*models.StaffMember, error)
+	CreateStaff(member *models.StaffMember) error
+	UpdateStaff(member *models.StaffMember) error
+	SetCalendarTokenHash(staffID uuid.UUID, hash string) error
+	ReplaceWorkingHours(staffID uuid.UUID, hours []models.StaffWorkingHours) error
+	GetTimeOff(staffID uuid.UUID, from time.Time) ([]models.StaffTimeOff, error)
+	CreateTimeOff(timeOff *models.StaffTimeOff) error
+	DeleteTimeOff(staffID uuid.UUID, id string) error
+	GetUpcomingAppointments(staffID uuid.UUID, from time.Time) ([]models.Appointment, error)
+	UserExists(id uuid.UUID) (bool, error)
+}
+
+type CreateStaffPayload struct {
+	UserID      *uuid.UUID `json:"user_id"`
+	DisplayName string     `json:"display_name" validate:"required"`
+	Bio         string     `json:"bio"`
+}
+
+type UpdateStaffPayload struct {
+	UserID      *uuid.UUID `json:"user_id"`
+	DisplayName *string    `json:"display_name" validate:"omitempty,min=1"`
+	Bio         *string    `json:"bio"`
+	IsActive    *bool      `json:"is_active"`
+}
+
+type WorkingHoursPayload struct {
+	Hours []WorkingHoursEntry `json:"hours" validate:"dive"`
+}
+
+// WorkingHoursEntry gives opening and closing times as "15:04" in the
+// shop's timezone.
+type WorkingHoursEntry struct {
+	Weekday time.Weekday `json:"weekday" validate:"min=0,max=6"`
+	Opens   string       `json:"opens" validate:"required,datetime=15:04"`
+	Closes  string       `json:"closes" validate:"required,datetime=15:04"`
+}
+
+type CreateTimeOffPayload struct {
+	StartsAt time.Time `json:"starts_at" validate:"required"`
+	EndsAt   time.Time `json:"ends_at" validate:"required,gtfield=StartsAt"`
+	Reason   string    `json:"reason"`
+}
@@ -0,0 +1,81 @@
+package trash
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/razdacoder/mcwale-api/services/audit"
+	"github.com/razdacoder/mcwale-api/services/auth"
+	"github.com/razdacoder/mcwale-api/utils"
+)
+
+type Handler struct {
+	store TrashStore
+	audit audit.Recorder
+}
+
+func NewHandler(store TrashStore, recorder audit.Recorder) *Handler {
+	return &Handler{
+		store: store,
+		audit: recorder,
+	}
+}
+
+func trashRouter(handler *Handler) chi.Router {
+	router := chi.NewRouter()
+	router.Use(auth.IsLoggedIn)
+
+	router.Route("/{kind}", func(router chi.Router) {
+		router.Use(requireKindPermission)
+		router.Get("/", handler.handleGetDeleted)
+		router.Post("/{id}/restore", handler.handleRestore)
+	})
+
+	return router
+}
+
+func (handler *Handler) RegisterRoutes(router chi.Router) {
+	router.Mount("/trash", trashRouter(handler))
+}
+
+func requireKindPermission(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		permission, ok := permissions[chi.URLParam(request, "kind")]
+		if !ok {
+			utils.WriteError(writer, http.StatusNotFound, fmt.Errorf("unknown trash type"))
+			return
+		}
+		auth.RequirePermission(permission)(next).ServeHTTP(writer, request)
+	})
+}
+
+func (handler *Handler) handleGetDeleted(writer http.ResponseWriter, request *http.Request) {
+	page := utils.ParseStringToInt(request.URL.Query().Get("page"), 0)
+	perPage := utils.ParseStringToInt(os.Getenv("PER_PAGE"), 10)
+	if page == 0 {
+		page = 1
+	}
+	offset := (page - 1) * perPage
+
+	items, err := handler.store.GetDeleted(chi.URLParam(request, "kind"), offset, perPage)
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, map[string]any{"page": page, "data": items})
+}
+
+func (handler *Handler) handleRestore(writer http.ResponseWriter, request *http.Request) {
+	kind, id := chi.URLParam(request, "kind"), chi.URLParam(request, "id")
+	err := handler.store.Restore(kind, id)
+	if err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+	handler.audit.Record(request, kind+".restore", kind, id, nil, nil)
+
+	utils.WriteJSON(writer, http.StatusOK, map[string]string{"message": "Restored Successfully"})
+}
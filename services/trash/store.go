@@ -0,0 +1,146 @@
+package trash
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/razdacoder/mcwale-api/db"
+	"github.com/razdacoder/mcwale-api/models"
+	"gorm.io/gorm"
+)
+
+type Store struct {
+	db *gorm.DB
+}
+
+func NewStore(db *gorm.DB) *Store {
+	return &Store{
+		db: db,
+	}
+}
+
+func listDeleted[T any](db *gorm.DB, offset, limit int, deletedAt func(T) gorm.DeletedAt) ([]TrashItem[T], error) {
+	var rows []T
+	result := db.Unscoped().Where("deleted_at IS NOT NULL").Order("deleted_at DESC").Offset(offset).Limit(limit).Find(&rows)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	items := make([]TrashItem[T], 0, len(rows))
+	for _, row := range rows {
+		items = append(items, TrashItem[T]{Item: row, DeletedAt: deletedAt(row).Time})
+	}
+	return items, nil
+}
+
+func (store *Store) GetDeleted(kind string, offset, limit int) (any, error) {
+	switch kind {
+	case "users":
+		return listDeleted(store.db, offset, limit, func(user models.User) gorm.DeletedAt { return user.DeletedAt })
+	case "products":
+		return listDeleted(store.db.Preload("Category", db.Unscoped), offset, limit, func(product models.Product) gorm.DeletedAt { return product.DeletedAt })
+	case "categories":
+		return listDeleted(store.db, offset, limit, func(category models.Category) gorm.DeletedAt { return category.DeletedAt })
+	case "orders":
+		return listDeleted(store.db, offset, limit, func(order models.Order) gorm.DeletedAt { return order.DeletedAt })
+	}
+	return nil, fmt.Errorf("unknown trash type %s", kind)
+}
+
+func (store *Store) Restore(kind, id string) error {
+	switch kind {
+	case "users":
+		return restore(store.db, &models.User{}, id)
+	case "orders":
+		return restore(store.db, &models.Order{}, id)
+	case "products":
+		var product models.Product
+		if err := store.db.Unscoped().Where("id = ?", id).First(&product).Error; err != nil {
+			return err
+		}
+		var count int64
+		if err := store.db.Model(&models.Category{}).Where("id = ?", product.CategoryID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return fmt.Errorf("restore the product's category first")
+		}
+		return restore(store.db, &models.Product{}, id)
+	case "categories":
+		// Products deleted along with the category share its deletion time
+		// and come back with it.
+		return store.db.Transaction(func(tx *gorm.DB) error {
+			var category models.Category
+			if err := tx.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id).First(&category).Error; err != nil {
+				return err
+			}
+			err := tx.Unscoped().Model(&models.Product{}).
+				Where("category_id = ? AND deleted_at = ?", category.ID, category.DeletedAt.Time).
+				Update("deleted_at", nil).Error
+			if err != nil {
+				return err
+			}
+			return restore(tx, &models.Category{}, id)
+		})
+	}
+	return fmt.Errorf("unknown trash type %s", kind)
+}
+
+func restore(db *gorm.DB, model any, id string) error {
+	result := db.Unscoped().Model(model).Where("id = ? AND deleted_at IS NOT NULL", id).Update("deleted_at", nil)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("no deleted record with id %s", id)
+	}
+	return nil
+}
+
+// Purge permanently removes products, categories and orders deleted before
+// the cutoff. Products still referenced by orders, and categories that still
+// hold products, are kept so order history stays intact.
+func (store *Store) Purge(before time.Time) (int64, error) {
+	var purged int64
+	err := store.db.Transaction(func(tx *gorm.DB) error {
+		deletedOrders := tx.Unscoped().Model(&models.Order{}).Select("id").Where("deleted_at < ?", before)
+		if err := tx.Where("order_id IN (?)", deletedOrders).Delete(&models.OrderItem{}).Error; err != nil {
+			return err
+		}
+		result := tx.Unscoped().Where("deleted_at < ?", before).Delete(&models.Order{})
+		if result.Error != nil {
+			return result.Error
+		}
+		purged += result.RowsAffected
+
+		result = tx.Unscoped().
+			Where("deleted_at < ?", before).
+			Where("NOT EXISTS (SELECT 1 FROM order_items WHERE order_items.product_id = products.id)").
+			Delete(&models.Product{})
+		if result.Error != nil {
+			return result.Error
+		}
+		purged += result.RowsAffected
+
+		result = tx.Unscoped().
+			Where("deleted_at < ?", before).
+			Where("NOT EXISTS (SELECT 1 FROM products WHERE products.category_id = categories.id)").
+			Delete(&models.Category{})
+		if result.Error != nil {
+			return result.Error
+		}
+		purged += result.RowsAffected
+
+		return nil
+	})
+	return purged, err
+}
+
+// GetPurgeableUserIDs returns users deleted before the cutoff. They are
+// erased through users.Store.EraseUser so their orders are anonymised.
+func (store *Store) GetPurgeableUserIDs(before time.Time) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	result := store.db.Unscoped().Model(&models.User{}).Where("deleted_at < ?", before).Pluck("id", &ids)
+	return ids, result.Error
+}
@@ -0,0 +1,26 @@
+package trash
+
+import (
+	"time"
+
+	"github.com/razdacoder/mcwale-api/models"
+)
+
+type TrashStore interface {
+	GetDeleted(kind string, offset, limit int) (any, error)
+	Restore(kind, id string) error
+}
+
+// permissions maps each kind of trashed record to the permission needed to
+// list and restore it.
+var permissions = map[string]string{
+	"users":      models.PermUsersDelete,
+	"products":   models.PermProductsDelete,
+	"categories": models.PermCategoriesDelete,
+	"orders":     models.PermOrdersDelete,
+}
+
+type TrashItem[T any] struct {
+	Item      T         `json:"item"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
@@ -1,25 +1,41 @@
 package users
 
 import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"log"
+	"math"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/go-playground/validator"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
 
+	"github.com/razdacoder/mcwale-api/mailer"
 	"github.com/razdacoder/mcwale-api/models"
+	"github.com/razdacoder/mcwale-api/services/audit"
 	"github.com/razdacoder/mcwale-api/services/auth"
 	"github.com/razdacoder/mcwale-api/utils"
 )
 
+const purposeEmailChange = "email_change"
+
 type Handler struct {
-	store UserStore
+	store  UserStore
+	mailer mailer.Mailer
+	audit  audit.Recorder
 }
 
-func NewHandler(store UserStore) *Handler {
+func NewHandler(store UserStore, mailer mailer.Mailer, recorder audit.Recorder) *Handler {
 	return &Handler{
-		store: store,
+		store:  store,
+		mailer: mailer,
+		audit:  recorder,
 	}
 }
 
@@ -29,32 +45,44 @@ func (handler *Handler) RegisterRoutes(router chi.Router) {
 	router.Post("/verify", handler.handleVerifyToken)
 	router.Post("/reset-password", handler.handleResetPassword)
 	router.Post("/reset-password/{token}", handler.handleResetPasswordConfirm)
+	router.Post("/verify-email/{token}", handler.handleConfirmEmailChange)
 
-	router.Get("/users", handler.handleGetAllUsers)
+	router.With(auth.IsLoggedIn, auth.RequirePermission(models.PermUsersRead)).Get("/users", handler.handleGetAllUsers)
 	router.Route("/users/me", func(router chi.Router) {
 		router.Use(auth.IsLoggedIn)
 		router.Get("/", handler.handleGetCurrentUser)
+		router.Post("/email", handler.handleRequestEmailChange)
+		router.Post("/email/verify", handler.handleResendVerification)
+		router.Post("/password", handler.handleChangePassword)
+		router.Get("/export", handler.handleExportData)
+		router.Post("/deletion", handler.handleRequestDeletion)
+		router.Delete("/deletion", handler.handleCancelDeletion)
+		router.Route("/addresses", func(router chi.Router) {
+			router.Get("/", handler.handleGetAddresses)
+			router.Post("/", handler.handleCreateAddress)
+			router.Get("/{addressID}", handler.handleGetAddress)
+			router.Put("/{addressID}", handler.handleUpdateAddress)
+			router.Delete("/{addressID}", handler.handleDeleteAddress)
+		})
 	})
 	router.Route("/users/{id}", func(router chi.Router) {
 		router.Use(auth.IsLoggedIn)
-		router.Use(auth.IsAdminOrCurrentUser)
-		router.Get("/", handler.handleGetSingleUser)
-		router.Put("/", handler.handleUpdateUser)
-		router.Delete("/", handler.handleUserDelete)
+		router.With(auth.IsCurrentUserOr(models.PermUsersRead)).Get("/", handler.handleGetSingleUser)
+		router.With(auth.IsCurrentUserOr(models.PermUsersWrite)).Put("/", handler.handleUpdateUser)
+		router.With(auth.IsCurrentUserOr(models.PermUsersWrite)).Patch("/", handler.handleUpdateUser)
+		router.With(auth.IsCurrentUserOr(models.PermUsersDelete)).Delete("/", handler.handleUserDelete)
+		router.With(auth.RequirePermission(models.PermRolesManage)).Put("/role", handler.handleAssignRole)
 	})
 }
 
 func (handler *Handler) handleVerifyToken(writer http.ResponseWriter, request *http.Request) {
-	tokenString := request.Header.Get("Authorization")
-	if tokenString == "" {
+	tokenString, ok := auth.BearerToken(request)
+	if !ok {
 		utils.WriteError(writer, http.StatusUnauthorized, fmt.Errorf("Unauthorized"))
 		return
 	}
 
-	tokenString = tokenString[len("Bearer "):]
-	_, err := auth.VerifyUserToken(tokenString)
-	if err != nil {
-
+	if _, err := auth.PrincipalFromToken(tokenString); err != nil {
 		utils.WriteError(writer, http.StatusUnauthorized, fmt.Errorf("Unauthorized"))
 		return
 	}
@@ -81,14 +109,21 @@ func (handler *Handler) handleResetPassword(writer http.ResponseWriter, request
 		return
 	}
 
-	token, err := auth.CreateResetPasswordJWT([]byte(os.Getenv("JWT_SECRET")), user.ID)
+	token, err := auth.CreateResetPasswordJWT(user.ID)
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	err = handler.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body:    fmt.Sprintf("Use the link below to choose a new password. It expires in 10 minutes.\n\n%s", mailer.Link("/reset-password/"+token)),
+	})
 	if err != nil {
 		utils.WriteError(writer, http.StatusInternalServerError, err)
 		return
 	}
-	fmt.Println(token)
 
-	// Send Email to User
 	utils.WriteJSON(writer, http.StatusOK, map[string]string{"message": "reset password email sent"})
 }
 
@@ -127,6 +162,7 @@ func (handler *Handler) handleResetPasswordConfirm(writer http.ResponseWriter, r
 		utils.WriteError(writer, http.StatusBadRequest, err)
 		return
 	}
+	handler.audit.Record(request, "user.reset_password", "user", userId, nil, nil)
 
 	utils.WriteJSON(writer, http.StatusOK, map[string]string{"message": "password reset successful"})
 }
@@ -155,12 +191,17 @@ func (handler *Handler) handleLogin(writer http.ResponseWriter, request *http.Re
 		return
 	}
 
-	token, err := auth.CreateJWT([]byte(os.Getenv("JWT_SECRET")), user.ID, string(*user.UserRole))
+	token, err := auth.CreateJWT(user.ID, string(*user.UserRole))
 	if err != nil {
 		utils.WriteError(writer, http.StatusInternalServerError, err)
 		return
 	}
 
+	if err := handler.store.RecordLogin(user.ID); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
 	utils.WriteJSON(writer, http.StatusOK, map[string]string{"token": token})
 }
 
@@ -189,7 +230,7 @@ func (handler *Handler) handleRegister(writer http.ResponseWriter, request *http
 		return
 	}
 
-	err = handler.store.CreateUser(RegisterUserPayload{
+	user, err := handler.store.CreateUser(RegisterUserPayload{
 		FirstName: payload.FirstName,
 		LastName:  payload.LastName,
 		Email:     payload.Email,
@@ -200,18 +241,64 @@ func (handler *Handler) handleRegister(writer http.ResponseWriter, request *http
 		utils.WriteError(writer, http.StatusInternalServerError, err)
 		return
 	}
+	handler.audit.Record(request, "user.register", "user", user.ID.String(), nil, user)
+
+	// Bookings made with this email only show up once it is verified. The
+	// account stands even if the email fails; it can be sent again.
+	if err := handler.sendVerification(user.ID, user.Email, "Confirm your email address"); err != nil {
+		log.Printf("failed to email verification to user %s: %v", user.ID, err)
+	}
 
 	utils.WriteJSON(writer, http.StatusCreated, nil)
 }
 
 func (handler *Handler) handleGetAllUsers(writer http.ResponseWriter, request *http.Request) {
-	users, err := handler.store.GetAllUsers()
+	query := request.URL.Query()
+	page := utils.ParseStringToInt(query.Get("page"), 0)
+	perPage := utils.ParseStringToInt(os.Getenv("PER_PAGE"), 10)
+	if page == 0 {
+		page = 1
+	}
+
+	filter := UserFilter{
+		Search: query.Get("q"),
+		Role:   query.Get("role"),
+		SortBy: query.Get("sortBy"),
+		Offset: (page - 1) * perPage,
+		Limit:  perPage,
+	}
+	if verified := query.Get("verified"); verified != "" {
+		value, err := strconv.ParseBool(verified)
+		if err != nil {
+			utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("verified must be true or false"))
+			return
+		}
+		filter.Verified = &value
+	}
+	for param, target := range map[string]**time.Time{"registered_from": &filter.RegisteredFrom, "registered_to": &filter.RegisteredTo} {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+		date, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("%s must be a YYYY-MM-DD date", param))
+			return
+		}
+		if param == "registered_to" {
+			date = date.AddDate(0, 0, 1)
+		}
+		*target = &date
+	}
+
+	users, total, err := handler.store.GetUsers(filter)
 	if err != nil {
 		utils.WriteError(writer, http.StatusInternalServerError, err)
 		return
 	}
 
-	utils.WriteJSON(writer, http.StatusOK, users)
+	pages := int(math.Ceil(float64(total) / float64(perPage)))
+	utils.WriteJSON(writer, http.StatusOK, map[string]any{"results": len(users), "page": page, "pages": pages, "total": total, "data": users})
 }
 
 func (handler *Handler) handleGetSingleUser(writer http.ResponseWriter, request *http.Request) {
@@ -226,15 +313,13 @@ func (handler *Handler) handleGetSingleUser(writer http.ResponseWriter, request
 }
 
 func (handler *Handler) handleGetCurrentUser(writer http.ResponseWriter, request *http.Request) {
-	ctx := request.Context()
-	userObj, ok := ctx.Value(auth.UserKey).(map[string]string)
-	fmt.Println(userObj, ok)
+	principal, ok := auth.PrincipalFromContext(request.Context())
 	if !ok {
 		utils.WriteError(writer, http.StatusUnauthorized, fmt.Errorf("unauthorized"))
 		return
 	}
 
-	user, err := handler.store.GetUserByID(string(userObj["userId"]))
+	user, err := handler.store.GetUserByID(principal.UserID.String())
 	if err != nil {
 		utils.WriteError(writer, http.StatusNotFound, err)
 		return
@@ -245,38 +330,545 @@ func (handler *Handler) handleGetCurrentUser(writer http.ResponseWriter, request
 
 func (handler *Handler) handleUpdateUser(writer http.ResponseWriter, request *http.Request) {
 	id := chi.URLParam(request, "id")
+	var payload UpdateProfilePayload
+	if err := utils.ParseJSON(request, &payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	before, err := handler.store.GetUserByID(id)
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, err)
+		return
+	}
+
+	if err := handler.store.UpdateProfile(id, payload); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
 	user, err := handler.store.GetUserByID(id)
 	if err != nil {
 		utils.WriteError(writer, http.StatusNotFound, err)
 		return
 	}
-	var patch models.User
-	if err := utils.ParseJSON(request, &patch); err != nil {
+	handler.audit.Record(request, "user.update_profile", "user", id, before, user)
+
+	utils.WriteJSON(writer, http.StatusOK, user)
+}
+
+func (handler *Handler) handleChangePassword(writer http.ResponseWriter, request *http.Request) {
+	userID, err := currentUserID(request)
+	if err != nil {
+		utils.WriteError(writer, http.StatusUnauthorized, err)
+		return
+	}
+
+	var payload ChangePasswordPayload
+	if err := utils.ParseJSON(request, &payload); err != nil {
 		utils.WriteError(writer, http.StatusBadRequest, err)
 		return
 	}
-	patch.Password = user.Password
 
-	err = handler.store.UpdateUserInfo(&patch)
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	if payload.NewPassword != payload.ConfirmPassword {
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("password do not match"))
+		return
+	}
+
+	user, err := handler.store.GetUserByID(userID.String())
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, err)
+		return
+	}
+
+	if !auth.VerifyPassword(user.Password, []byte(payload.CurrentPassword)) {
+		utils.WriteError(writer, http.StatusUnauthorized, fmt.Errorf("current password is incorrect"))
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(payload.NewPassword)
 	if err != nil {
 		utils.WriteError(writer, http.StatusInternalServerError, err)
 		return
 	}
 
-	utils.WriteJSON(writer, http.StatusOK, patch)
+	if err := handler.store.UpdatePassword(user.ID.String(), hashedPassword); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	handler.audit.Record(request, "user.change_password", "user", user.ID.String(), nil, nil)
+
+	utils.WriteJSON(writer, http.StatusOK, map[string]string{"message": "password changed"})
 }
 
-func (handler *Handler) handleUserDelete(writer http.ResponseWriter, request *http.Request) {
+// handleRequestEmailChange emails a confirmation link to the new address.
+// The account keeps its current email until that link is used.
+func (handler *Handler) handleRequestEmailChange(writer http.ResponseWriter, request *http.Request) {
+	userID, err := currentUserID(request)
+	if err != nil {
+		utils.WriteError(writer, http.StatusUnauthorized, err)
+		return
+	}
+
+	var payload ChangeEmailPayload
+	if err := utils.ParseJSON(request, &payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	user, err := handler.store.GetUserByID(userID.String())
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, err)
+		return
+	}
+
+	if !auth.VerifyPassword(user.Password, []byte(payload.Password)) {
+		utils.WriteError(writer, http.StatusUnauthorized, fmt.Errorf("password is incorrect"))
+		return
+	}
+
+	userExists, err := handler.store.UserExists(payload.NewEmail)
+	if userExists || err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("user with email address %s already exists", payload.NewEmail))
+		return
+	}
+
+	if err := handler.sendVerification(user.ID, payload.NewEmail, "Confirm your new email address"); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, map[string]string{"message": "verification email sent"})
+}
+
+// handleResendVerification emails a new link to confirm the account's
+// current email address.
+func (handler *Handler) handleResendVerification(writer http.ResponseWriter, request *http.Request) {
+	userID, err := currentUserID(request)
+	if err != nil {
+		utils.WriteError(writer, http.StatusUnauthorized, err)
+		return
+	}
+
+	user, err := handler.store.GetUserByID(userID.String())
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, err)
+		return
+	}
+	if user.EmailVerifiedAt != nil {
+		utils.WriteError(writer, http.StatusConflict, fmt.Errorf("email address is already verified"))
+		return
+	}
+
+	if err := handler.sendVerification(user.ID, user.Email, "Confirm your email address"); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, map[string]string{"message": "verification email sent"})
+}
+
+// sendVerification emails a link that proves the user owns email, making
+// it the account's verified address.
+func (handler *Handler) sendVerification(userID uuid.UUID, email, subject string) error {
+	token, err := auth.CreatePurposeJWT(userID.String(), purposeEmailChange, time.Hour, map[string]string{"email": email})
+	if err != nil {
+		return err
+	}
+
+	return handler.mailer.Send(mailer.Message{
+		To:      email,
+		Subject: subject,
+		Body:    fmt.Sprintf("Use the link below to confirm your email address. It expires in 1 hour.\n\n%s", mailer.Link("/verify-email/"+token)),
+	})
+}
+
+// handleConfirmEmailChange switches the account to the email address in the
+// link and marks it verified. A link for the current address just verifies
+// it.
+func (handler *Handler) handleConfirmEmailChange(writer http.ResponseWriter, request *http.Request) {
+	claims, err := auth.ParseToken(chi.URLParam(request, "token"), purposeEmailChange)
+	if err != nil || claims.Data["email"] == "" {
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid or expired link"))
+		return
+	}
+
+	user, err := handler.store.GetUserByID(claims.Subject)
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, err)
+		return
+	}
+	oldEmail := user.Email
+
+	if err := handler.store.UpdateEmail(user.ID, claims.Data["email"]); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+	if claims.Data["email"] == oldEmail {
+		handler.audit.Record(request, "user.verify_email", "user", user.ID.String(), nil, nil)
+		utils.WriteJSON(writer, http.StatusOK, map[string]string{"message": "email address verified"})
+		return
+	}
+	handler.audit.Record(request, "user.change_email", "user", user.ID.String(),
+		map[string]string{"email": oldEmail}, map[string]string{"email": claims.Data["email"]})
+
+	err = handler.mailer.Send(mailer.Message{
+		To:      oldEmail,
+		Subject: "Your email address was changed",
+		Body:    fmt.Sprintf("The email address on your account was changed to %s. If this was not you, contact us immediately.", claims.Data["email"]),
+	})
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, map[string]string{"message": "email address updated"})
+}
+
+func (handler *Handler) handleAssignRole(writer http.ResponseWriter, request *http.Request) {
 	id := chi.URLParam(request, "id")
+	var payload AssignRolePayload
+	if err := utils.ParseJSON(request, &payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
 	user, err := handler.store.GetUserByID(id)
 	if err != nil {
 		utils.WriteError(writer, http.StatusNotFound, err)
 		return
 	}
-	err = handler.store.DeleteUser(user.ID)
+
+	if err := handler.store.AssignRole(user.ID, models.UserRole(payload.Role)); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+	handler.audit.Record(request, "user.assign_role", "user", user.ID.String(),
+		map[string]any{"role": user.UserRole}, map[string]any{"role": payload.Role})
+
+	utils.WriteJSON(writer, http.StatusOK, map[string]string{"message": "Role Assigned"})
+}
+
+// handleUserDelete moves another user's account to the trash. Users deleting
+// their own account go through the same password check as
+// POST /users/me/deletion and are scheduled for erasure after the grace
+// period instead.
+func (handler *Handler) handleUserDelete(writer http.ResponseWriter, request *http.Request) {
+	id := chi.URLParam(request, "id")
+	principal, _ := auth.PrincipalFromContext(request.Context())
+	if principal.IsUser(id) {
+		handler.handleRequestDeletion(writer, request)
+		return
+	}
+
+	user, err := handler.store.GetUserByID(id)
 	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, err)
+		return
+	}
+
+	if err := handler.store.DeleteUser(user.ID); err != nil {
 		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
 	}
+	handler.audit.Record(request, "user.delete", "user", id, user, nil)
 
 	utils.WriteJSON(writer, http.StatusOK, nil)
 }
+
+func (handler *Handler) handleExportData(writer http.ResponseWriter, request *http.Request) {
+	userID, err := currentUserID(request)
+	if err != nil {
+		utils.WriteError(writer, http.StatusUnauthorized, err)
+		return
+	}
+
+	export, err := handler.store.ExportUserData(userID)
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	if request.URL.Query().Get("format") != "zip" {
+		writer.Header().Set("Content-Disposition", `attachment; filename="mcwale-data.json"`)
+		utils.WriteJSON(writer, http.StatusOK, export)
+		return
+	}
+
+	files := map[string]any{
+		"profile.json":         export.Profile,
+		"addresses.json":       export.Addresses,
+		"orders.json":          export.Orders,
+		"appointments.json":    export.Appointments,
+		"waitlist.json":        export.Waitlist,
+		"measurements.json":    export.Measurements,
+		"custom_orders.json":   export.CustomOrders,
+		"reviews.json":         export.Reviews,
+		"linked_accounts.json": export.Identities,
+		"api_keys.json":        export.APIKeys,
+	}
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+	for name, value := range files {
+		file, err := archive.Create(name)
+		if err != nil {
+			utils.WriteError(writer, http.StatusInternalServerError, err)
+			return
+		}
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(value); err != nil {
+			utils.WriteError(writer, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/zip")
+	writer.Header().Set("Content-Disposition", `attachment; filename="mcwale-data.zip"`)
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(buffer.Bytes())
+}
+
+func (handler *Handler) handleRequestDeletion(writer http.ResponseWriter, request *http.Request) {
+	userID, err := currentUserID(request)
+	if err != nil {
+		utils.WriteError(writer, http.StatusUnauthorized, err)
+		return
+	}
+
+	var payload DeleteAccountPayload
+	if err := utils.ParseJSON(request, &payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	user, err := handler.store.GetUserByID(userID.String())
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, err)
+		return
+	}
+
+	if !auth.VerifyPassword(user.Password, []byte(payload.Password)) {
+		utils.WriteError(writer, http.StatusUnauthorized, fmt.Errorf("password is incorrect"))
+		return
+	}
+
+	scheduledAt, err := handler.scheduleDeletion(user)
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	handler.audit.Record(request, "user.request_deletion", "user", user.ID.String(), nil, map[string]any{"deletion_scheduled_at": scheduledAt})
+
+	utils.WriteJSON(writer, http.StatusAccepted, map[string]any{"deletion_scheduled_at": scheduledAt})
+}
+
+func (handler *Handler) handleCancelDeletion(writer http.ResponseWriter, request *http.Request) {
+	userID, err := currentUserID(request)
+	if err != nil {
+		utils.WriteError(writer, http.StatusUnauthorized, err)
+		return
+	}
+
+	if err := handler.store.CancelDeletion(userID); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	handler.audit.Record(request, "user.cancel_deletion", "user", userID.String(), nil, nil)
+
+	utils.WriteJSON(writer, http.StatusOK, map[string]string{"message": "account deletion cancelled"})
+}
+
+// scheduleDeletion marks the account for erasure once the grace period set by
+// ACCOUNT_DELETION_GRACE_DAYS has passed. Signing in and cancelling before
+// then keeps the account. The deletion stands even if the notice cannot be
+// emailed.
+func (handler *Handler) scheduleDeletion(user *models.User) (time.Time, error) {
+	graceDays := utils.ParseStringToInt(os.Getenv("ACCOUNT_DELETION_GRACE_DAYS"), 14)
+	scheduledAt := time.Now().AddDate(0, 0, graceDays)
+	if err := handler.store.ScheduleDeletion(user.ID, scheduledAt); err != nil {
+		return time.Time{}, err
+	}
+
+	if err := handler.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Your account is scheduled for deletion",
+		Body:    fmt.Sprintf("Your account and personal data will be erased on %s. Sign in and cancel the deletion before then if you change your mind.", scheduledAt.Format("2 January 2006")),
+	}); err != nil {
+		log.Printf("failed to email deletion notice to user %s: %v", user.ID, err)
+	}
+
+	return scheduledAt, nil
+}
+
+func currentUserID(request *http.Request) (uuid.UUID, error) {
+	principal, ok := auth.PrincipalFromContext(request.Context())
+	if !ok || principal.IsAPIKey() {
+		return uuid.Nil, fmt.Errorf("unauthorized")
+	}
+	return principal.UserID, nil
+}
+
+func (handler *Handler) handleGetAddresses(writer http.ResponseWriter, request *http.Request) {
+	userID, err := currentUserID(request)
+	if err != nil {
+		utils.WriteError(writer, http.StatusUnauthorized, err)
+		return
+	}
+
+	addresses, err := handler.store.GetAddresses(userID)
+	if err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, addresses)
+}
+
+func (handler *Handler) handleGetAddress(writer http.ResponseWriter, request *http.Request) {
+	userID, err := currentUserID(request)
+	if err != nil {
+		utils.WriteError(writer, http.StatusUnauthorized, err)
+		return
+	}
+
+	address, err := handler.store.GetAddress(userID, chi.URLParam(request, "addressID"))
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, err)
+		return
+	}
+
+	utils.WriteJSON(writer, http.StatusOK, address)
+}
+
+func (handler *Handler) handleCreateAddress(writer http.ResponseWriter, request *http.Request) {
+	userID, err := currentUserID(request)
+	if err != nil {
+		utils.WriteError(writer, http.StatusUnauthorized, err)
+		return
+	}
+
+	var payload AddressPayload
+	if err := utils.ParseJSON(request, &payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	address := &models.Address{UserID: userID}
+	applyAddressPayload(address, payload)
+	if err := handler.store.CreateAddress(address); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	handler.audit.Record(request, "address.create", "address", address.ID.String(), nil, address)
+
+	utils.WriteJSON(writer, http.StatusCreated, address)
+}
+
+func (handler *Handler) handleUpdateAddress(writer http.ResponseWriter, request *http.Request) {
+	userID, err := currentUserID(request)
+	if err != nil {
+		utils.WriteError(writer, http.StatusUnauthorized, err)
+		return
+	}
+
+	address, err := handler.store.GetAddress(userID, chi.URLParam(request, "addressID"))
+	if err != nil {
+		utils.WriteError(writer, http.StatusNotFound, err)
+		return
+	}
+
+	var payload AddressPayload
+	if err := utils.ParseJSON(request, &payload); err != nil {
+		utils.WriteError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		errors := err.(validator.ValidationErrors)
+		utils.WriteError(writer, http.StatusBadRequest, fmt.Errorf("invalid payload %v", errors))
+		return
+	}
+
+	before := *address
+	applyAddressPayload(address, payload)
+	if err := handler.store.UpdateAddress(address); err != nil {
+		utils.WriteError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	handler.audit.Record(request, "address.update", "address", address.ID.String(), before, address)
+
+	utils.WriteJSON(writer, http.StatusOK, address)
+}
+
+func (handler *Handler) handleDeleteAddress(writer http.ResponseWriter, request *http.Request) {
+	userID, err := currentUserID(request)
+	if err != nil {
+		utils.WriteError(writer, http.StatusUnauthorized, err)
+		return
+	}
+
+	addressID := chi.URLParam(request, "addressID")
+	if err := handler.store.DeleteAddress(userID, addressID); err != nil {
+		utils.WriteError(writer, http.StatusNotFound, err)
+		return
+	}
+	handler.audit.Record(request, "address.delete", "address", addressID, nil, nil)
+
+	utils.WriteJSON(writer, http.StatusNoContent, nil)
+}
+
+func applyAddressPayload(address *models.Address, payload AddressPayload) {
+	address.Label = payload.Label
+	address.FirstName = payload.FirstName
+	address.LastName = payload.LastName
+	address.PhoneNumber = payload.PhoneNumber
+	address.Address1 = payload.Address1
+	address.Address2 = payload.Address2
+	address.Town = payload.Town
+	address.State = payload.State
+	address.Country = payload.Country
+	address.PostalCode = payload.PostalCode
+	address.IsDefaultShipping = payload.IsDefaultShipping
+	address.IsDefaultBilling = payload.IsDefaultBilling
+}
@@ -1,8 +1,12 @@
 package users
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/razdacoder/mcwale-api/models"
+	"github.com/razdacoder/mcwale-api/services/products"
 	"gorm.io/gorm"
 )
 
@@ -24,7 +28,8 @@ func (store *Store) GetUserByEmail(email string) (*models.User, error) {
 
 func (store *Store) UserExists(email string) (bool, error) {
 	var count int64
-	result := store.db.Model(&models.User{}).Where("email = ?", email).Count(&count)
+	// Deleted accounts still hold their email until they are purged.
+	result := store.db.Unscoped().Model(&models.User{}).Where("email = ?", email).Count(&count)
 	return count > 0, result.Error
 }
 
@@ -34,7 +39,7 @@ func (store *Store) GetUserByID(id string) (*models.User, error) {
 	return &user, result.Error
 }
 
-func (store *Store) CreateUser(payload RegisterUserPayload) error {
+func (store *Store) CreateUser(payload RegisterUserPayload) (*models.User, error) {
 	user := &models.User{
 		Firstname: payload.FirstName,
 		Lastname:  payload.LastName,
@@ -42,26 +47,351 @@ func (store *Store) CreateUser(payload RegisterUserPayload) error {
 		Password:  payload.Password,
 	}
 	result := store.db.Create(user)
-	return result.Error
+	return user, result.Error
 }
 
-func (store *Store) GetAllUsers() ([]models.User, error) {
-	var users []models.User
-	result := store.db.Find(&users)
-	return users, result.Error
+func (store *Store) GetUsers(filter UserFilter) ([]UserSummary, int64, error) {
+	var users []UserSummary
+	var total int64
+	orderTotals := store.db.Model(&models.Order{}).
+		Select("user_id, COUNT(*) AS order_count, SUM(total) AS lifetime_spend").
+		Where("user_id IS NOT NULL").
+		Group("user_id")
+	db := store.db.Model(&models.User{}).
+		Select("users.*, COALESCE(totals.order_count, 0) AS order_count, COALESCE(totals.lifetime_spend, 0) AS lifetime_spend").
+		Joins("LEFT JOIN (?) AS totals ON totals.user_id = users.id", orderTotals)
+
+	if filter.Search != "" {
+		search := "%" + filter.Search + "%"
+		db = db.Where("(users.firstname ILIKE ? OR users.lastname ILIKE ? OR users.email ILIKE ? OR CONCAT(users.firstname, ' ', users.lastname) ILIKE ?)",
+			search, search, search, search)
+	}
+	if filter.Role != "" {
+		db = db.Where("users.user_role = ?", filter.Role)
+	}
+	if filter.Verified != nil {
+		if *filter.Verified {
+			db = db.Where("users.email_verified_at IS NOT NULL")
+		} else {
+			db = db.Where("users.email_verified_at IS NULL")
+		}
+	}
+	if filter.RegisteredFrom != nil {
+		db = db.Where("users.created_at >= ?", *filter.RegisteredFrom)
+	}
+	if filter.RegisteredTo != nil {
+		db = db.Where("users.created_at < ?", *filter.RegisteredTo)
+	}
+
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	switch filter.SortBy {
+	case "oldest":
+		db = db.Order("users.created_at ASC")
+	case "name":
+		db = db.Order("users.firstname ASC, users.lastname ASC")
+	case "email":
+		db = db.Order("users.email ASC")
+	case "last_login":
+		db = db.Order("users.last_login_at DESC NULLS LAST")
+	case "order_count":
+		db = db.Order("order_count DESC")
+	case "lifetime_spend":
+		db = db.Order("lifetime_spend DESC")
+	default:
+		db = db.Order("users.created_at DESC")
+	}
+
+	result := db.Offset(filter.Offset).Limit(filter.Limit).Scan(&users)
+	return users, total, result.Error
+}
+
+func (store *Store) RecordLogin(id uuid.UUID) error {
+	results := store.db.Model(&models.User{}).Where("id = ?", id).UpdateColumn("last_login_at", time.Now())
+	return results.Error
 }
 
-func (store *Store) UpdateUserInfo(user *models.User) error {
-	results := store.db.Save(&user)
+func (store *Store) UpdateProfile(id string, payload UpdateProfilePayload) error {
+	updates := map[string]interface{}{}
+	if payload.FirstName != nil {
+		updates["firstname"] = *payload.FirstName
+	}
+	if payload.LastName != nil {
+		updates["lastname"] = *payload.LastName
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	results := store.db.Model(&models.User{}).Where("id = ?", id).Updates(updates)
 	return results.Error
 }
 
+func (store *Store) UpdateEmail(id uuid.UUID, email string) error {
+	return store.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.User{}).Where("email = ? AND id <> ?", email, id).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("user with email address %s already exists", email)
+		}
+		return tx.Model(&models.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"email":             email,
+			"email_verified_at": time.Now(),
+		}).Error
+	})
+}
+
+// DeleteUser moves the user to the trash, from where it can be restored until
+// it is purged.
 func (store *Store) DeleteUser(id uuid.UUID) error {
 	results := store.db.Delete(&models.User{}, id)
 	return results.Error
 }
 
+func (store *Store) ExportUserData(id uuid.UUID) (*UserExport, error) {
+	export := &UserExport{ExportedAt: time.Now()}
+	if err := store.db.Where("id = ?", id).First(&export.Profile).Error; err != nil {
+		return nil, err
+	}
+	email := export.Profile.VerifiedEmail()
+
+	if err := store.db.Where("user_id = ?", id).Find(&export.Addresses).Error; err != nil {
+		return nil, err
+	}
+	if err := ownedBy(store.db, id, email).Preload("Items.Product", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).Find(&export.Orders).Error; err != nil {
+		return nil, err
+	}
+	if email != "" {
+		if err := store.db.Where("email = ?", email).Find(&export.Appointments).Error; err != nil {
+			return nil, err
+		}
+		if err := store.db.Where("email = ?", email).Find(&export.Waitlist).Error; err != nil {
+			return nil, err
+		}
+	}
+	if err := ownedBy(store.db, id, email).Preload("Revisions").Find(&export.Measurements).Error; err != nil {
+		return nil, err
+	}
+	if err := ownedBy(store.db, id, email).Preload("Stages").Find(&export.CustomOrders).Error; err != nil {
+		return nil, err
+	}
+	if err := store.db.Where("user_id = ?", id).Find(&export.Reviews).Error; err != nil {
+		return nil, err
+	}
+	if err := store.db.Where("user_id = ?", id).Find(&export.Identities).Error; err != nil {
+		return nil, err
+	}
+	if err := store.db.Where("created_by_id = ?", id).Find(&export.APIKeys).Error; err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
+
+func (store *Store) ScheduleDeletion(id uuid.UUID, at time.Time) error {
+	results := store.db.Model(&models.User{}).Where("id = ?", id).Update("deletion_scheduled_at", at)
+	return results.Error
+}
+
+func (store *Store) CancelDeletion(id uuid.UUID) error {
+	results := store.db.Model(&models.User{}).Where("id = ?", id).Update("deletion_scheduled_at", nil)
+	return results.Error
+}
+
+func (store *Store) GetUsersDueForErasure(now time.Time) ([]models.User, error) {
+	var users []models.User
+	result := store.db.Where("deletion_scheduled_at IS NOT NULL AND deletion_scheduled_at <= ?", now).Find(&users)
+	return users, result.Error
+}
+
+// EraseUser removes the account and everything tied to it. Orders and
+// appointments are kept for the books but stripped of personal details.
+// Anything matched only by email is left alone unless the email was
+// verified, as it may belong to someone else.
+func (store *Store) EraseUser(id uuid.UUID) error {
+	return store.db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Unscoped().Where("id = ?", id).First(&user).Error; err != nil {
+			return err
+		}
+		email := user.VerifiedEmail()
+
+		err := ownedBy(tx.Unscoped().Model(&models.Order{}), id, email).Updates(map[string]interface{}{
+			"user_id":              nil,
+			"first_name":           "Deleted",
+			"last_name":            "User",
+			"email":                "",
+			"phone_number":         "",
+			"address1":             "",
+			"address2":             "",
+			"town":                 "",
+			"state":                "",
+			"postal_code":          "",
+			"order_note":           "",
+			"shipping_address_id":  nil,
+			"billing_first_name":   "",
+			"billing_last_name":    "",
+			"billing_phone_number": "",
+			"billing_address1":     "",
+			"billing_address2":     "",
+			"billing_town":         "",
+			"billing_state":        "",
+			"billing_postal_code":  "",
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		if email != "" {
+			err = tx.Model(&models.Appointment{}).Where("email = ?", email).Updates(map[string]interface{}{
+				"first_name":   "Deleted",
+				"last_name":    "User",
+				"email":        "",
+				"phone_number": "",
+				"address":      "",
+			}).Error
+			if err != nil {
+				return err
+			}
+
+			if err := tx.Where("email = ?", email).Delete(&models.WaitlistEntry{}).Error; err != nil {
+				return err
+			}
+		}
+
+		err = ownedBy(tx.Model(&models.CustomOrder{}), id, email).Updates(map[string]interface{}{
+			"user_id":      nil,
+			"first_name":   "Deleted",
+			"last_name":    "User",
+			"email":        "",
+			"phone_number": "",
+			"address":      "",
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		if err := ownedBy(tx, id, email).Delete(&models.MeasurementSet{}).Error; err != nil {
+			return err
+		}
+		var reviewed []uuid.UUID
+		if err := tx.Model(&models.ProductReview{}).Where("user_id = ?", id).Pluck("product_id", &reviewed).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", id).Delete(&models.ProductReview{}).Error; err != nil {
+			return err
+		}
+		if err := products.RefreshRatings(tx, reviewed...); err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", id).Delete(&models.Address{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", id).Delete(&models.UserIdentity{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("created_by_id = ?", id).Delete(&models.APIKey{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.StaffMember{}).Where("user_id = ?", id).Update("user_id", nil).Error; err != nil {
+			return err
+		}
+
+		return tx.Unscoped().Delete(&models.User{}, id).Error
+	})
+}
+
 func (store *Store) UpdatePassword(id, password string) error {
 	results := store.db.Model(&models.User{}).Where("id = ?", id).Update("password", password)
 	return results.Error
 }
+
+func (store *Store) AssignRole(id uuid.UUID, role models.UserRole) error {
+	var count int64
+	if err := store.db.Model(&models.Role{}).Where("name = ?", role).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("role %s does not exist", role)
+	}
+	results := store.db.Model(&models.User{}).Where("id = ?", id).Update("user_role", role)
+	return results.Error
+}
+
+func (store *Store) GetAddresses(userID uuid.UUID) ([]models.Address, error) {
+	var addresses []models.Address
+	result := store.db.Where("user_id = ?", userID).Order("created_at ASC").Find(&addresses)
+	return addresses, result.Error
+}
+
+func (store *Store) GetAddress(userID uuid.UUID, id string) (*models.Address, error) {
+	var address models.Address
+	result := store.db.Model(&models.Address{}).Where("id = ? AND user_id = ?", id, userID).First(&address)
+	return &address, result.Error
+}
+
+// clearDefaults unsets the default flags on the user's other addresses when
+// address takes them over, so each user has at most one of each default.
+func clearDefaults(tx *gorm.DB, address *models.Address) error {
+	query := tx.Model(&models.Address{}).Where("user_id = ? AND id <> ?", address.UserID, address.ID)
+	if address.IsDefaultShipping {
+		if err := query.Session(&gorm.Session{}).Update("is_default_shipping", false).Error; err != nil {
+			return err
+		}
+	}
+	if address.IsDefaultBilling {
+		if err := query.Session(&gorm.Session{}).Update("is_default_billing", false).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (store *Store) CreateAddress(address *models.Address) error {
+	return store.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.Address{}).Where("user_id = ?", address.UserID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			address.IsDefaultShipping = true
+			address.IsDefaultBilling = true
+		}
+		if err := tx.Create(address).Error; err != nil {
+			return err
+		}
+		return clearDefaults(tx, address)
+	})
+}
+
+func (store *Store) UpdateAddress(address *models.Address) error {
+	return store.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(address).Error; err != nil {
+			return err
+		}
+		return clearDefaults(tx, address)
+	})
+}
+
+func (store *Store) DeleteAddress(userID uuid.UUID, id string) error {
+	results := store.db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.Address{})
+	if results.Error != nil {
+		return results.Error
+	}
+	if results.RowsAffected == 0 {
+		return fmt.Errorf("address not found")
+	}
+	return nil
+}
+
+// ownedBy matches rows tied to the user's account, or made with their email
+// when it is verified. email is empty otherwise.
+func ownedBy(db *gorm.DB, id uuid.UUID, email string) *gorm.DB {
+	if email == "" {
+		return db.Where("user_id = ?", id)
+	}
+	return db.Where("user_id = ? OR email = ?", id, email)
+}
@@ -1,6 +1,8 @@
 package users
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/razdacoder/mcwale-api/models"
 )
@@ -9,11 +11,23 @@ type UserStore interface {
 	GetUserByEmail(email string) (*models.User, error)
 	UserExists(email string) (bool, error)
 	GetUserByID(id string) (*models.User, error)
-	CreateUser(payload RegisterUserPayload) error
-	GetAllUsers() ([]models.User, error)
-	UpdateUserInfo(user *models.User) error
+	CreateUser(payload RegisterUserPayload) (*models.User, error)
+	GetUsers(filter UserFilter) ([]UserSummary, int64, error)
+	RecordLogin(id uuid.UUID) error
+	UpdateProfile(id string, payload UpdateProfilePayload) error
+	UpdateEmail(id uuid.UUID, email string) error
 	DeleteUser(id uuid.UUID) error
+	ExportUserData(id uuid.UUID) (*UserExport, error)
+	ScheduleDeletion(id uuid.UUID, at time.Time) error
+	CancelDeletion(id uuid.UUID) error
+	EraseUser(id uuid.UUID) error
 	UpdatePassword(id, password string) error
+	AssignRole(id uuid.UUID, role models.UserRole) error
+	GetAddresses(userID uuid.UUID) ([]models.Address, error)
+	GetAddress(userID uuid.UUID, id string) (*models.Address, error)
+	CreateAddress(address *models.Address) error
+	UpdateAddress(address *models.Address) error
+	DeleteAddress(userID uuid.UUID, id string) error
 }
 
 type RegisterUserPayload struct {
@@ -36,3 +50,77 @@ type ResetPasswordConfirmPayload struct {
 	Password        string `json:"password" validate:"required,min=8,max=255"`
 	ConfirmPassword string `json:"confirm_password" validate:"required,min=8,max=255"`
 }
+
+type AssignRolePayload struct {
+	Role string `json:"role" validate:"required"`
+}
+
+type AddressPayload struct {
+	Label             string `json:"label"`
+	FirstName         string `json:"first_name" validate:"required"`
+	LastName          string `json:"last_name" validate:"required"`
+	PhoneNumber       string `json:"phone_number" validate:"required"`
+	Address1          string `json:"address_line_1" validate:"required"`
+	Address2          string `json:"address_line_2"`
+	Town              string `json:"town" validate:"required"`
+	State             string `json:"state" validate:"required"`
+	Country           string `json:"country" validate:"required"`
+	PostalCode        string `json:"postal_code" validate:"required"`
+	IsDefaultShipping bool   `json:"is_default_shipping"`
+	IsDefaultBilling  bool   `json:"is_default_billing"`
+}
+
+type UpdateProfilePayload struct {
+	FirstName *string `json:"first_name" validate:"omitempty,min=1"`
+	LastName  *string `json:"last_name" validate:"omitempty,min=1"`
+}
+
+type ChangePasswordPayload struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,min=8,max=255"`
+	ConfirmPassword string `json:"confirm_password" validate:"required,min=8,max=255"`
+}
+
+type ChangeEmailPayload struct {
+	NewEmail string `json:"new_email" validate:"required,email"`
+	Password string `json:"password" validate:"required"`
+}
+
+type DeleteAccountPayload struct {
+	Password string `json:"password" validate:"required"`
+}
+
+// UserExport is everything held about a user, returned for data subject
+// access requests. Sessions are not listed because access tokens are not
+// stored server side.
+type UserExport struct {
+	Profile      models.User             `json:"profile"`
+	Addresses    []models.Address        `json:"addresses"`
+	Orders       []models.Order          `json:"orders"`
+	Appointments []models.Appointment    `json:"appointments"`
+	Waitlist     []models.WaitlistEntry  `json:"waitlist"`
+	Measurements []models.MeasurementSet `json:"measurements"`
+	CustomOrders []models.CustomOrder    `json:"custom_orders"`
+	Reviews      []models.ProductReview  `json:"reviews"`
+	Identities   []models.UserIdentity   `json:"linked_accounts"`
+	APIKeys      []models.APIKey         `json:"api_keys"`
+	ExportedAt   time.Time               `json:"exported_at"`
+}
+
+type UserFilter struct {
+	Search         string
+	Role           string
+	Verified       *bool
+	RegisteredFrom *time.Time
+	RegisteredTo   *time.Time
+	SortBy         string
+	Offset         int
+	Limit          int
+}
+
+// UserSummary is a user as shown in the back office customer list.
+type UserSummary struct {
+	models.User
+	OrderCount    int64   `json:"order_count"`
+	LifetimeSpend float64 `json:"lifetime_spend"`
+}